@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	errors "golang.org/x/xerrors"
+)
+
+// DigestSettings are when a user who opted in to digest emails gets them.
+type DigestSettings struct {
+	Frequency        pgtype.Varchar // daily or weekly
+	TimeZone         pgtype.Varchar // IANA time zone name
+	Hour             pgtype.Int2    // local hour
+	Weekday          pgtype.Int2    // 0 is Sunday, only for weekly
+	UnsubscribeToken pgtype.Varchar
+	NextSendTime     pgtype.Timestamptz
+	LastSendTime     pgtype.Timestamptz
+}
+
+const saveDigestSettingsSQL = `insert into digest_settings(user_id, frequency, time_zone, hour, weekday, unsubscribe_token, next_send_time)
+values($1, $2, $3, $4, $5, $6, $7)
+on conflict (user_id) do update set
+  frequency=excluded.frequency,
+  time_zone=excluded.time_zone,
+  hour=excluded.hour,
+  weekday=excluded.weekday,
+  next_send_time=excluded.next_send_time`
+
+// SaveDigestSettings opts userID in to digests or changes the schedule. The
+// unsubscribe token of a user who already opted in is kept.
+func SaveDigestSettings(ctx context.Context, db Queryer, userID int32, settings *DigestSettings) error {
+	_, err := prepareExec(ctx, db, "saveDigestSettings", saveDigestSettingsSQL,
+		userID,
+		&settings.Frequency,
+		&settings.TimeZone,
+		&settings.Hour,
+		&settings.Weekday,
+		&settings.UnsubscribeToken,
+		&settings.NextSendTime,
+	)
+	return err
+}
+
+const selectDigestSettingsSQL = `select frequency, time_zone, hour, weekday, unsubscribe_token, next_send_time, last_send_time
+from digest_settings
+where user_id=$1`
+
+func SelectDigestSettings(ctx context.Context, db Queryer, userID int32) (*DigestSettings, error) {
+	var s DigestSettings
+	err := prepareQueryRow(ctx, db, "selectDigestSettings", selectDigestSettingsSQL, userID).Scan(
+		&s.Frequency,
+		&s.TimeZone,
+		&s.Hour,
+		&s.Weekday,
+		&s.UnsubscribeToken,
+		&s.NextSendTime,
+		&s.LastSendTime,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+const deleteDigestSettingsSQL = `delete from digest_settings where user_id=$1`
+
+func DeleteDigestSettings(ctx context.Context, db Queryer, userID int32) error {
+	commandTag, err := prepareExec(ctx, db, "deleteDigestSettings", deleteDigestSettingsSQL, userID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const deleteDigestSettingsByUnsubscribeTokenSQL = `delete from digest_settings where unsubscribe_token=$1`
+
+// DeleteDigestSettingsByUnsubscribeToken opts the user with the unsubscribe
+// token out of digests.
+func DeleteDigestSettingsByUnsubscribeToken(ctx context.Context, db Queryer, token string) error {
+	commandTag, err := prepareExec(ctx, db, "deleteDigestSettingsByUnsubscribeToken", deleteDigestSettingsByUnsubscribeTokenSQL, token)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DueDigest is a digest that is due to be sent to a user.
+type DueDigest struct {
+	UserID   int32
+	Name     string
+	Email    string
+	Settings DigestSettings
+}
+
+const selectDueDigestsSQL = `select users.id,
+  users.name,
+  coalesce(users.email, ''),
+  digest_settings.frequency,
+  digest_settings.time_zone,
+  digest_settings.hour,
+  digest_settings.weekday,
+  digest_settings.unsubscribe_token,
+  digest_settings.next_send_time,
+  digest_settings.last_send_time
+from digest_settings
+  join users on digest_settings.user_id=users.id
+where digest_settings.next_send_time <= $1
+order by digest_settings.next_send_time`
+
+// SelectDueDigests returns the digests whose send time is at or before now.
+func SelectDueDigests(ctx context.Context, db Queryer, now time.Time) ([]DueDigest, error) {
+	digests := make([]DueDigest, 0, 16)
+	rows, _ := prepareQuery(ctx, db, "selectDueDigests", selectDueDigestsSQL, now)
+	for rows.Next() {
+		var d DueDigest
+		rows.Scan(
+			&d.UserID,
+			&d.Name,
+			&d.Email,
+			&d.Settings.Frequency,
+			&d.Settings.TimeZone,
+			&d.Settings.Hour,
+			&d.Settings.Weekday,
+			&d.Settings.UnsubscribeToken,
+			&d.Settings.NextSendTime,
+			&d.Settings.LastSendTime,
+		)
+		digests = append(digests, d)
+	}
+
+	return digests, rows.Err()
+}
+
+// DigestItem is an item listed in a digest.
+type DigestItem struct {
+	ID       int32
+	FeedID   int32
+	FeedName string
+	Title    string
+	URL      string
+}
+
+// selectDigestItemsSQL selects the unread items of unmuted subscriptions that
+// arrived since the user opted in and were not in a previous digest.
+const selectDigestItemsSQL = `select items.id,
+  feeds.id,
+  coalesce(subscriptions.name, feeds.name),
+  items.title,
+  items.url
+from unread_items
+  join subscriptions on unread_items.user_id=subscriptions.user_id and unread_items.feed_id=subscriptions.feed_id
+  join feeds on unread_items.feed_id=feeds.id
+  join items on unread_items.item_id=items.id
+  join digest_settings on unread_items.user_id=digest_settings.user_id
+where unread_items.user_id=$1
+  and not subscriptions.muted
+  and items.creation_time >= digest_settings.creation_time
+  and not exists(select 1 from digested_items where user_id=$1 and item_id=items.id)
+order by lower(coalesce(subscriptions.name, feeds.name)), feeds.id, coalesce(items.publication_time, items.creation_time), items.id
+limit $2`
+
+// SelectDigestItems returns up to limit items for the next digest of userID
+// ordered by feed.
+func SelectDigestItems(ctx context.Context, db Queryer, userID int32, limit int32) ([]DigestItem, error) {
+	items := make([]DigestItem, 0, 16)
+	rows, _ := prepareQuery(ctx, db, "selectDigestItems", selectDigestItemsSQL, userID, limit)
+	for rows.Next() {
+		var item DigestItem
+		rows.Scan(&item.ID, &item.FeedID, &item.FeedName, &item.Title, &item.URL)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+const insertDigestedItemsSQL = `insert into digested_items(user_id, item_id)
+select $1, unnest($2::integer[])
+on conflict do nothing`
+
+const updateDigestSendTimeSQL = `update digest_settings
+set last_send_time=$2,
+  next_send_time=$3
+where user_id=$1`
+
+// RecordDigestSent records that a digest with itemIDs was sent to userID at
+// sendTime and schedules the next one.
+func RecordDigestSent(ctx context.Context, db *pgxpool.Pool, userID int32, itemIDs []int32, sendTime, nextSendTime time.Time) error {
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(itemIDs) > 0 {
+		_, err = tx.Exec(ctx, insertDigestedItemsSQL, userID, itemIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, updateDigestSendTimeSQL, userID, sendTime, nextSendTime)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const scheduleDigestSQL = `update digest_settings set next_send_time=$2 where user_id=$1`
+
+// ScheduleDigest moves the next digest of userID to nextSendTime without
+// recording a digest as sent, e.g. because there was nothing to send.
+func ScheduleDigest(ctx context.Context, db Queryer, userID int32, nextSendTime time.Time) error {
+	_, err := prepareExec(ctx, db, "scheduleDigest", scheduleDigestSQL, userID, nextSendTime)
+	return err
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"io"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	errors "golang.org/x/xerrors"
+)
+
+const deleteFeverAPIKeysSQL = `delete from fever_api_keys where user_id=$1`
+
+const insertFeverAPIKeysSQL = `insert into fever_api_keys(api_key, user_id)
+select unnest($2::varchar[]), $1
+on conflict (api_key) do update set user_id=excluded.user_id`
+
+// SetFeverAPIKeys replaces the Fever API keys of userID with keys. It enables
+// the Fever API for userID.
+func SetFeverAPIKeys(ctx context.Context, db *pgxpool.Pool, userID int32, keys []string) error {
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, deleteFeverAPIKeysSQL, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertFeverAPIKeysSQL, userID, keys)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UpdateFeverAPIKeys replaces the Fever API keys of userID with keys if userID
+// enabled the Fever API by having keys set with SetFeverAPIKeys. It returns
+// whether the keys were replaced.
+func UpdateFeverAPIKeys(ctx context.Context, db *pgxpool.Pool, userID int32, keys []string) (bool, error) {
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	commandTag, err := tx.Exec(ctx, deleteFeverAPIKeysSQL, userID)
+	if err != nil {
+		return false, err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, insertFeverAPIKeysSQL, userID, keys)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// DeleteFeverAPIKeys disables the Fever API for userID.
+func DeleteFeverAPIKeys(ctx context.Context, db Queryer, userID int32) error {
+	_, err := prepareExec(ctx, db, "deleteFeverAPIKeys", deleteFeverAPIKeysSQL, userID)
+	return err
+}
+
+const selectFeverEnabledSQL = `select exists(select 1 from fever_api_keys where user_id=$1)`
+
+// SelectFeverEnabled returns whether userID has enabled the Fever API.
+func SelectFeverEnabled(ctx context.Context, db Queryer, userID int32) (bool, error) {
+	var enabled bool
+	err := prepareQueryRow(ctx, db, "selectFeverEnabled", selectFeverEnabledSQL, userID).Scan(&enabled)
+	return enabled, err
+}
+
+const selectUserIDByFeverAPIKeySQL = `select user_id from fever_api_keys where api_key=$1`
+
+func SelectUserIDByFeverAPIKey(ctx context.Context, db Queryer, key string) (int32, error) {
+	var userID int32
+	err := prepareQueryRow(ctx, db, "selectUserIDByFeverAPIKey", selectUserIDByFeverAPIKeySQL, key).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+const selectLastFetchTimeForUserSQL = `select max(feeds.last_fetch_time)
+from feeds
+  join subscriptions on feeds.id=subscriptions.feed_id
+where subscriptions.user_id=$1`
+
+// SelectLastFetchTimeForUser returns when a feed of userID was last fetched.
+func SelectLastFetchTimeForUser(ctx context.Context, db Queryer, userID int32) (pgtype.Timestamptz, error) {
+	var t pgtype.Timestamptz
+	err := prepareQueryRow(ctx, db, "selectLastFetchTimeForUser", selectLastFetchTimeForUserSQL, userID).Scan(&t)
+	return t, err
+}
+
+const selectUnreadItemIDsSQL = `select unread_items.item_id
+from unread_items
+  join subscriptions on unread_items.user_id=subscriptions.user_id and unread_items.feed_id=subscriptions.feed_id
+where unread_items.user_id=$1
+  and not subscriptions.muted
+order by unread_items.item_id`
+
+// SelectUnreadItemIDs returns the IDs of the unread items of userID that are
+// not from muted subscriptions.
+func SelectUnreadItemIDs(ctx context.Context, db Queryer, userID int32) ([]int32, error) {
+	return selectItemIDs(ctx, db, "selectUnreadItemIDs", selectUnreadItemIDsSQL, userID)
+}
+
+const selectStarredItemIDsSQL = `select item_id from starred_items where user_id=$1 order by item_id`
+
+func SelectStarredItemIDs(ctx context.Context, db Queryer, userID int32) ([]int32, error) {
+	return selectItemIDs(ctx, db, "selectStarredItemIDs", selectStarredItemIDsSQL, userID)
+}
+
+func selectItemIDs(ctx context.Context, db Queryer, name, sql string, userID int32) ([]int32, error) {
+	itemIDs := make([]int32, 0, 64)
+	rows, _ := prepareQuery(ctx, db, name, sql, userID)
+	for rows.Next() {
+		var itemID int32
+		rows.Scan(&itemID)
+		itemIDs = append(itemIDs, itemID)
+	}
+
+	return itemIDs, rows.Err()
+}
+
+// FeverItemQuery selects items the way the Fever API items call does. At most
+// one of SinceID, MaxID, and WithIDs is used, in that order of preference.
+type FeverItemQuery struct {
+	SinceID int32 // items with a greater ID in ascending order
+	MaxID   int32 // items with a lesser ID in descending order
+	WithIDs []int32
+	Limit   int32
+}
+
+// feverItemsFrom are the items a user can read through the Fever API: those of
+// the user's subscriptions and those the user starred.
+const feverItemsFrom = `items
+    left join subscriptions on subscriptions.user_id=$1 and subscriptions.feed_id=items.feed_id
+    left join starred_items on starred_items.user_id=$1 and starred_items.item_id=items.id
+  where (subscriptions.user_id is not null or starred_items.item_id is not null)`
+
+const getFeverItemsSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select items.id,
+    items.feed_id,
+    items.title,
+    coalesce(items.author, '') as author,
+    coalesce(items.content, items.summary, '') as html,
+    items.url,
+    (starred_items.item_id is not null)::integer as is_saved,
+    (not exists(select 1 from unread_items where user_id=$1 and item_id=items.id))::integer as is_read,
+    extract(epoch from coalesce(items.publication_time, items.creation_time))::bigint as created_on_time
+  from ` + feverItemsFrom + `
+    and ($2::integer = 0 or items.id > $2)
+    and ($3::integer = 0 or items.id < $3)
+    and ($4::integer[] is null or items.id=any($4))
+  order by case when $3::integer = 0 then items.id else -items.id end
+  limit $5
+) t`
+
+// CopyFeverItemsAsJSON writes the items of userID selected by query in the
+// format of the Fever API.
+func CopyFeverItemsAsJSON(ctx context.Context, db Queryer, w io.Writer, userID int32, query FeverItemQuery) error {
+	var sinceID, maxID int32
+	var withIDs interface{}
+	switch {
+	case query.SinceID != 0:
+		sinceID = query.SinceID
+	case query.MaxID != 0:
+		maxID = query.MaxID
+	case query.WithIDs != nil:
+		withIDs = query.WithIDs
+	}
+
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getFeverItems", getFeverItemsSQL, userID, sinceID, maxID, withIDs, query.Limit).Scan(&b)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+const countFeverItemsSQL = `select count(*) from ` + feverItemsFrom
+
+// CountFeverItems returns how many items userID can read through the Fever API.
+func CountFeverItems(ctx context.Context, db Queryer, userID int32) (int64, error) {
+	var n int64
+	err := prepareQueryRow(ctx, db, "countFeverItems", countFeverItemsSQL, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	errors "golang.org/x/xerrors"
+)
+
+// FilterRule is applied to new items of feeds the user is subscribed to right
+// after they are inserted. A rule without a FeedID applies to all feeds.
+type FilterRule struct {
+	ID        pgtype.Int4
+	FeedID    pgtype.Int4
+	Field     pgtype.Varchar // title, content, author, or url
+	MatchType pgtype.Varchar // keyword or regex
+	Pattern   pgtype.Varchar
+	Action    pgtype.Varchar // mark_read, star, or tag
+	TagID     pgtype.Int4    // only for the tag action
+}
+
+// InvalidPatternError is returned when a regex filter rule pattern is not a
+// valid PostgreSQL regular expression.
+type InvalidPatternError struct {
+	Message string
+}
+
+func (e InvalidPatternError) Error() string {
+	return e.Message
+}
+
+// MaxFilterRulePatternLength is the longest pattern a filter rule may have.
+const MaxFilterRulePatternLength = 200
+
+const validateRegexSQL = `select '' ~ $1`
+
+const insertFilterRuleSQL = `insert into filter_rules(user_id, feed_id, field, match_type, pattern, action, tag_id)
+select $1, $2, $3, $4, $5, $6, $7
+where ($2::integer is null or exists(select 1 from subscriptions where user_id=$1 and feed_id=$2))
+  and ($7::integer is null or exists(select 1 from tags where user_id=$1 and id=$7))
+returning id`
+
+// CreateFilterRule creates a filter rule for userID. ErrNotFound is returned if
+// the rule is scoped to a feed userID is not subscribed to or uses a tag of
+// another user.
+func CreateFilterRule(ctx context.Context, db Queryer, userID int32, rule *FilterRule) (int32, error) {
+	if len(rule.Pattern.String) > MaxFilterRulePatternLength {
+		return 0, InvalidPatternError{Message: fmt.Sprintf("pattern must not be longer than %d characters", MaxFilterRulePatternLength)}
+	}
+
+	// Validate regexes up front. An invalid one would fail every time the rules
+	// are applied.
+	if rule.MatchType.String == "regex" {
+		_, err := prepareExec(ctx, db, "validateRegex", validateRegexSQL, rule.Pattern.String)
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid regular expression") {
+				return 0, InvalidPatternError{Message: err.Error()}
+			}
+			return 0, err
+		}
+	}
+
+	var ruleID int32
+	err := prepareQueryRow(ctx, db, "insertFilterRule", insertFilterRuleSQL,
+		userID,
+		&rule.FeedID,
+		&rule.Field,
+		&rule.MatchType,
+		&rule.Pattern,
+		&rule.Action,
+		&rule.TagID,
+	).Scan(&ruleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return ruleID, nil
+}
+
+const getFilterRulesForUserSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select filter_rules.id,
+    filter_rules.feed_id,
+    filter_rules.field,
+    filter_rules.match_type,
+    filter_rules.pattern,
+    filter_rules.action,
+    tags.name as tag
+  from filter_rules
+    left join tags on filter_rules.tag_id=tags.id
+  where filter_rules.user_id=$1
+  order by filter_rules.id
+) t`
+
+func CopyFilterRulesForUserAsJSON(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getFilterRulesForUser", getFilterRulesForUserSQL, userID).Scan(&b)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+const deleteFilterRuleSQL = `delete from filter_rules where user_id=$1 and id=$2`
+
+func DeleteFilterRule(ctx context.Context, db Queryer, userID, ruleID int32) error {
+	commandTag, err := prepareExec(ctx, db, "deleteFilterRule", deleteFilterRuleSQL, userID, ruleID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgtype"
+)
+
+type Folder struct {
+	ID   pgtype.Int4
+	Name pgtype.Varchar
+}
+
+const insertFolderSQL = `insert into folders(user_id, name) values($1, $2) returning id`
+
+func CreateFolder(ctx context.Context, db Queryer, userID int32, name string) (int32, error) {
+	var folderID int32
+	err := prepareQueryRow(ctx, db, "insertFolder", insertFolderSQL, userID, name).Scan(&folderID)
+	if err != nil {
+		if strings.Contains(err.Error(), "folders_user_id_name_unq") {
+			return 0, DuplicationError{Field: "name"}
+		}
+		return 0, err
+	}
+
+	return folderID, nil
+}
+
+const selectOrCreateFolderSQL = `with new_folder as (
+  insert into folders(user_id, name)
+  values($1, $2)
+  on conflict (user_id, lower(name)) do nothing
+  returning id
+)
+select id from new_folder
+union all
+select id from folders where user_id=$1 and lower(name)=lower($2)`
+
+// SelectOrCreateFolder returns the ID of the folder of userID called name,
+// creating it if it does not exist yet.
+func SelectOrCreateFolder(ctx context.Context, db Queryer, userID int32, name string) (int32, error) {
+	var folderID int32
+	err := prepareQueryRow(ctx, db, "selectOrCreateFolder", selectOrCreateFolderSQL, userID, name).Scan(&folderID)
+	return folderID, err
+}
+
+const selectFoldersSQL = `select id, name from folders where user_id=$1 order by lower(name)`
+
+func SelectFolders(ctx context.Context, db Queryer, userID int32) ([]Folder, error) {
+	folders := make([]Folder, 0, 8)
+	rows, _ := prepareQuery(ctx, db, "selectFolders", selectFoldersSQL, userID)
+	for rows.Next() {
+		var f Folder
+		rows.Scan(&f.ID, &f.Name)
+		folders = append(folders, f)
+	}
+
+	return folders, rows.Err()
+}
+
+const getFoldersForUserSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select folders.id,
+    folders.name,
+    count(subscriptions.feed_id) as feed_count
+  from folders
+    left join subscriptions on folders.id=subscriptions.folder_id
+  where folders.user_id=$1
+  group by folders.id
+  order by lower(folders.name)
+) t`
+
+func CopyFoldersForUserAsJSON(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getFoldersForUser", getFoldersForUserSQL, userID).Scan(&b)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+const renameFolderSQL = `update folders set name=$3 where user_id=$1 and id=$2`
+
+func RenameFolder(ctx context.Context, db Queryer, userID, folderID int32, name string) error {
+	commandTag, err := prepareExec(ctx, db, "renameFolder", renameFolderSQL, userID, folderID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "folders_user_id_name_unq") {
+			return DuplicationError{Field: "name"}
+		}
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const deleteFolderSQL = `delete from folders where user_id=$1 and id=$2`
+
+// DeleteFolder deletes a folder. Its subscriptions are kept but no longer in
+// any folder.
+func DeleteFolder(ctx context.Context, db Queryer, userID, folderID int32) error {
+	commandTag, err := prepareExec(ctx, db, "deleteFolder", deleteFolderSQL, userID, folderID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const setSubscriptionFolderSQL = `update subscriptions
+set folder_id=$3
+where user_id=$1
+  and feed_id=$2
+  and ($3::integer is null or exists(select 1 from folders where user_id=$1 and id=$3))`
+
+// SetSubscriptionFolder moves the subscription of userID to feedID into
+// folderID. A null folderID removes it from its folder. ErrNotFound is
+// returned if the user has no such subscription or folder.
+func SetSubscriptionFolder(ctx context.Context, db Queryer, userID, feedID int32, folderID pgtype.Int4) error {
+	var folder interface{}
+	if folderID.Status == pgtype.Present {
+		folder = folderID.Int
+	}
+
+	commandTag, err := prepareExec(ctx, db, "setSubscriptionFolder", setSubscriptionFolderSQL, userID, feedID, folder)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
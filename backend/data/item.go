@@ -3,11 +3,14 @@ package data
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -27,22 +30,119 @@ func MarkItemRead(ctx context.Context, db Queryer, userID, itemID int32) error {
 	return nil
 }
 
+// markItemUnreadSQL only marks items from feeds the user is subscribed to.
+// Items that are already unread are counted but not inserted again.
+const markItemUnreadSQL = `with item as (
+  select subscriptions.user_id, items.feed_id, items.id
+  from items
+    join subscriptions on items.feed_id=subscriptions.feed_id
+  where subscriptions.user_id=$1
+    and items.id=$2
+), inserted as (
+  insert into unread_items(user_id, feed_id, item_id)
+  select user_id, feed_id, id from item
+  on conflict do nothing
+)
+select count(*) from item`
+
+func MarkItemUnread(ctx context.Context, db Queryer, userID, itemID int32) error {
+	var n int64
+	err := prepareQueryRow(ctx, db, "markItemUnread", markItemUnreadSQL, userID, itemID).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const markMultipleItemsReadSQL = `delete from unread_items where user_id=$1 and item_id=any($2)`
+
+// MarkMultipleItemsRead marks the items of userID with itemIDs read in a single
+// statement. IDs of items that are not unread are ignored. It returns the
+// number of items marked read.
+func MarkMultipleItemsRead(ctx context.Context, db Queryer, userID int32, itemIDs []int32) (int64, error) {
+	commandTag, err := prepareExec(ctx, db, "markMultipleItemsRead", markMultipleItemsReadSQL, userID, itemIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
+// markMultipleItemsUnreadSQL only marks items from feeds the user is
+// subscribed to.
+const markMultipleItemsUnreadSQL = `insert into unread_items(user_id, feed_id, item_id)
+select subscriptions.user_id, items.feed_id, items.id
+from items
+  join subscriptions on items.feed_id=subscriptions.feed_id
+where subscriptions.user_id=$1
+  and items.id=any($2)
+on conflict do nothing`
+
+// MarkMultipleItemsUnread marks the items of userID with itemIDs unread in a
+// single statement. IDs of items that are already unread or not in a
+// subscribed feed are ignored. It returns the number of items marked unread.
+func MarkMultipleItemsUnread(ctx context.Context, db Queryer, userID int32, itemIDs []int32) (int64, error) {
+	commandTag, err := prepareExec(ctx, db, "markMultipleItemsUnread", markMultipleItemsUnreadSQL, userID, itemIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
+const markItemsReadSQL = `delete from unread_items
+using items, subscriptions
+where unread_items.user_id=$1
+  and unread_items.item_id=items.id
+  and unread_items.user_id=subscriptions.user_id
+  and unread_items.feed_id=subscriptions.feed_id
+  and ($2::integer = 0 or subscriptions.folder_id=$2)
+  and ($3::integer = 0 or unread_items.feed_id=$3)
+  and ($4::timestamptz is null or coalesce(items.publication_time, items.creation_time) < $4)`
+
+// MarkItemsRead marks all unread items of userID that match filter read in a
+// single statement. If before is not zero only items published before it are
+// marked. It returns the number of items marked read.
+func MarkItemsRead(ctx context.Context, db Queryer, userID int32, filter ItemFilter, before time.Time) (int64, error) {
+	var cutoff interface{}
+	if !before.IsZero() {
+		cutoff = before
+	}
+
+	commandTag, err := prepareExec(ctx, db, "markItemsRead", markItemsReadSQL, userID, filter.FolderID, filter.FeedID, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
 const getFeedsForUserSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
 from (
   select feeds.id as feed_id,
-    name,
+    coalesce(subscriptions.name, feeds.name) as name,
+    feeds.name as feed_name,
     feeds.url,
     extract(epoch from last_fetch_time::timestamptz(0)) as last_fetch_time,
     last_failure,
     extract(epoch from last_failure_time::timestamptz(0)) as last_failure_time,
     failure_count,
+    extract(epoch from next_fetch_time::timestamptz(0)) as next_fetch_time,
+    extract(epoch from suspended_time::timestamptz(0)) as suspended_time,
     count(items.id) as item_count,
-    extract(epoch from max(items.publication_time::timestamptz(0))) as last_publication_time
+    extract(epoch from max(items.publication_time::timestamptz(0))) as last_publication_time,
+    subscriptions.folder_id,
+    subscriptions.notes,
+    subscriptions.muted
   from feeds
     join subscriptions on feeds.id=subscriptions.feed_id
     left join items on feeds.id=items.feed_id
   where user_id=$1
-  group by feeds.id
+  group by feeds.id, subscriptions.user_id, subscriptions.feed_id
   order by name
 ) t`
 
@@ -57,25 +157,170 @@ func CopySubscriptionsForUserAsJSON(ctx context.Context, db Queryer, w io.Writer
 	return err
 }
 
-const getUnreadItemsSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
-from (
-  select
-    items.id,
+// ItemFilter restricts which items are returned. The zero value does not
+// filter anything.
+type ItemFilter struct {
+	FolderID int32
+	FeedID   int32
+}
+
+// ItemPage selects a page of an item listing. Listings are ordered by
+// publication time (creation time for items without one) and then by ID, so
+// the position of any item in a listing can be used as a keyset cursor.
+type ItemPage struct {
+	After      ItemCursor // only items after this position in listing order
+	Before     ItemCursor // only items before this position in listing order
+	Limit      int32      // 0 for no limit
+	Descending bool
+}
+
+// ItemCursor is the position of an item in listing order. It does not depend on
+// the item still existing. The zero value is no position.
+type ItemCursor struct {
+	SortTime time.Time
+	ID       int32
+}
+
+func (c ItemCursor) IsZero() bool {
+	return c.ID == 0
+}
+
+// String encodes c as the sort time in Unix microseconds and the ID separated
+// by "_". Item listings include it as the cursor of each item.
+func (c ItemCursor) String() string {
+	return strconv.FormatInt(c.SortTime.UnixNano()/1000, 10) + "_" + strconv.FormatInt(int64(c.ID), 10)
+}
+
+// ParseItemCursor parses a cursor encoded by ItemCursor.String.
+func ParseItemCursor(s string) (ItemCursor, error) {
+	i := strings.LastIndex(s, "_")
+	if i < 0 {
+		return ItemCursor{}, fmt.Errorf("invalid item cursor: %q", s)
+	}
+	usec, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return ItemCursor{}, fmt.Errorf("invalid item cursor: %q", s)
+	}
+	id, err := strconv.ParseInt(s[i+1:], 10, 32)
+	if err != nil || id <= 0 {
+		return ItemCursor{}, fmt.Errorf("invalid item cursor: %q", s)
+	}
+
+	return ItemCursor{SortTime: time.Unix(0, usec*1000), ID: int32(id)}, nil
+}
+
+// itemListColumns are the item attributes returned by all item listings. They
+// expect the page being listed to be joined as items and the user ID to be $1.
+const itemListColumns = `items.id,
     feeds.id as feed_id,
-    feeds.name as feed_name,
+    coalesce(subscriptions.name, feeds.name) as feed_name,
     items.title,
     items.url,
-    extract(epoch from coalesce(publication_time, items.creation_time)::timestamptz(0)) as publication_time
-  from feeds
-    join items on feeds.id=items.feed_id
-    join unread_items on items.id=unread_items.item_id
-  where user_id=$1
-  order by publication_time asc
+    items.author,
+    items.summary,
+    items.content,
+    extract(epoch from page.sort_time::timestamptz(0)) as publication_time,
+    round(extract(epoch from page.sort_time) * 1000000)::bigint || '_' || items.id as cursor,
+    (
+      select coalesce(json_agg(json_build_object(
+        'url', enclosures.url,
+        'type', enclosures.mime_type,
+        'length', enclosures.length,
+        'duration', enclosures.duration,
+        'thumbnail_url', enclosures.thumbnail_url
+      ) order by enclosures.id), '[]'::json)
+      from enclosures
+      where enclosures.item_id=items.id
+    ) as enclosures,
+    (
+      select coalesce(json_agg(tags.name order by lower(tags.name)), '[]'::json)
+      from item_tags
+        join tags on item_tags.tag_id=tags.id
+      where tags.user_id=$1
+        and item_tags.item_id=items.id
+    ) as tags,
+    exists(select 1 from starred_items where starred_items.user_id=$1 and starred_items.item_id=items.id) as starred,
+    (
+      select extract(epoch from starred_items.starred_time::timestamptz(0))
+      from starred_items
+      where starred_items.user_id=$1
+        and starred_items.item_id=items.id
+    ) as starred_time,
+    exists(select 1 from unread_items where unread_items.user_id=$1 and unread_items.item_id=items.id) as unread`
+
+// buildItemPageSQL builds a query that returns the IDs and sort times of one
+// page of the items selected by from and where. The page is not necessarily in
+// listing order. args must already hold the arguments referenced by from and
+// where starting with the user ID. listingOrder is the SQL sort direction of
+// the listing.
+func buildItemPageSQL(from, where string, args *pgx.QueryArgs, page ItemPage) (sql, listingOrder string) {
+	const sortTime = "coalesce(items.publication_time, items.creation_time)"
+
+	listingOrder = "asc"
+	if page.Descending {
+		listingOrder = "desc"
+	}
+	followingOp, precedingOp := ">", "<"
+	if page.Descending {
+		followingOp, precedingOp = "<", ">"
+	}
+
+	if !page.After.IsZero() {
+		where += fmt.Sprintf("\n      and (%s, items.id) %s (%s::timestamptz, %s::integer)", sortTime, followingOp, args.Append(page.After.SortTime), args.Append(page.After.ID))
+	}
+	if !page.Before.IsZero() {
+		where += fmt.Sprintf("\n      and (%s, items.id) %s (%s::timestamptz, %s::integer)", sortTime, precedingOp, args.Append(page.Before.SortTime), args.Append(page.Before.ID))
+	}
+
+	// A page before a cursor is the closest items to the cursor, so it is found
+	// by walking backwards and then put back in listing order.
+	pageOrder := listingOrder
+	if !page.Before.IsZero() && page.After.IsZero() {
+		if page.Descending {
+			pageOrder = "asc"
+		} else {
+			pageOrder = "desc"
+		}
+	}
+
+	limit := ""
+	if page.Limit > 0 {
+		limit = "\n    limit " + args.Append(page.Limit)
+	}
+
+	sql = `select items.id, ` + sortTime + ` as sort_time
+    from ` + from + `
+    where ` + where + `
+    order by sort_time ` + pageOrder + `, items.id ` + pageOrder + limit
+
+	return sql, listingOrder
+}
+
+// buildItemListSQL builds a query that returns one page of the items selected
+// by from and where as a JSON array. args must already hold the arguments
+// referenced by from and where starting with the user ID.
+func buildItemListSQL(from, where string, args *pgx.QueryArgs, page ItemPage) string {
+	pageSQL, listingOrder := buildItemPageSQL(from, where, args, page)
+
+	return `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select
+    ` + itemListColumns + `
+  from (
+    ` + pageSQL + `
+  ) page
+    join items on page.id=items.id
+    join feeds on items.feed_id=feeds.id
+    left join subscriptions on subscriptions.user_id=$1 and subscriptions.feed_id=feeds.id
+  order by page.sort_time ` + listingOrder + `, page.id ` + listingOrder + `
 ) t`
+}
+
+func copyItemListAsJSON(ctx context.Context, db Queryer, w io.Writer, name, from, where string, args pgx.QueryArgs, page ItemPage) error {
+	sql := buildItemListSQL(from, where, &args, page)
 
-func CopyUnreadItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
 	var b []byte
-	err := prepareQueryRow(ctx, db, "getUnreadItems", getUnreadItemsSQL, userID).Scan(&b)
+	err := prepareQueryRow(ctx, db, preparedName(name, sql), sql, args...).Scan(&b)
 	if err != nil {
 		return err
 	}
@@ -84,26 +329,229 @@ func CopyUnreadItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer,
 	return err
 }
 
-const getArchivedItemsSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+const unreadItemsFrom = `unread_items
+      join items on unread_items.item_id=items.id
+      join subscriptions on unread_items.user_id=subscriptions.user_id and unread_items.feed_id=subscriptions.feed_id`
+
+const unreadItemsWhere = `unread_items.user_id=$1
+      and not subscriptions.muted
+      and ($2::integer = 0 or subscriptions.folder_id=$2)
+      and ($3::integer = 0 or unread_items.feed_id=$3)`
+
+func CopyUnreadItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, filter ItemFilter, page ItemPage) error {
+	args := pgx.QueryArgs{userID, filter.FolderID, filter.FeedID}
+	return copyItemListAsJSON(ctx, db, w, "getUnreadItems", unreadItemsFrom, unreadItemsWhere, args, page)
+}
+
+const archivedItemsFrom = `items
+      join subscriptions on items.feed_id=subscriptions.feed_id`
+
+const archivedItemsWhere = `subscriptions.user_id=$1
+      and ($2::integer = 0 or subscriptions.folder_id=$2)
+      and ($3::integer = 0 or items.feed_id=$3)`
+
+func CopyArchivedItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, filter ItemFilter, page ItemPage) error {
+	args := pgx.QueryArgs{userID, filter.FolderID, filter.FeedID}
+	return copyItemListAsJSON(ctx, db, w, "getArchivedItems", archivedItemsFrom, archivedItemsWhere, args, page)
+}
+
+const taggedItemsFrom = `item_tags
+      join tags on item_tags.tag_id=tags.id
+      join items on item_tags.item_id=items.id`
+
+const taggedItemsWhere = `tags.user_id=$1
+      and lower(tags.name)=lower($2)`
+
+// CopyTaggedItemsAsJSONByUserID writes the items userID tagged with tag,
+// including those from feeds the user is no longer subscribed to.
+func CopyTaggedItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, tag string, page ItemPage) error {
+	args := pgx.QueryArgs{userID, tag}
+	return copyItemListAsJSON(ctx, db, w, "getTaggedItems", taggedItemsFrom, taggedItemsWhere, args, page)
+}
+
+// ItemQuery selects items from all the items a user can see: those of the
+// user's subscriptions and those the user starred. Zero values do not restrict
+// the selection. Items of muted subscriptions are only selected by queries for
+// starred or tagged items or for specific ItemIDs.
+type ItemQuery struct {
+	FeedID   int32
+	FolderID int32
+	Unread   bool   // only unread items
+	Read     bool   // only read items
+	Starred  bool   // only starred items
+	Tag      string // only items tagged with this tag
+	ItemIDs  []int32
+	Since    time.Time // only items published at or after
+	Until    time.Time // only items published before
+}
+
+const itemQueryFrom = `items
+      left join subscriptions on subscriptions.user_id=$1 and subscriptions.feed_id=items.feed_id`
+
+// buildItemQueryWhere builds the where clause for query. args must already
+// hold the user ID.
+func buildItemQueryWhere(query ItemQuery, args *pgx.QueryArgs) string {
+	where := "(subscriptions.user_id is not null or exists(select 1 from starred_items where user_id=$1 and item_id=items.id))"
+
+	if query.FeedID != 0 {
+		where += "\n      and items.feed_id=" + args.Append(query.FeedID)
+	}
+	if query.FolderID != 0 {
+		where += "\n      and subscriptions.folder_id=" + args.Append(query.FolderID)
+	}
+	if query.Unread {
+		where += "\n      and exists(select 1 from unread_items where user_id=$1 and item_id=items.id)"
+	}
+	if query.Read {
+		where += "\n      and not exists(select 1 from unread_items where user_id=$1 and item_id=items.id)"
+	}
+	if query.Starred {
+		where += "\n      and exists(select 1 from starred_items where user_id=$1 and item_id=items.id)"
+	}
+	if query.Tag != "" {
+		where += "\n      and exists(select 1 from item_tags join tags on item_tags.tag_id=tags.id where tags.user_id=$1 and lower(tags.name)=lower(" + args.Append(query.Tag) + ") and item_tags.item_id=items.id)"
+	}
+	if query.ItemIDs != nil {
+		where += "\n      and items.id=any(" + args.Append(query.ItemIDs) + "::integer[])"
+	}
+	if !query.Since.IsZero() {
+		where += "\n      and coalesce(items.publication_time, items.creation_time) >= " + args.Append(query.Since)
+	}
+	if !query.Until.IsZero() {
+		where += "\n      and coalesce(items.publication_time, items.creation_time) < " + args.Append(query.Until)
+	}
+	if !query.Starred && query.Tag == "" && query.ItemIDs == nil {
+		where += "\n      and not coalesce(subscriptions.muted, false)"
+	}
+
+	return where
+}
+
+func CopyItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, query ItemQuery, page ItemPage) error {
+	args := pgx.QueryArgs{userID}
+	where := buildItemQueryWhere(query, &args)
+	return copyItemListAsJSON(ctx, db, w, "getItems", itemQueryFrom, where, args, page)
+}
+
+// ItemRef identifies an item in a listing without its content.
+type ItemRef struct {
+	ID       int32
+	FeedID   int32
+	SortTime time.Time // publication time or creation time if unknown
+}
+
+func (r ItemRef) Cursor() ItemCursor {
+	return ItemCursor{SortTime: r.SortTime, ID: r.ID}
+}
+
+// SelectItemRefs returns one page of the items selected by query. It is a
+// lighter version of CopyItemsAsJSONByUserID for when only IDs are needed.
+func SelectItemRefs(ctx context.Context, db Queryer, userID int32, query ItemQuery, page ItemPage) ([]ItemRef, error) {
+	args := pgx.QueryArgs{userID}
+	where := buildItemQueryWhere(query, &args)
+	pageSQL, listingOrder := buildItemPageSQL(itemQueryFrom, where, &args, page)
+
+	sql := `select page.id, items.feed_id, page.sort_time
+from (
+  ` + pageSQL + `
+) page
+  join items on page.id=items.id
+order by page.sort_time ` + listingOrder + `, page.id ` + listingOrder
+
+	refs := make([]ItemRef, 0, 64)
+	rows, _ := prepareQuery(ctx, db, preparedName("selectItemRefs", sql), sql, args...)
+	for rows.Next() {
+		var ref ItemRef
+		rows.Scan(&ref.ID, &ref.FeedID, &ref.SortTime)
+		refs = append(refs, ref)
+	}
+
+	return refs, rows.Err()
+}
+
+// UnreadCount is the number of unread items of a feed.
+type UnreadCount struct {
+	FeedID     int32
+	Count      int64
+	NewestTime time.Time // publication time of the newest unread item
+}
+
+const selectUnreadCountsSQL = `select unread_items.feed_id,
+  count(*),
+  max(coalesce(items.publication_time, items.creation_time))
+from unread_items
+  join items on unread_items.item_id=items.id
+  join subscriptions on unread_items.user_id=subscriptions.user_id and unread_items.feed_id=subscriptions.feed_id
+where unread_items.user_id=$1
+  and not subscriptions.muted
+group by unread_items.feed_id
+order by unread_items.feed_id`
+
+// SelectUnreadCounts returns the unread counts of the feeds of userID that
+// have unread items and are not muted.
+func SelectUnreadCounts(ctx context.Context, db Queryer, userID int32) ([]UnreadCount, error) {
+	counts := make([]UnreadCount, 0, 16)
+	rows, _ := prepareQuery(ctx, db, "selectUnreadCounts", selectUnreadCountsSQL, userID)
+	for rows.Next() {
+		var c UnreadCount
+		rows.Scan(&c.FeedID, &c.Count, &c.NewestTime)
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+// ItemSearch is a full-text search of the items a user can see.
+type ItemSearch struct {
+	Query  string
+	FeedID int32     // 0 for all feeds
+	Since  time.Time // zero for no lower bound on publication time
+	Until  time.Time // zero for no upper bound on publication time
+	Limit  int32
+}
+
+// searchItemsSQL searches the items of the user's subscriptions and the items
+// the user starred. The snippet is HTML with matches in <b> elements. Any < or >
+// left in the content after removing tags is escaped before the matches are
+// marked so the <b> elements are the only elements in the snippet.
+const searchItemsSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
 from (
   select
     items.id,
     feeds.id as feed_id,
-    feeds.name as feed_name,
+    coalesce(subscriptions.name, feeds.name) as feed_name,
     items.title,
     items.url,
-    extract(epoch from coalesce(publication_time, items.creation_time)::timestamptz(0)) as publication_time
-  from feeds
-    join subscriptions on feeds.id=subscriptions.feed_id
-    join items on feeds.id=items.feed_id
-  where user_id=$1
-  order by publication_time desc
-  limit $2
+    items.author,
+    extract(epoch from coalesce(items.publication_time, items.creation_time)::timestamptz(0)) as publication_time,
+    ts_headline('english', replace(replace(html_to_text(coalesce(items.content, items.summary)), '<', '&lt;'), '>', '&gt;'), query, 'MaxFragments=2, MaxWords=30, MinWords=10') as snippet,
+    ts_rank(items.search_vector, query) as rank,
+    starred_items.item_id is not null as starred
+  from plainto_tsquery('english', $2) query,
+    items
+    join feeds on items.feed_id=feeds.id
+    left join subscriptions on subscriptions.user_id=$1 and subscriptions.feed_id=items.feed_id
+    left join starred_items on starred_items.user_id=$1 and starred_items.item_id=items.id
+  where items.search_vector @@ query
+    and (subscriptions.user_id is not null or starred_items.item_id is not null)
+    and ($3::integer = 0 or items.feed_id=$3)
+    and ($4::timestamptz is null or coalesce(items.publication_time, items.creation_time) >= $4)
+    and ($5::timestamptz is null or coalesce(items.publication_time, items.creation_time) < $5)
+  order by rank desc, items.id desc
+  limit $6
 ) t`
 
-func CopyArchivedItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
+func CopySearchResultsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, search ItemSearch) error {
+	var since, until interface{}
+	if !search.Since.IsZero() {
+		since = search.Since
+	}
+	if !search.Until.IsZero() {
+		until = search.Until
+	}
+
 	var b []byte
-	err := prepareQueryRow(ctx, db, "getArchivedItems", getArchivedItemsSQL, userID, 250).Scan(&b)
+	err := prepareQueryRow(ctx, db, "searchItems", searchItemsSQL, userID, search.Query, search.FeedID, since, until, search.Limit).Scan(&b)
 	if err != nil {
 		return err
 	}
@@ -112,10 +560,110 @@ func CopyArchivedItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Write
 	return err
 }
 
+const starredItemsFrom = `starred_items
+      join items on starred_items.item_id=items.id`
+
+const starredItemsWhere = `starred_items.user_id=$1`
+
+// CopyStarredItemsAsJSONByUserID writes the items starred by userID, including
+// those from feeds the user is no longer subscribed to.
+func CopyStarredItemsAsJSONByUserID(ctx context.Context, db Queryer, w io.Writer, userID int32, page ItemPage) error {
+	args := pgx.QueryArgs{userID}
+	return copyItemListAsJSON(ctx, db, w, "getStarredItems", starredItemsFrom, starredItemsWhere, args, page)
+}
+
+// starItemSQL only stars items from feeds the user is subscribed to. Starring
+// an already starred item is a no-op that still counts as one affected row.
+const starItemSQL = `insert into starred_items(user_id, item_id)
+select subscriptions.user_id, items.id
+from items
+  join subscriptions on items.feed_id=subscriptions.feed_id
+where subscriptions.user_id=$1
+  and items.id=$2
+on conflict (user_id, item_id) do update set starred_time=starred_items.starred_time`
+
+func StarItem(ctx context.Context, db Queryer, userID, itemID int32) error {
+	commandTag, err := prepareExec(ctx, db, "starItem", starItemSQL, userID, itemID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const unstarItemSQL = `delete from starred_items
+where user_id=$1
+  and item_id=$2`
+
+func UnstarItem(ctx context.Context, db Queryer, userID, itemID int32) error {
+	commandTag, err := prepareExec(ctx, db, "unstarItem", unstarItemSQL, userID, itemID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// starMultipleItemsSQL only stars items from feeds the user is subscribed to.
+const starMultipleItemsSQL = `insert into starred_items(user_id, item_id)
+select subscriptions.user_id, items.id
+from items
+  join subscriptions on items.feed_id=subscriptions.feed_id
+where subscriptions.user_id=$1
+  and items.id=any($2)
+on conflict do nothing`
+
+// StarMultipleItems stars the items of userID with itemIDs in a single
+// statement. IDs of items that are already starred or not in a subscribed
+// feed are ignored. It returns the number of items starred.
+func StarMultipleItems(ctx context.Context, db Queryer, userID int32, itemIDs []int32) (int64, error) {
+	commandTag, err := prepareExec(ctx, db, "starMultipleItems", starMultipleItemsSQL, userID, itemIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
+const unstarMultipleItemsSQL = `delete from starred_items where user_id=$1 and item_id=any($2)`
+
+// UnstarMultipleItems unstars the items of userID with itemIDs in a single
+// statement. It returns the number of items unstarred.
+func UnstarMultipleItems(ctx context.Context, db Queryer, userID int32, itemIDs []int32) (int64, error) {
+	commandTag, err := prepareExec(ctx, db, "unstarMultipleItems", unstarMultipleItemsSQL, userID, itemIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
+
 type ParsedItem struct {
 	URL             string
 	Title           string
+	Author          string
+	Summary         string // HTML
+	Content         string // HTML
 	PublicationTime pgtype.Timestamptz
+	Enclosures      []ParsedEnclosure
+
+	// BaseURL is the URL relative links in the item are resolved against when
+	// it differs from the item URL (e.g. Atom xml:base). It is not stored.
+	BaseURL string
+}
+
+type ParsedEnclosure struct {
+	URL          string
+	Type         string
+	Length       int64 // bytes, 0 if unknown
+	Duration     int32 // seconds, 0 if unknown
+	ThumbnailURL string
 }
 
 func (i *ParsedItem) IsValid() bool {
@@ -125,6 +673,15 @@ func (i *ParsedItem) IsValid() bool {
 type ParsedFeed struct {
 	Name  string
 	Items []ParsedItem
+
+	// UpdateInterval is how often the publisher says the feed should be
+	// checked (RSS ttl or sy:updatePeriod/sy:updateFrequency). 0 if unknown.
+	UpdateInterval time.Duration
+
+	// HubURL and SelfURL are the WebSub hub and topic the feed advertises with
+	// link rel="hub" and rel="self". Empty if not advertised.
+	HubURL  string
+	SelfURL string
 }
 
 func (f *ParsedFeed) IsValid() bool {
@@ -146,12 +703,15 @@ const updateFeedWithFetchSuccessSQL = `
       set name=$1,
         last_fetch_time=$2,
         etag=$3,
+        last_modified=$4,
+        next_fetch_time=$5,
         last_failure=null,
         last_failure_time=null,
-        failure_count=0
-      where id=$4`
+        failure_count=0,
+        suspended_time=null
+      where id=$6`
 
-func UpdateFeedWithFetchSuccess(ctx context.Context, db *pgxpool.Pool, feedID int32, update *ParsedFeed, etag pgtype.Varchar, fetchTime time.Time) error {
+func UpdateFeedWithFetchSuccess(ctx context.Context, db *pgxpool.Pool, feedID int32, update *ParsedFeed, etag, lastModified pgtype.Varchar, fetchTime, nextFetchTime time.Time) error {
 	tx, err := db.Begin(ctx, nil)
 	if err != nil {
 		return err
@@ -163,54 +723,273 @@ func UpdateFeedWithFetchSuccess(ctx context.Context, db *pgxpool.Pool, feedID in
 		update.Name,
 		fetchTime,
 		&etag,
+		&lastModified,
+		nextFetchTime,
 		feedID)
 	if err != nil {
 		return err
 	}
 
+	var rulesErr error
 	if len(update.Items) > 0 {
+		var newItemIDs []int32
 		insertSQL, insertArgs := buildNewItemsSQL(feedID, update.Items)
-		_, err = tx.Exec(ctx, insertSQL, insertArgs...)
+		err = tx.QueryRow(ctx, insertSQL, insertArgs...).Scan(&newItemIDs)
 		if err != nil {
 			return err
 		}
+
+		if insertSQL, insertArgs := buildNewEnclosuresSQL(feedID, update.Items); insertSQL != "" {
+			_, err = tx.Exec(ctx, insertSQL, insertArgs...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(newItemIDs) > 0 {
+			rulesErr, err = applyFilterRules(ctx, tx, feedID, newItemIDs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return rulesErr
+}
+
+// FilterRulesError is returned by UpdateFeedWithFetchSuccess when the items
+// were saved but the filter rules of some subscribers could not be applied to
+// them, e.g. because a regex was too slow.
+type FilterRulesError struct {
+	UserIDs []int32
+	Err     error
+}
+
+func (e FilterRulesError) Error() string {
+	return fmt.Sprintf("filter rules of users %v failed: %v", e.UserIDs, e.Err)
+}
+
+// filterRulesStatementTimeout limits how long the filter rules of one user
+// may take to run against the new items of one fetch.
+const filterRulesStatementTimeout = "2s"
+
+const selectFilterRuleUserIDsSQL = `select distinct filter_rules.user_id
+from filter_rules
+  join subscriptions on filter_rules.user_id=subscriptions.user_id and subscriptions.feed_id=$1
+where coalesce(filter_rules.feed_id, $1)=$1`
+
+// applyFilterRulesSQL applies the filter rules of user $1 scoped to feed $2 to
+// the new items $3: matching items are made read, are starred, or are tagged.
+const applyFilterRulesSQL = `with matched_rules as (
+  select filter_rules.action, filter_rules.tag_id, items.id as item_id
+  from items
+    cross join filter_rules
+    cross join lateral (
+      select case filter_rules.field
+        when 'title' then items.title
+        when 'content' then html_to_text(concat_ws(' ', items.summary, items.content))
+        when 'author' then items.author
+        when 'url' then items.url
+      end as value
+    ) field
+  where items.id=any($3)
+    and filter_rules.user_id=$1
+    and coalesce(filter_rules.feed_id, $2)=$2
+    and case filter_rules.match_type
+      when 'keyword' then strpos(lower(field.value), lower(filter_rules.pattern)) > 0
+      when 'regex' then field.value ~* filter_rules.pattern
+    end
+),
+starred as (
+  insert into starred_items(user_id, item_id)
+  select distinct $1::integer, item_id
+  from matched_rules
+  where action='star'
+  on conflict do nothing
+),
+tagged as (
+  insert into item_tags(tag_id, item_id)
+  select distinct tag_id, item_id
+  from matched_rules
+  where action='tag'
+  on conflict do nothing
+)
+delete from unread_items
+where user_id=$1
+  and item_id in (select item_id from matched_rules where action='mark_read')`
+
+// applyFilterRules applies the filter rules of each subscriber of feedID to
+// newItemIDs. Each user's rules run in their own savepoint with a statement
+// timeout so a slow or failing rule of one user cannot stop the items from
+// being saved for everyone else. Those failures are returned as a
+// FilterRulesError in rulesErr. err is only set if the transaction itself
+// failed.
+func applyFilterRules(ctx context.Context, tx Queryer, feedID int32, newItemIDs []int32) (rulesErr, err error) {
+	var userIDs []int32
+	rows, _ := tx.Query(ctx, selectFilterRuleUserIDsSQL, feedID)
+	for rows.Next() {
+		var userID int32
+		rows.Scan(&userID)
+		userIDs = append(userIDs, userID)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.Exec(ctx, "set local statement_timeout = '"+filterRulesStatementTimeout+"'")
+	if err != nil {
+		return nil, err
+	}
+
+	var failure FilterRulesError
+	for _, userID := range userIDs {
+		_, err = tx.Exec(ctx, "savepoint apply_filter_rules")
+		if err != nil {
+			return nil, err
+		}
+
+		_, ruleErr := tx.Exec(ctx, applyFilterRulesSQL, userID, feedID, newItemIDs)
+		if ruleErr != nil {
+			_, err = tx.Exec(ctx, "rollback to savepoint apply_filter_rules")
+			if err != nil {
+				return nil, err
+			}
+			failure.UserIDs = append(failure.UserIDs, userID)
+			if failure.Err == nil {
+				failure.Err = ruleErr
+			}
+			continue
+		}
+
+		_, err = tx.Exec(ctx, "release savepoint apply_filter_rules")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "set local statement_timeout = default")
+	if err != nil {
+		return nil, err
 	}
 
-	return tx.Commit(ctx)
+	if len(failure.UserIDs) > 0 {
+		return failure, nil
+	}
+	return nil, nil
 }
 
-const updateFeedWithFetchUnchangedSQL = `update feeds
+// updateFeedWithFetchUnchangedSQL also marks the deleted items of the feed as
+// seen since the feed still lists whatever it listed before.
+const updateFeedWithFetchUnchangedSQL = `with seen_deleted_items as (
+  update deleted_items
+  set last_seen_time=$1
+  where feed_id=$3
+)
+update feeds
 set last_fetch_time=$1,
+  next_fetch_time=$2,
   last_failure=null,
   last_failure_time=null,
-  failure_count=0
-where id=$2`
+  failure_count=0,
+  suspended_time=null
+where id=$3`
 
-func UpdateFeedWithFetchUnchanged(ctx context.Context, db Queryer, feedID int32, fetchTime time.Time) (err error) {
-	_, err = prepareExec(ctx, db, "updateFeedWithFetchUnchanged", updateFeedWithFetchUnchangedSQL, fetchTime, feedID)
+func UpdateFeedWithFetchUnchanged(ctx context.Context, db Queryer, feedID int32, fetchTime, nextFetchTime time.Time) (err error) {
+	_, err = prepareExec(ctx, db, "updateFeedWithFetchUnchanged", updateFeedWithFetchUnchangedSQL, fetchTime, nextFetchTime, feedID)
 	return
 }
 
 const updateFeedWithFetchFailureSQL = `update feeds
 set last_failure=$1,
   last_failure_time=$2,
+  next_fetch_time=$3,
   failure_count=failure_count+1
-where id=$3`
+where id=$4`
 
-func UpdateFeedWithFetchFailure(ctx context.Context, db Queryer, feedID int32, failure string, fetchTime time.Time) (err error) {
-	_, err = prepareExec(ctx, db, "updateFeedWithFetchFailure", updateFeedWithFetchFailureSQL, failure, fetchTime, feedID)
+func UpdateFeedWithFetchFailure(ctx context.Context, db Queryer, feedID int32, failure string, fetchTime, nextFetchTime time.Time) (err error) {
+	_, err = prepareExec(ctx, db, "updateFeedWithFetchFailure", updateFeedWithFetchFailureSQL, failure, fetchTime, nextFetchTime, feedID)
 	return err
 }
 
+const suspendFeedSQL = `update feeds
+set suspended_time=$1
+where id=$2`
+
+// SuspendFeed stops feedID from being fetched until it is retried with
+// RetryFeed or RetryFeedByURL.
+func SuspendFeed(ctx context.Context, db Queryer, feedID int32, suspendTime time.Time) error {
+	commandTag, err := prepareExec(ctx, db, "suspendFeed", suspendFeedSQL, suspendTime, feedID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const retryFeedSQL = `update feeds
+set suspended_time=null,
+  next_fetch_time=$1
+where id=$2
+  and exists(select 1 from subscriptions where feed_id=$2 and user_id=$3)`
+
+// RetryFeed unsuspends feedID and schedules it to be fetched at now. The
+// failure count is kept so a feed that is still broken is suspended again
+// after its next failure. It returns ErrNotFound if userID is not subscribed to
+// feedID.
+func RetryFeed(ctx context.Context, db Queryer, userID, feedID int32, now time.Time) error {
+	commandTag, err := prepareExec(ctx, db, "retryFeed", retryFeedSQL, now, feedID, userID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const retryFeedByURLSQL = `update feeds
+set suspended_time=null,
+  next_fetch_time=$1
+where url=$2`
+
+// RetryFeedByURL is RetryFeed for administrative use. It does not check
+// subscriptions.
+func RetryFeedByURL(ctx context.Context, db Queryer, url string, now time.Time) error {
+	commandTag, err := prepareExec(ctx, db, "retryFeedByURL", retryFeedByURLSQL, now, url)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// buildNewItemsSQL builds a statement that inserts the items that feedID does
+// not have yet and makes them unread for all subscribers. Items that were
+// deleted by DeleteExpiredItems are not inserted again. A delivery of the new
+// items is queued for each webhook of a subscriber whose scope includes the
+// feed. It returns the IDs of the new items.
 func buildNewItemsSQL(feedID int32, items []ParsedItem) (sql string, args []interface{}) {
 	var buf bytes.Buffer
 	args = append(args, feedID)
 
 	buf.WriteString(`
-      with new_items as (
-        insert into items(feed_id, url, title, publication_time)
-        select $1, url, title, publication_time
-        from (values
+      with fetched_items(url, title, author, summary, content, publication_time) as (
+        values
     `)
 
 	for i, item := range items {
@@ -226,6 +1005,17 @@ func buildNewItemsSQL(feedID int32, items []ParsedItem) (sql string, args []inte
 		args = append(args, item.Title)
 		buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
 
+		for _, s := range []string{item.Author, item.Summary, item.Content} {
+			buf.WriteString(",$")
+			if s != "" {
+				args = append(args, s)
+			} else {
+				args = append(args, nil)
+			}
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+			buf.WriteString("::text")
+		}
+
 		buf.WriteString(",$")
 		if item.PublicationTime.Status == pgtype.Present {
 			args = append(args, item.PublicationTime.Time)
@@ -237,36 +1027,168 @@ func buildNewItemsSQL(feedID int32, items []ParsedItem) (sql string, args []inte
 	}
 
 	buf.WriteString(`
-      ) t(url, title, publication_time)
+    ),
+    seen_deleted_items as (
+      update deleted_items
+      set last_seen_time=now()
+      where feed_id=$1
+        and url in (select url from fetched_items)
+    ),
+    new_items as (
+      insert into items(feed_id, url, title, author, summary, content, publication_time)
+      select $1, url, title, author, summary, content, publication_time
+      from fetched_items t
       where not exists(
         select 1
         from items
         where feed_id=$1
           and url=t.url
       )
-      returning id
+        and not exists(
+          select 1
+          from deleted_items
+          where feed_id=$1
+            and url=t.url
+        )
+      returning id, url, title, author, summary, content, publication_time
+    ),
+    queued_webhook_deliveries as (
+      insert into webhook_deliveries(webhook_id, payload)
+      select webhooks.id,
+        json_build_object(
+          'event', 'new_items',
+          'feed', json_build_object('id', feeds.id, 'name', coalesce(subscriptions.name, feeds.name), 'url', feeds.url),
+          'items', json_agg(json_build_object(
+            'id', new_items.id,
+            'url', new_items.url,
+            'title', new_items.title,
+            'author', new_items.author,
+            'summary', new_items.summary,
+            'publication_time', new_items.publication_time
+          ) order by new_items.id)
+        )
+      from webhooks
+        join subscriptions on webhooks.user_id=subscriptions.user_id and subscriptions.feed_id=$1
+        join feeds on feeds.id=$1
+        cross join new_items
+      where coalesce(webhooks.feed_id, $1)=$1
+        and (webhooks.folder_id is null or webhooks.folder_id=subscriptions.folder_id)
+      group by webhooks.id, feeds.id, subscriptions.name
+    ),
+    unread as (
+      insert into unread_items(user_id, feed_id, item_id)
+      select user_id, $1, new_items.id
+      from subscriptions
+        cross join new_items
+      where subscriptions.feed_id=$1
     )
-    insert into unread_items(user_id, feed_id, item_id)
-    select user_id, $1, new_items.id
-    from subscriptions
-      cross join new_items
-    where subscriptions.feed_id=$1
+    select coalesce(array_agg(id), '{}')::integer[]
+    from new_items
+  `)
+
+	return buf.String(), args
+}
+
+// buildNewEnclosuresSQL builds a statement that inserts the enclosures of
+// items that do not have any enclosures yet. It must run after the items
+// themselves have been inserted. sql is empty if items have no enclosures.
+func buildNewEnclosuresSQL(feedID int32, items []ParsedItem) (sql string, args []interface{}) {
+	var buf bytes.Buffer
+	args = append(args, feedID)
+
+	buf.WriteString(`
+      insert into enclosures(item_id, url, mime_type, length, duration, thumbnail_url)
+      select items.id, t.url, t.mime_type, t.length, t.duration, t.thumbnail_url
+      from (values
+    `)
+
+	n := 0
+	for _, item := range items {
+		for _, enclosure := range item.Enclosures {
+			if n > 0 {
+				buf.WriteString(",")
+			}
+			n++
+
+			buf.WriteString("($")
+			args = append(args, item.URL)
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+
+			buf.WriteString(",$")
+			args = append(args, enclosure.URL)
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+
+			buf.WriteString(",$")
+			if enclosure.Type != "" {
+				args = append(args, enclosure.Type)
+			} else {
+				args = append(args, nil)
+			}
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+			buf.WriteString("::varchar")
+
+			buf.WriteString(",$")
+			if enclosure.Length > 0 {
+				args = append(args, enclosure.Length)
+			} else {
+				args = append(args, nil)
+			}
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+			buf.WriteString("::bigint")
+
+			buf.WriteString(",$")
+			if enclosure.Duration > 0 {
+				args = append(args, enclosure.Duration)
+			} else {
+				args = append(args, nil)
+			}
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+			buf.WriteString("::integer")
+
+			buf.WriteString(",$")
+			if enclosure.ThumbnailURL != "" {
+				args = append(args, enclosure.ThumbnailURL)
+			} else {
+				args = append(args, nil)
+			}
+			buf.WriteString(strconv.FormatInt(int64(len(args)), 10))
+			buf.WriteString("::varchar)")
+		}
+	}
+
+	if n == 0 {
+		return "", nil
+	}
+
+	buf.WriteString(`
+      ) t(item_url, url, mime_type, length, duration, thumbnail_url)
+        join items on items.feed_id=$1 and items.url=t.item_url
+      where not exists(
+        select 1
+        from enclosures
+        where item_id=items.id
+      )
   `)
 
 	return buf.String(), args
 }
 
-const getFeedsUncheckedSinceSQL = `select id, url, etag
+const getFeedsDueForFetchSQL = `select id, url, etag, last_modified, last_fetch_time, next_fetch_time, failure_count
 from feeds
-where greatest(last_fetch_time, last_failure_time, '-Infinity'::timestamptz) < $1`
+where coalesce(next_fetch_time, '-Infinity'::timestamptz) <= $1
+  and suspended_time is null
+  and exists(select 1 from subscriptions where feed_id=feeds.id)`
 
-func GetFeedsUncheckedSince(ctx context.Context, db Queryer, since time.Time) ([]Feed, error) {
+// GetFeedsDueForFetch returns the feeds whose next fetch time is at or before
+// now. Feeds that have never been fetched are always due. Suspended feeds and
+// feeds only kept for their starred items are never due.
+func GetFeedsDueForFetch(ctx context.Context, db Queryer, now time.Time) ([]Feed, error) {
 	feeds := make([]Feed, 0, 8)
-	rows, _ := prepareQuery(ctx, db, "getFeedsUncheckedSince", getFeedsUncheckedSinceSQL, since)
+	rows, _ := prepareQuery(ctx, db, "getFeedsDueForFetch", getFeedsDueForFetchSQL, now)
 
 	for rows.Next() {
 		var feed Feed
-		rows.Scan(&feed.ID, &feed.URL, &feed.ETag)
+		rows.Scan(&feed.ID, &feed.URL, &feed.ETag, &feed.LastModified, &feed.LastFetchTime, &feed.NextFetchTime, &feed.FailureCount)
 		feeds = append(feeds, feed)
 	}
 
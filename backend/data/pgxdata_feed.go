@@ -20,6 +20,9 @@ type Feed struct {
   LastFailureTime pgtype.Timestamptz
   FailureCount pgtype.Int4
   CreationTime pgtype.Timestamptz
+  NextFetchTime pgtype.Timestamptz
+  SuspendedTime pgtype.Timestamptz
+  LastModified pgtype.Varchar
 }
 
 const countFeedSQL = `select count(*) from "feeds"`
@@ -39,7 +42,10 @@ const SelectAllFeedSQL = `select
   "last_failure",
   "last_failure_time",
   "failure_count",
-  "creation_time"
+  "creation_time",
+  "next_fetch_time",
+  "suspended_time",
+  "last_modified"
 from "feeds"`
 
 func SelectAllFeed(ctx context.Context, db Queryer) ([]Feed, error) {
@@ -62,6 +68,9 @@ func SelectAllFeed(ctx context.Context, db Queryer) ([]Feed, error) {
     &row.LastFailureTime,
     &row.FailureCount,
     &row.CreationTime,
+    &row.NextFetchTime,
+    &row.SuspendedTime,
+    &row.LastModified,
     )
     rows = append(rows, row)
   }
@@ -82,7 +91,10 @@ const selectFeedByPKSQL = `select
   "last_failure",
   "last_failure_time",
   "failure_count",
-  "creation_time"
+  "creation_time",
+  "next_fetch_time",
+  "suspended_time",
+  "last_modified"
 from "feeds"
 where "id"=$1`
 
@@ -102,6 +114,9 @@ func SelectFeedByPK(
     &row.LastFailureTime,
     &row.FailureCount,
     &row.CreationTime,
+    &row.NextFetchTime,
+    &row.SuspendedTime,
+    &row.LastModified,
     )
   if errors.Is(err, pgx.ErrNoRows) {
     return nil, ErrNotFound
@@ -113,7 +128,7 @@ func SelectFeedByPK(
 }
 
 func InsertFeed(ctx context.Context, db Queryer, row *Feed) error {
-  args := pgx.QueryArgs(make([]interface{}, 0, 9))
+  args := pgx.QueryArgs(make([]interface{}, 0, 12))
 
   var columns, values []string
 
@@ -153,6 +168,18 @@ func InsertFeed(ctx context.Context, db Queryer, row *Feed) error {
     columns = append(columns, `creation_time`)
     values = append(values, args.Append(&row.CreationTime))
   }
+  if row.NextFetchTime.Status != pgtype.Undefined {
+    columns = append(columns, `next_fetch_time`)
+    values = append(values, args.Append(&row.NextFetchTime))
+  }
+  if row.SuspendedTime.Status != pgtype.Undefined {
+    columns = append(columns, `suspended_time`)
+    values = append(values, args.Append(&row.SuspendedTime))
+  }
+  if row.LastModified.Status != pgtype.Undefined {
+    columns = append(columns, `last_modified`)
+    values = append(values, args.Append(&row.LastModified))
+  }
 
 
   sql := `insert into "feeds"(` + strings.Join(columns, ", ") + `)
@@ -169,8 +196,8 @@ func UpdateFeed(ctx context.Context, db Queryer,
   id int32,
   row *Feed,
 ) error {
-  sets := make([]string, 0, 9)
-  args := pgx.QueryArgs(make([]interface{}, 0, 9))
+  sets := make([]string, 0, 12)
+  args := pgx.QueryArgs(make([]interface{}, 0, 12))
 
   if row.ID.Status != pgtype.Undefined {
     sets = append(sets, `id`+"="+args.Append(&row.ID))
@@ -199,6 +226,15 @@ func UpdateFeed(ctx context.Context, db Queryer,
   if row.CreationTime.Status != pgtype.Undefined {
     sets = append(sets, `creation_time`+"="+args.Append(&row.CreationTime))
   }
+  if row.NextFetchTime.Status != pgtype.Undefined {
+    sets = append(sets, `next_fetch_time`+"="+args.Append(&row.NextFetchTime))
+  }
+  if row.SuspendedTime.Status != pgtype.Undefined {
+    sets = append(sets, `suspended_time`+"="+args.Append(&row.SuspendedTime))
+  }
+  if row.LastModified.Status != pgtype.Undefined {
+    sets = append(sets, `last_modified`+"="+args.Append(&row.LastModified))
+  }
 
 
   if len(sets) == 0 {
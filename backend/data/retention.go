@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RetentionPolicy limits how many items are kept for each feed. An item is
+// expired when it is beyond every limit that is set. Unread, starred, and
+// tagged items never expire.
+type RetentionPolicy struct {
+	MaxItemsPerFeed int32         // newest items kept per feed, 0 for no limit
+	MaxItemAge      time.Duration // items created more recently are kept, 0 for no limit
+}
+
+// IsEnabled returns true if the policy expires any items.
+func (p RetentionPolicy) IsEnabled() bool {
+	return p.MaxItemsPerFeed > 0 || p.MaxItemAge > 0
+}
+
+// deletedItemRetention is how long the URL of a deleted item is remembered
+// after its feed stopped listing it. Only time the feed was successfully
+// fetched counts so the URLs of suspended or gone feeds are never forgotten.
+const deletedItemRetention = 30 * 24 * time.Hour
+
+const deleteExpiredItemsSQL = `with ranked_items as (
+  select id,
+    feed_id,
+    url,
+    creation_time,
+    row_number() over (partition by feed_id order by coalesce(publication_time, creation_time) desc, id desc) as position
+  from items
+),
+expired_items as (
+  select id, feed_id, url
+  from ranked_items
+  where ($1::integer = 0 or position > $1)
+    and ($2::timestamptz is null or creation_time < $2)
+    and not exists(select 1 from unread_items where item_id=ranked_items.id)
+    and not exists(select 1 from starred_items where item_id=ranked_items.id)
+    and not exists(select 1 from item_tags where item_id=ranked_items.id)
+),
+new_deleted_items as (
+  insert into deleted_items(feed_id, url)
+  select feed_id, url
+  from expired_items
+  on conflict (feed_id, url) do update set last_seen_time=excluded.last_seen_time
+)
+delete from items
+using expired_items
+where items.id=expired_items.id`
+
+const deleteForgottenDeletedItemsSQL = `delete from deleted_items
+using feeds
+where deleted_items.feed_id=feeds.id
+  and deleted_items.last_seen_time < feeds.last_fetch_time - $1::bigint * interval '1 second'`
+
+// RetentionResult counts what DeleteExpiredItems deleted.
+type RetentionResult struct {
+	Items int64
+}
+
+// DeleteExpiredItems deletes the items that are expired under policy at now.
+// The URLs of deleted items are remembered while their feed still lists them so
+// they are not inserted again as new unread items.
+func DeleteExpiredItems(ctx context.Context, db *pgxpool.Pool, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+
+	tx, err := db.Begin(ctx, &pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx)
+
+	if policy.IsEnabled() {
+		var minCreationTime interface{}
+		if policy.MaxItemAge > 0 {
+			minCreationTime = now.Add(-policy.MaxItemAge)
+		}
+
+		commandTag, err := tx.Exec(ctx, deleteExpiredItemsSQL, policy.MaxItemsPerFeed, minCreationTime)
+		if err != nil {
+			return result, err
+		}
+		result.Items = commandTag.RowsAffected()
+	}
+
+	_, err = tx.Exec(ctx, deleteForgottenDeletedItemsSQL, int64(deletedItemRetention/time.Second))
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit(ctx)
+}
@@ -2,10 +2,13 @@ package data
 
 import (
 	"context"
+	"strings"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	errors "golang.org/x/xerrors"
 )
 
 type Subscription struct {
@@ -18,29 +21,54 @@ type Subscription struct {
 	FailureCount        pgtype.Int4
 	ItemCount           pgtype.Int8
 	LastPublicationTime pgtype.Timestamptz
+	FolderID            pgtype.Int4
+	Notes               pgtype.Text
+	Muted               pgtype.Bool
 }
 
 const createSubscriptionSQL = `select create_subscription($1::integer, $2::varchar)`
 
+// ErrAlreadySubscribed is returned by InsertSubscription when the user is
+// already subscribed to the feed.
+var ErrAlreadySubscribed = errors.New("already subscribed")
+
 func InsertSubscription(ctx context.Context, db Queryer, userID int32, feedURL string) error {
 	_, err := prepareExec(ctx, db, "createSubscription", createSubscriptionSQL, userID, feedURL)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrAlreadySubscribed
+	}
 	return err
 }
 
+const selectFeedIDByURLSQL = `select id from feeds where url=$1`
+
+func SelectFeedIDByURL(ctx context.Context, db Queryer, feedURL string) (int32, error) {
+	var feedID int32
+	err := prepareQueryRow(ctx, db, "selectFeedIDByURL", selectFeedIDByURLSQL, feedURL).Scan(&feedID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return feedID, err
+}
+
 const getSubscriptionsSQL = `select feeds.id as feed_id,
-  name,
+  coalesce(subscriptions.name, feeds.name) as name,
   feeds.url,
   last_fetch_time,
   last_failure,
   last_failure_time,
   failure_count,
   count(items.id) as item_count,
-  max(items.publication_time::timestamptz) as last_publication_time
+  max(items.publication_time::timestamptz) as last_publication_time,
+  subscriptions.folder_id,
+  subscriptions.notes,
+  subscriptions.muted
 from feeds
   join subscriptions on feeds.id=subscriptions.feed_id
   left join items on feeds.id=items.feed_id
 where user_id=$1
-group by feeds.id
+group by feeds.id, subscriptions.user_id, subscriptions.feed_id
 order by name`
 
 func SelectSubscriptions(ctx context.Context, db Queryer, userID int32) ([]Subscription, error) {
@@ -48,17 +76,86 @@ func SelectSubscriptions(ctx context.Context, db Queryer, userID int32) ([]Subsc
 	rows, _ := prepareQuery(ctx, db, "getSubscriptions", getSubscriptionsSQL, userID)
 	for rows.Next() {
 		var s Subscription
-		rows.Scan(&s.FeedID, &s.Name, &s.URL, &s.LastFetchTime, &s.LastFailure, &s.LastFailureTime, &s.FailureCount, &s.ItemCount, &s.LastPublicationTime)
+		rows.Scan(&s.FeedID, &s.Name, &s.URL, &s.LastFetchTime, &s.LastFailure, &s.LastFailureTime, &s.FailureCount, &s.ItemCount, &s.LastPublicationTime, &s.FolderID, &s.Notes, &s.Muted)
 		subs = append(subs, s)
 	}
 
 	return subs, rows.Err()
 }
 
+// SubscriptionSettings are a user's own settings for a subscribed feed. Fields
+// that are Undefined are left unchanged by UpdateSubscription. A Null Name
+// falls back to the feed's own name. A Null FolderID removes the subscription
+// from its folder.
+type SubscriptionSettings struct {
+	Name     pgtype.Varchar
+	Notes    pgtype.Text
+	Muted    pgtype.Bool
+	FolderID pgtype.Int4
+}
+
+// UpdateSubscription changes the settings of the subscription of userID to
+// feedID in a single statement. ErrNotFound is returned and nothing is changed
+// if the user has no such subscription or the FolderID is not one of the
+// user's folders.
+func UpdateSubscription(ctx context.Context, db Queryer, userID, feedID int32, settings *SubscriptionSettings) error {
+	sets := make([]string, 0, 4)
+	args := pgx.QueryArgs(make([]interface{}, 0, 6))
+	folderCheck := ""
+
+	if settings.Name.Status != pgtype.Undefined {
+		sets = append(sets, "name="+args.Append(&settings.Name))
+	}
+	if settings.Notes.Status != pgtype.Undefined {
+		sets = append(sets, "notes="+args.Append(&settings.Notes))
+	}
+	if settings.Muted.Status != pgtype.Undefined {
+		sets = append(sets, "muted="+args.Append(&settings.Muted))
+	}
+	if settings.FolderID.Status == pgtype.Present {
+		folderID := args.Append(settings.FolderID.Int)
+		sets = append(sets, "folder_id="+folderID)
+		folderCheck = " and exists(select 1 from folders where user_id=subscriptions.user_id and id=" + folderID + ")"
+	} else if settings.FolderID.Status == pgtype.Null {
+		sets = append(sets, "folder_id=null")
+	}
+
+	if len(sets) == 0 {
+		return nil
+	}
+
+	sql := "update subscriptions set " + strings.Join(sets, ", ") + " where user_id=" + args.Append(userID) + " and feed_id=" + args.Append(feedID) + folderCheck
+
+	commandTag, err := prepareExec(ctx, db, preparedName("updateSubscription", sql), sql, args...)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
 const deleteSubscriptionSQL = `delete from subscriptions where user_id=$1 and feed_id=$2`
 const deleteFeedIfOrphanedSQL = `delete from feeds
 where id=$1
-  and not exists(select 1 from subscriptions where feed_id=id)`
+  and not exists(select 1 from subscriptions where feed_id=id)
+  and not exists(
+    select 1
+    from starred_items
+      join items on starred_items.item_id=items.id
+    where items.feed_id=feeds.id
+  )
+  and not exists(
+    select 1
+    from item_tags
+      join items on item_tags.item_id=items.id
+    where items.feed_id=feeds.id
+  )`
+
+const deleteFeedFilterRulesSQL = `delete from filter_rules where user_id=$1 and feed_id=$2`
+const deleteFeedWebhooksSQL = `delete from webhooks where user_id=$1 and feed_id=$2`
 
 func DeleteSubscription(ctx context.Context, db *pgxpool.Pool, userID, feedID int32) error {
 	tx, err := db.Begin(ctx, &pgx.TxOptions{IsoLevel: pgx.Serializable})
@@ -72,6 +169,16 @@ func DeleteSubscription(ctx context.Context, db *pgxpool.Pool, userID, feedID in
 		return err
 	}
 
+	_, err = tx.Exec(ctx, deleteFeedFilterRulesSQL, userID, feedID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, deleteFeedWebhooksSQL, userID, feedID)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(ctx, deleteFeedIfOrphanedSQL, feedID)
 	if err != nil {
 		return err
@@ -79,3 +186,154 @@ func DeleteSubscription(ctx context.Context, db *pgxpool.Pool, userID, feedID in
 
 	return tx.Commit(ctx)
 }
+
+const selectFeedIDByURLForUpdateSQL = `select id from feeds where url=$1 for update`
+const updateFeedURLSQL = `update feeds set url=$1 where id=$2`
+
+const mergeSubscriptionsSQL = `insert into subscriptions(user_id, feed_id, folder_id, name, notes, muted)
+select user_id, $2, folder_id, name, notes, muted
+from subscriptions
+where feed_id=$1
+  and not exists(
+    select 1
+    from subscriptions s
+    where s.user_id=subscriptions.user_id
+      and s.feed_id=$2
+  )`
+
+const mergeItemsSQL = `insert into items(feed_id, url, title, author, summary, content, publication_time, creation_time)
+select $2, items.url, items.title, items.author, items.summary, items.content, items.publication_time, items.creation_time
+from items
+where items.feed_id=$1
+  and (
+    exists(select 1 from unread_items where item_id=items.id)
+    or exists(select 1 from starred_items where item_id=items.id)
+    or exists(select 1 from item_tags where item_id=items.id)
+  )
+  and not exists(
+    select 1
+    from items target
+    where target.feed_id=$2
+      and target.url=items.url
+  )`
+
+const mergeEnclosuresSQL = `insert into enclosures(item_id, url, mime_type, length, duration, thumbnail_url)
+select target.id, enclosures.url, enclosures.mime_type, enclosures.length, enclosures.duration, enclosures.thumbnail_url
+from enclosures
+  join items on enclosures.item_id=items.id
+  join items target on target.feed_id=$2 and target.url=items.url
+where items.feed_id=$1
+  and not exists(
+    select 1
+    from enclosures e
+    where e.item_id=target.id
+  )`
+
+const mergeUnreadItemReferencesSQL = `insert into unread_items(user_id, feed_id, item_id)
+select unread_items.user_id, $2, target.id
+from unread_items
+  join items on unread_items.item_id=items.id
+  join items target on target.feed_id=$2 and target.url=items.url
+where unread_items.feed_id=$1
+  and not exists(
+    select 1
+    from unread_items u
+    where u.user_id=unread_items.user_id
+      and u.item_id=target.id
+  )`
+
+const mergeStarredItemsSQL = `insert into starred_items(user_id, item_id, starred_time)
+select starred_items.user_id, target.id, starred_items.starred_time
+from starred_items
+  join items on starred_items.item_id=items.id
+  join items target on target.feed_id=$2 and target.url=items.url
+where items.feed_id=$1
+on conflict do nothing`
+
+const deleteMergedStarredItemsSQL = `delete from starred_items
+using items
+where starred_items.item_id=items.id
+  and items.feed_id=$1`
+
+const mergeItemTagsSQL = `insert into item_tags(tag_id, item_id)
+select item_tags.tag_id, target.id
+from item_tags
+  join items on item_tags.item_id=items.id
+  join items target on target.feed_id=$2 and target.url=items.url
+where items.feed_id=$1
+on conflict do nothing`
+
+const deleteMergedItemTagsSQL = `delete from item_tags
+using items
+where item_tags.item_id=items.id
+  and items.feed_id=$1`
+
+const mergeDigestedItemsSQL = `insert into digested_items(user_id, item_id)
+select digested_items.user_id, target.id
+from digested_items
+  join items on digested_items.item_id=items.id
+  join items target on target.feed_id=$2 and target.url=items.url
+where items.feed_id=$1
+on conflict do nothing`
+
+const mergeDeletedItemsSQL = `insert into deleted_items(feed_id, url, last_seen_time)
+select $2, url, last_seen_time
+from deleted_items
+where feed_id=$1
+on conflict do nothing`
+
+const mergeFilterRulesSQL = `update filter_rules set feed_id=$2 where feed_id=$1`
+
+const mergeWebhooksSQL = `update webhooks set feed_id=$2 where feed_id=$1`
+
+// UpdateFeedURL changes the URL of feedID to url, e.g. because the feed has
+// permanently moved. If another feed already has url, feedID is merged into it:
+// its subscriptions, filter rules, webhooks, and unread, starred, and tagged
+// items are moved to the other feed and feedID is deleted. The ID of the feed
+// that now has url is returned.
+func UpdateFeedURL(ctx context.Context, db *pgxpool.Pool, feedID int32, url string) (int32, error) {
+	tx, err := db.Begin(ctx, &pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var targetID int32
+	err = tx.QueryRow(ctx, selectFeedIDByURLForUpdateSQL, url).Scan(&targetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		_, err = tx.Exec(ctx, updateFeedURLSQL, url, feedID)
+		if err != nil {
+			return 0, err
+		}
+
+		return feedID, tx.Commit(ctx)
+	} else if err != nil {
+		return 0, err
+	}
+
+	if targetID == feedID {
+		return feedID, nil
+	}
+
+	for _, sql := range []string{mergeSubscriptionsSQL, mergeItemsSQL, mergeEnclosuresSQL, mergeUnreadItemReferencesSQL, mergeStarredItemsSQL, mergeItemTagsSQL, mergeDigestedItemsSQL, mergeDeletedItemsSQL, mergeFilterRulesSQL, mergeWebhooksSQL} {
+		_, err = tx.Exec(ctx, sql, feedID, targetID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Starred and tagged items would otherwise keep the merged feed's items from being deleted
+	for _, sql := range []string{deleteMergedStarredItemsSQL, deleteMergedItemTagsSQL} {
+		_, err = tx.Exec(ctx, sql, feedID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "delete from feeds where id=$1", feedID)
+	if err != nil {
+		return 0, err
+	}
+
+	return targetID, tx.Commit(ctx)
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"io"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const selectOrCreateTagSQL = `with new_tag as (
+  insert into tags(user_id, name)
+  values($1, $2)
+  on conflict (user_id, lower(name)) do nothing
+  returning id
+)
+select id from new_tag
+union all
+select id from tags where user_id=$1 and lower(name)=lower($2)`
+
+// SelectOrCreateTag returns the ID of the tag of userID called name, creating
+// it if it does not exist yet.
+func SelectOrCreateTag(ctx context.Context, db Queryer, userID int32, name string) (int32, error) {
+	var tagID int32
+	err := prepareQueryRow(ctx, db, "selectOrCreateTag", selectOrCreateTagSQL, userID, name).Scan(&tagID)
+	return tagID, err
+}
+
+const getTagsForUserSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select tags.id,
+    tags.name,
+    count(item_tags.item_id) as item_count
+  from tags
+    left join item_tags on tags.id=item_tags.tag_id
+  where tags.user_id=$1
+  group by tags.id
+  order by lower(tags.name)
+) t`
+
+func CopyTagsForUserAsJSON(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getTagsForUser", getTagsForUserSQL, userID).Scan(&b)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+const selectTagNamesSQL = `select name from tags where user_id=$1 order by lower(name)`
+
+func SelectTagNames(ctx context.Context, db Queryer, userID int32) ([]string, error) {
+	names := make([]string, 0, 16)
+	rows, _ := prepareQuery(ctx, db, "selectTagNames", selectTagNamesSQL, userID)
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+const deleteTagSQL = `delete from tags where user_id=$1 and id=$2`
+
+// DeleteTag deletes a tag. It is removed from all items and filter rules that
+// tag items with it are deleted.
+func DeleteTag(ctx context.Context, db Queryer, userID, tagID int32) error {
+	commandTag, err := prepareExec(ctx, db, "deleteTag", deleteTagSQL, userID, tagID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// tagItemsSQL only tags items from feeds the user is subscribed to or that the
+// user already starred or tagged.
+const tagItemsSQL = `insert into item_tags(tag_id, item_id)
+select tags.id, items.id
+from tags
+  cross join items
+where tags.user_id=$1
+  and tags.id=any($2::integer[])
+  and items.id=any($3::integer[])
+  and (
+    exists(select 1 from subscriptions where user_id=$1 and feed_id=items.feed_id)
+    or exists(select 1 from starred_items where user_id=$1 and item_id=items.id)
+    or exists(select 1 from item_tags join tags t on item_tags.tag_id=t.id where t.user_id=$1 and item_tags.item_id=items.id)
+  )
+on conflict do nothing`
+
+// TagItems adds the tags called names to itemIDs for userID. Tags that do not
+// exist yet are created. Items the user cannot see are skipped.
+func TagItems(ctx context.Context, db *pgxpool.Pool, userID int32, itemIDs []int32, names []string) error {
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tagIDs := make([]int32, 0, len(names))
+	for _, name := range names {
+		tagID, err := SelectOrCreateTag(ctx, tx, userID, name)
+		if err != nil {
+			return err
+		}
+		tagIDs = append(tagIDs, tagID)
+	}
+
+	_, err = tx.Exec(ctx, tagItemsSQL, userID, tagIDs, itemIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+const untagItemsSQL = `delete from item_tags
+using tags
+where item_tags.tag_id=tags.id
+  and tags.user_id=$1
+  and lower(tags.name) in (select lower(unnest($2::varchar[])))
+  and item_tags.item_id=any($3::integer[])`
+
+// UntagItems removes the tags called names from itemIDs for userID. The tags
+// themselves are kept.
+func UntagItems(ctx context.Context, db Queryer, userID int32, itemIDs []int32, names []string) error {
+	_, err := prepareExec(ctx, db, "untagItems", untagItemsSQL, userID, names, itemIDs)
+	return err
+}
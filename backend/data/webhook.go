@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	errors "golang.org/x/xerrors"
+)
+
+// Webhook receives a signed POST describing the new items of each fetch of the
+// feeds in its scope. A webhook without a FeedID or FolderID applies to all
+// feeds the user is subscribed to.
+type Webhook struct {
+	ID       pgtype.Int4
+	URL      pgtype.Varchar
+	Secret   pgtype.Varchar
+	FeedID   pgtype.Int4
+	FolderID pgtype.Int4
+}
+
+const insertWebhookSQL = `insert into webhooks(user_id, url, secret, feed_id, folder_id)
+select $1, $2, $3, $4, $5
+where ($4::integer is null or exists(select 1 from subscriptions where user_id=$1 and feed_id=$4))
+  and ($5::integer is null or exists(select 1 from folders where user_id=$1 and id=$5))
+returning id`
+
+// CreateWebhook creates a webhook for userID. ErrNotFound is returned if the
+// webhook is scoped to a feed userID is not subscribed to or a folder of
+// another user.
+func CreateWebhook(ctx context.Context, db Queryer, userID int32, webhook *Webhook) (int32, error) {
+	var webhookID int32
+	err := prepareQueryRow(ctx, db, "insertWebhook", insertWebhookSQL,
+		userID,
+		&webhook.URL,
+		&webhook.Secret,
+		&webhook.FeedID,
+		&webhook.FolderID,
+	).Scan(&webhookID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return webhookID, nil
+}
+
+const getWebhooksForUserSQL = `select coalesce(json_agg(row_to_json(t)), '[]'::json)
+from (
+  select id,
+    url,
+    feed_id,
+    folder_id,
+    creation_time
+  from webhooks
+  where user_id=$1
+  order by id
+) t`
+
+// CopyWebhooksForUserAsJSON writes the webhooks of userID without their
+// secrets.
+func CopyWebhooksForUserAsJSON(ctx context.Context, db Queryer, w io.Writer, userID int32) error {
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getWebhooksForUser", getWebhooksForUserSQL, userID).Scan(&b)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+const deleteWebhookSQL = `delete from webhooks where user_id=$1 and id=$2`
+
+func DeleteWebhook(ctx context.Context, db Queryer, userID, webhookID int32) error {
+	commandTag, err := prepareExec(ctx, db, "deleteWebhook", deleteWebhookSQL, userID, webhookID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const getWebhookDeliveriesSQL = `select case when exists(select 1 from webhooks where user_id=$1 and id=$2) then
+  coalesce((
+    select json_agg(row_to_json(t))
+    from (
+      select id,
+        payload,
+        status,
+        attempt_count,
+        next_attempt_time,
+        last_attempt_time,
+        response_status,
+        last_failure,
+        creation_time
+      from webhook_deliveries
+      where webhook_id=$2
+      order by id desc
+      limit $3
+    ) t
+  ), '[]'::json)
+end`
+
+// CopyWebhookDeliveriesAsJSON writes the most recent deliveries of webhookID,
+// newest first. ErrNotFound is returned if userID does not have webhookID.
+func CopyWebhookDeliveriesAsJSON(ctx context.Context, db Queryer, w io.Writer, userID, webhookID, limit int32) error {
+	var b []byte
+	err := prepareQueryRow(ctx, db, "getWebhookDeliveries", getWebhookDeliveriesSQL, userID, webhookID, limit).Scan(&b)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return ErrNotFound
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// WebhookDelivery is a pending POST of Payload to URL.
+type WebhookDelivery struct {
+	ID           int64
+	WebhookID    int32
+	URL          string
+	Secret       string
+	Payload      []byte
+	AttemptCount int32
+}
+
+const selectDueWebhookDeliveriesSQL = `select webhook_deliveries.id,
+  webhooks.id,
+  webhooks.url,
+  webhooks.secret,
+  webhook_deliveries.payload::text,
+  webhook_deliveries.attempt_count
+from webhook_deliveries
+  join webhooks on webhook_deliveries.webhook_id=webhooks.id
+where webhook_deliveries.status='pending'
+  and webhook_deliveries.next_attempt_time <= $1
+order by webhook_deliveries.next_attempt_time, webhook_deliveries.id
+limit $2`
+
+// SelectDueWebhookDeliveries returns up to limit pending deliveries that are
+// due at now, the longest waiting first.
+func SelectDueWebhookDeliveries(ctx context.Context, db Queryer, now time.Time, limit int32) ([]WebhookDelivery, error) {
+	deliveries := make([]WebhookDelivery, 0, 16)
+	rows, _ := prepareQuery(ctx, db, "selectDueWebhookDeliveries", selectDueWebhookDeliveriesSQL, now, limit)
+	for rows.Next() {
+		var d WebhookDelivery
+		rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Payload, &d.AttemptCount)
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+const recordWebhookDeliverySuccessSQL = `update webhook_deliveries
+set status='delivered',
+  attempt_count=attempt_count+1,
+  next_attempt_time=null,
+  last_attempt_time=$2,
+  response_status=$3,
+  last_failure=null
+where id=$1`
+
+func RecordWebhookDeliverySuccess(ctx context.Context, db Queryer, deliveryID int64, attemptTime time.Time, responseStatus int32) error {
+	_, err := prepareExec(ctx, db, "recordWebhookDeliverySuccess", recordWebhookDeliverySuccessSQL, deliveryID, attemptTime, responseStatus)
+	return err
+}
+
+const recordWebhookDeliveryFailureSQL = `update webhook_deliveries
+set status=case when $3::timestamptz is null then 'failed' else 'pending' end,
+  attempt_count=attempt_count+1,
+  next_attempt_time=$3,
+  last_attempt_time=$2,
+  response_status=$4,
+  last_failure=$5
+where id=$1`
+
+// RecordWebhookDeliveryFailure records a failed attempt of deliveryID. It is
+// attempted again at nextAttemptTime or given up if that is Null.
+// responseStatus is Null if there was no response.
+func RecordWebhookDeliveryFailure(ctx context.Context, db Queryer, deliveryID int64, attemptTime time.Time, nextAttemptTime pgtype.Timestamptz, responseStatus pgtype.Int4, failure string) error {
+	_, err := prepareExec(ctx, db, "recordWebhookDeliveryFailure", recordWebhookDeliveryFailureSQL, deliveryID, attemptTime, &nextAttemptTime, &responseStatus, failure)
+	return err
+}
+
+const deleteOldWebhookDeliveriesSQL = `delete from webhook_deliveries where status<>'pending' and creation_time < $1`
+
+// DeleteOldWebhookDeliveries deletes delivered and failed deliveries created
+// before t from the delivery log.
+func DeleteOldWebhookDeliveries(ctx context.Context, db Queryer, t time.Time) (int64, error) {
+	commandTag, err := prepareExec(ctx, db, "deleteOldWebhookDeliveries", deleteOldWebhookDeliveriesSQL, t)
+	if err != nil {
+		return 0, err
+	}
+
+	return commandTag.RowsAffected(), nil
+}
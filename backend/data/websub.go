@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	errors "golang.org/x/xerrors"
+)
+
+// WebSubSubscription is a subscription to the WebSub hub of a feed. It is
+// pending until the hub verifies it and active until its lease expires.
+type WebSubSubscription struct {
+	FeedID              pgtype.Int4
+	HubURL              pgtype.Varchar
+	TopicURL            pgtype.Varchar
+	Secret              pgtype.Varchar
+	RequestTime         pgtype.Timestamptz
+	LeaseExpirationTime pgtype.Timestamptz
+}
+
+// IsActive returns true if the hub verified the subscription and its lease has
+// not expired at now.
+func (s *WebSubSubscription) IsActive(now time.Time) bool {
+	return s.LeaseExpirationTime.Status == pgtype.Present && s.LeaseExpirationTime.Time.After(now)
+}
+
+const requestWebSubSubscriptionSQL = `insert into websub_subscriptions(feed_id, hub_url, topic_url, secret, request_time)
+values($1, $2, $3, $4, $5)
+on conflict (feed_id) do update set
+  hub_url=excluded.hub_url,
+  topic_url=excluded.topic_url,
+  secret=excluded.secret,
+  request_time=excluded.request_time,
+  lease_expiration_time=null`
+
+// RequestWebSubSubscription records that a subscription to the hub of feedID
+// is about to be requested. It replaces any previous subscription of the feed.
+func RequestWebSubSubscription(ctx context.Context, db Queryer, feedID int32, hubURL, topicURL, secret string, requestTime time.Time) error {
+	_, err := prepareExec(ctx, db, "requestWebSubSubscription", requestWebSubSubscriptionSQL, feedID, hubURL, topicURL, secret, requestTime)
+	return err
+}
+
+const selectWebSubSubscriptionSQL = `select feed_id, hub_url, topic_url, secret, request_time, lease_expiration_time
+from websub_subscriptions
+where feed_id=$1`
+
+func SelectWebSubSubscription(ctx context.Context, db Queryer, feedID int32) (*WebSubSubscription, error) {
+	var s WebSubSubscription
+	err := prepareQueryRow(ctx, db, "selectWebSubSubscription", selectWebSubSubscriptionSQL, feedID).Scan(
+		&s.FeedID,
+		&s.HubURL,
+		&s.TopicURL,
+		&s.Secret,
+		&s.RequestTime,
+		&s.LeaseExpirationTime,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+const activateWebSubSubscriptionSQL = `update websub_subscriptions
+set lease_expiration_time=$3
+where feed_id=$1 and topic_url=$2`
+
+// ActivateWebSubSubscription records that the hub verified the subscription of
+// feedID to topicURL and until when it lasts.
+func ActivateWebSubSubscription(ctx context.Context, db Queryer, feedID int32, topicURL string, leaseExpirationTime time.Time) error {
+	commandTag, err := prepareExec(ctx, db, "activateWebSubSubscription", activateWebSubSubscriptionSQL, feedID, topicURL, leaseExpirationTime)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const deactivateWebSubSubscriptionSQL = `update websub_subscriptions
+set request_time=$3,
+  lease_expiration_time=null
+where feed_id=$1 and topic_url=$2`
+
+// DeactivateWebSubSubscription makes the subscription of feedID to topicURL
+// pending again, e.g. because the hub denied it. requestTime replaces the time
+// it was last requested so it is not requested again right away.
+func DeactivateWebSubSubscription(ctx context.Context, db Queryer, feedID int32, topicURL string, requestTime time.Time) error {
+	commandTag, err := prepareExec(ctx, db, "deactivateWebSubSubscription", deactivateWebSubSubscriptionSQL, feedID, topicURL, requestTime)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
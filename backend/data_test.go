@@ -6,6 +6,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -175,8 +179,8 @@ func TestDataFeeds(t *testing.T) {
 
 	now := time.Now()
 	fiveMinutesAgo := now.Add(-5 * time.Minute)
-	tenMinutesAgo := now.Add(-10 * time.Minute)
-	fifteenMinutesAgo := now.Add(-15 * time.Minute)
+	fiveMinutesFromNow := now.Add(5 * time.Minute)
+	tenMinutesFromNow := now.Add(10 * time.Minute)
 	update := &data.ParsedFeed{Name: "baz", Items: make([]data.ParsedItem, 0)}
 
 	// Create a feed
@@ -187,7 +191,7 @@ func TestDataFeeds(t *testing.T) {
 	}
 
 	// A new feed has never been fetched -- it should need fetching
-	staleFeeds, err := data.GetFeedsUncheckedSince(context.Background(), pool, tenMinutesAgo)
+	staleFeeds, err := data.GetFeedsDueForFetch(context.Background(), pool, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -203,14 +207,14 @@ func TestDataFeeds(t *testing.T) {
 
 	nullString := pgtype.Varchar{Status: pgtype.Null}
 
-	// Update feed as of now
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, now)
+	// Update feed as of now with next fetch in the future
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, tenMinutesFromNow)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// feed should no longer be stale
-	staleFeeds, err = data.GetFeedsUncheckedSince(context.Background(), pool, tenMinutesAgo)
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -218,37 +222,1669 @@ func TestDataFeeds(t *testing.T) {
 		t.Fatalf("Found %d stale feed, expected 0", len(staleFeeds))
 	}
 
-	// Update feed to be old enough to need refresh
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, fifteenMinutesAgo)
+	// But it should be due once its next fetch time has passed
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, tenMinutesFromNow)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(staleFeeds) != 1 {
+		t.Fatalf("Found %d stale feed, expected 1", len(staleFeeds))
+	}
+	if staleFeeds[0].ID.Int != feedID {
+		t.Errorf("Expected %v, got %v", feedID, staleFeeds[0].ID)
+	}
+	if !staleFeeds[0].NextFetchTime.Time.Equal(tenMinutesFromNow.Truncate(time.Microsecond)) {
+		t.Errorf("Expected %v, got %v", tenMinutesFromNow, staleFeeds[0].NextFetchTime)
+	}
+
+	// Update feed with a next fetch time in the past
+	err = data.UpdateFeedWithFetchUnchanged(context.Background(), pool, feedID, fiveMinutesAgo, fiveMinutesAgo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It should now need fetching
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 1 {
+		t.Fatalf("Found %d stale feed, expected 1", len(staleFeeds))
+	}
+
+	// But update feed with a failed fetch that should be retried later
+	err = data.UpdateFeedWithFetchFailure(context.Background(), pool, feedID, "something went wrong", now, fiveMinutesFromNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// feed should no longer be stale
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 0 {
+		t.Fatalf("Found %d stale feed, expected 0", len(staleFeeds))
+	}
+}
+
+func TestDataSuspendAndRetryFeed(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "http://bar"
+	err = data.InsertSubscription(context.Background(), pool, userID, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedID := subscriptions[0].FeedID.Int
+
+	now := time.Now()
+
+	err = data.UpdateFeedWithFetchFailure(context.Background(), pool, feedID, "something went wrong", now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.SuspendFeed(context.Background(), pool, feedID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Suspended feeds are never due
+	staleFeeds, err := data.GetFeedsDueForFetch(context.Background(), pool, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 0 {
+		t.Fatalf("Found %d stale feed, expected 0", len(staleFeeds))
+	}
+
+	// Other users can't retry the feed
+	err = data.RetryFeed(context.Background(), pool, userID+1, feedID, now)
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	err = data.RetryFeed(context.Background(), pool, userID, feedID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 1 {
+		t.Fatalf("Found %d stale feed, expected 1", len(staleFeeds))
+	}
+	if staleFeeds[0].FailureCount.Int != 1 {
+		t.Errorf("Expected failure count to be kept at %v, got %v", 1, staleFeeds[0].FailureCount)
+	}
+
+	err = data.SuspendFeed(context.Background(), pool, feedID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.RetryFeedByURL(context.Background(), pool, url, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleFeeds, err = data.GetFeedsDueForFetch(context.Background(), pool, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 1 {
+		t.Fatalf("Found %d stale feed, expected 1", len(staleFeeds))
+	}
+}
+
+func TestDataUpdateFeedURL(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Name = pgtype.Varchar{String: "other", Status: pgtype.Present}
+	otherUserID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.InsertSubscription(context.Background(), pool, otherUserID, "http://new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFeedID := subscriptions[0].FeedID.Int
+
+	subscriptions, err = data.SelectSubscriptions(context.Background(), pool, otherUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFeedID := subscriptions[0].FeedID.Int
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "baz", Items: []data.ParsedItem{
+		{URL: "http://baz/bar", Title: "Baz"},
+	}}
+
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, oldFeedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.UpdateFeedURL(context.Background(), pool, oldFeedID, "http://new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedID != newFeedID {
+		t.Fatalf("Expected feed to be merged into %v, got %v", newFeedID, feedID)
+	}
+
+	subscriptions, err = data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 {
+		t.Fatalf("Found %d subscriptions, expected 1", len(subscriptions))
+	}
+	if subscriptions[0].FeedID.Int != newFeedID {
+		t.Errorf("Expected subscription to feed %v, got %v", newFeedID, subscriptions[0].FeedID.Int)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unreadItems []struct {
+		FeedID int32 `json:"feed_id"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &unreadItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreadItems) != 1 {
+		t.Fatalf("Found %d unreadItems, expected 1", len(unreadItems))
+	}
+	if unreadItems[0].FeedID != newFeedID {
+		t.Errorf("Expected unread item in feed %v, got %v", newFeedID, unreadItems[0].FeedID)
+	}
+
+	// Moving to an unused URL just renames the feed
+	feedID, err = data.UpdateFeedURL(context.Background(), pool, newFeedID, "http://newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedID != newFeedID {
+		t.Errorf("Expected feed %v to keep its id, got %v", newFeedID, feedID)
+	}
+}
+
+func TestDataFolders(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderID, err := data.CreateFolder(context.Background(), pool, userID, "News")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = data.CreateFolder(context.Background(), pool, userID, "news")
+	if _, ok := err.(data.DuplicationError); !ok {
+		t.Fatalf("Expected DuplicationError, got %v", err)
+	}
+
+	sameFolderID, err := data.SelectOrCreateFolder(context.Background(), pool, userID, "NEWS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameFolderID != folderID {
+		t.Errorf("Expected existing folder %v, got %v", folderID, sameFolderID)
+	}
+
+	for _, url := range []string{"http://foo", "http://bar"} {
+		err = data.InsertSubscription(context.Background(), pool, userID, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.SetSubscriptionFolder(context.Background(), pool, userID, feedID, pgtype.Int4{Int: folderID, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other users' folders can't be used
+	err = data.SetSubscriptionFolder(context.Background(), pool, userID, feedID, pgtype.Int4{Int: folderID + 1, Status: pgtype.Present})
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	now := time.Now()
+	for i, url := range []string{"http://foo", "http://bar"} {
+		feedID, err := data.SelectFeedIDByURL(context.Background(), pool, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update := &data.ParsedFeed{Name: url, Items: []data.ParsedItem{{URL: fmt.Sprintf("%s/%d", url, i), Title: "Item"}}}
+		err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{FolderID: folderID}, data.ItemPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unreadItems []struct {
+		FeedID int32 `json:"feed_id"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &unreadItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreadItems) != 1 || unreadItems[0].FeedID != feedID {
+		t.Fatalf("Expected 1 unread item from feed %d, got %v", feedID, unreadItems)
+	}
+
+	err = data.RenameFolder(context.Background(), pool, userID, folderID, "Daily News")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.DeleteFolder(context.Background(), pool, userID, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 2 {
+		t.Fatalf("Found %d subscriptions, expected 2", len(subscriptions))
+	}
+	for _, s := range subscriptions {
+		if s.FolderID.Status != pgtype.Null {
+			t.Errorf("Expected subscription %v to no longer be in a folder, got %v", s.URL.String, s.FolderID)
+		}
+	}
+
+	err = data.DeleteFolder(context.Background(), pool, userID, folderID)
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+}
+
+func TestDataUpdateSubscription(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Blog - Just another WordPress site", Items: []data.ParsedItem{{URL: "http://foo/1", Title: "Item"}}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.UpdateSubscription(context.Background(), pool, userID, feedID, &data.SubscriptionSettings{
+		Name:  pgtype.Varchar{String: "Foo", Status: pgtype.Present},
+		Notes: pgtype.Text{String: "Weekly", Status: pgtype.Present},
+		Muted: pgtype.Bool{Bool: true, Status: pgtype.Present},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next fetch must not overwrite the user's name
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 {
+		t.Fatalf("Found %d subscriptions, expected 1", len(subscriptions))
+	}
+	if subscriptions[0].Name.String != "Foo" {
+		t.Errorf("Expected name %v, got %v", "Foo", subscriptions[0].Name.String)
+	}
+	if subscriptions[0].Notes.String != "Weekly" {
+		t.Errorf("Expected notes %v, got %v", "Weekly", subscriptions[0].Notes.String)
+	}
+	if !subscriptions[0].Muted.Bool {
+		t.Error("Expected subscription to be muted")
+	}
+
+	buffer := &bytes.Buffer{}
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "[]" {
+		t.Errorf("Expected no unread items from muted subscription, got %s", buffer.String())
+	}
+
+	// Undefined fields are left alone and a null name falls back to the feed name
+	err = data.UpdateSubscription(context.Background(), pool, userID, feedID, &data.SubscriptionSettings{
+		Name: pgtype.Varchar{Status: pgtype.Null},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err = data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions[0].Name.String != update.Name {
+		t.Errorf("Expected name %v, got %v", update.Name, subscriptions[0].Name.String)
+	}
+	if subscriptions[0].Notes.String != "Weekly" {
+		t.Errorf("Expected notes %v, got %v", "Weekly", subscriptions[0].Notes.String)
+	}
+
+	err = data.UpdateSubscription(context.Background(), pool, userID+1, feedID, &data.SubscriptionSettings{
+		Muted: pgtype.Bool{Bool: false, Status: pgtype.Present},
+	})
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	// An unknown folder changes nothing
+	err = data.UpdateSubscription(context.Background(), pool, userID, feedID, &data.SubscriptionSettings{
+		Notes:    pgtype.Text{String: "Daily", Status: pgtype.Present},
+		FolderID: pgtype.Int4{Int: -1, Status: pgtype.Present},
+	})
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	subscriptions, err = data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions[0].Notes.String != "Weekly" {
+		t.Errorf("Expected notes %v, got %v", "Weekly", subscriptions[0].Notes.String)
+	}
+}
+
+func TestDataStarredItems(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{{URL: "http://foo/1", Title: "Item"}}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemID int32
+	err = pool.QueryRow(context.Background(), "select id from items where feed_id=$1", feedID).Scan(&itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users can only star items from their own subscriptions
+	err = data.StarItem(context.Background(), pool, userID+1, itemID)
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = data.StarItem(context.Background(), pool, userID, itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = data.DeleteSubscription(context.Background(), pool, userID, feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The feed is kept for its starred item but no longer fetched
+	staleFeeds, err := data.GetFeedsDueForFetch(context.Background(), pool, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staleFeeds) != 0 {
+		t.Fatalf("Found %d stale feed, expected 0", len(staleFeeds))
+	}
+
+	buffer := &bytes.Buffer{}
+	err = data.CopyStarredItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var starredItems []struct {
+		ID          int32    `json:"id"`
+		FeedName    string   `json:"feed_name"`
+		Starred     bool     `json:"starred"`
+		StarredTime *float64 `json:"starred_time"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &starredItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starredItems) != 1 {
+		t.Fatalf("Found %d starred items, expected 1", len(starredItems))
+	}
+	if starredItems[0].ID != itemID || starredItems[0].FeedName != "Foo" || !starredItems[0].Starred || starredItems[0].StarredTime == nil {
+		t.Errorf("Unexpected starred item: %#v", starredItems[0])
+	}
+
+	err = data.UnstarItem(context.Background(), pool, userID, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.UnstarItem(context.Background(), pool, userID, itemID)
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+}
+
+func TestDataMarkItemUnread(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{{URL: "http://foo/1", Title: "Item"}}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemID int32
+	err = pool.QueryRow(context.Background(), "select id from items where feed_id=$1", feedID).Scan(&itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.MarkItemRead(context.Background(), pool, userID, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Marking unread twice is the same as once
+	for i := 0; i < 2; i++ {
+		err = data.MarkItemUnread(context.Background(), pool, userID, itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var unreadCount int64
+	err = pool.QueryRow(context.Background(), "select count(*) from unread_items where user_id=$1", userID).Scan(&unreadCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unreadCount != 1 {
+		t.Errorf("Found %d unread items, expected 1", unreadCount)
+	}
+
+	err = data.MarkItemUnread(context.Background(), pool, userID+1, itemID)
+	if err != data.ErrNotFound {
+		t.Fatalf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+}
+
+func TestDataMarkItemsRead(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderID, err := data.CreateFolder(context.Background(), pool, userID, "News")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lastWeek := now.Add(-7 * 24 * time.Hour)
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	feedIDs := make(map[string]int32)
+	for _, url := range []string{"http://foo", "http://bar", "http://baz"} {
+		err = data.InsertSubscription(context.Background(), pool, userID, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedID, err := data.SelectFeedIDByURL(context.Background(), pool, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		feedIDs[url] = feedID
+
+		update := &data.ParsedFeed{Name: url, Items: []data.ParsedItem{
+			{URL: url + "/old", Title: "Old", PublicationTime: pgtype.Timestamptz{Time: lastWeek, Status: pgtype.Present}},
+			{URL: url + "/new", Title: "New", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+		}}
+		err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = data.SetSubscriptionFolder(context.Background(), pool, userID, feedIDs["http://bar"], pgtype.Int4{Int: folderID, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   data.ItemFilter
+		before   time.Time
+		expected int64
+	}{
+		{data.ItemFilter{FeedID: feedIDs["http://foo"]}, time.Time{}, 2},
+		{data.ItemFilter{FolderID: folderID}, time.Time{}, 2},
+		{data.ItemFilter{}, now.Add(-time.Hour), 1},
+		{data.ItemFilter{}, time.Time{}, 1},
+		{data.ItemFilter{}, time.Time{}, 0},
+	}
+
+	for i, tt := range tests {
+		n, err := data.MarkItemsRead(context.Background(), pool, userID, tt.filter, tt.before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.expected {
+			t.Errorf("%d. Expected %d items marked read, got %d", i, tt.expected, n)
+		}
+	}
+}
+
+func TestDataMarkMultipleItemsRead(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+		{URL: "http://foo/3", Title: "Three"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(id order by url) from items").Scan(&itemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := data.MarkMultipleItemsRead(context.Background(), pool, userID, []int32{itemIDs[0], itemIDs[2], itemIDs[2] + 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 items marked read, got %d", n)
+	}
+
+	var unreadIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(item_id) from unread_items where user_id=$1", userID).Scan(&unreadIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unreadIDs, []int32{itemIDs[1]}) {
+		t.Errorf("Expected unread %v, got %v", []int32{itemIDs[1]}, unreadIDs)
+	}
+}
+
+func TestDataMarkMultipleItemsUnread(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := newUser()
+	user.Name = pgtype.Varchar{String: "other", Status: pgtype.Present}
+	otherUserID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	for _, url := range []string{"http://foo", "http://bar"} {
+		err = data.InsertSubscription(context.Background(), pool, otherUserID, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedID, err := data.SelectFeedIDByURL(context.Background(), pool, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update := &data.ParsedFeed{Name: url, Items: []data.ParsedItem{
+			{URL: url + "/1", Title: "One"},
+			{URL: url + "/2", Title: "Two"},
+		}}
+		err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// userID is only subscribed to http://foo
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(id order by url) from items").Scan(&itemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	barItemID, fooItemIDs := itemIDs[0], itemIDs[2:]
+
+	_, err = data.MarkMultipleItemsRead(context.Background(), pool, userID, fooItemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Marking unread twice is the same as once
+	n, err := data.MarkMultipleItemsUnread(context.Background(), pool, userID, []int32{fooItemIDs[0], barItemID, fooItemIDs[1] + 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 item marked unread, got %d", n)
+	}
+
+	n, err = data.MarkMultipleItemsUnread(context.Background(), pool, userID, []int32{fooItemIDs[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Expected 0 items marked unread, got %d", n)
+	}
+
+	var unreadIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(item_id) from unread_items where user_id=$1", userID).Scan(&unreadIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unreadIDs, []int32{fooItemIDs[0]}) {
+		t.Errorf("Expected unread %v, got %v", []int32{fooItemIDs[0]}, unreadIDs)
+	}
+}
+
+func TestDataStarMultipleItems(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+		{URL: "http://foo/3", Title: "Three"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(id order by url) from items").Scan(&itemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := data.StarMultipleItems(context.Background(), pool, userID, []int32{itemIDs[0], itemIDs[1], itemIDs[2] + 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 items starred, got %d", n)
+	}
+
+	n, err = data.StarMultipleItems(context.Background(), pool, userID+1, itemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Expected no items starred for unsubscribed user, got %d", n)
+	}
+
+	n, err = data.UnstarMultipleItems(context.Background(), pool, userID, []int32{itemIDs[0], itemIDs[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 item unstarred, got %d", n)
+	}
+
+	var starredIDs []int32
+	err = pool.QueryRow(context.Background(), "select array_agg(item_id) from starred_items where user_id=$1", userID).Scan(&starredIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(starredIDs, []int32{itemIDs[1]}) {
+		t.Errorf("Expected starred %v, got %v", []int32{itemIDs[1]}, starredIDs)
+	}
+}
+
+func TestDataItemPagination(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	for _, url := range []string{"http://foo", "http://bar"} {
+		err = data.InsertSubscription(context.Background(), pool, userID, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedID, err := data.SelectFeedIDByURL(context.Background(), pool, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update := &data.ParsedFeed{Name: url}
+		for i := 0; i < 3; i++ {
+			update.Items = append(update.Items, data.ParsedItem{
+				URL:             fmt.Sprintf("%s/%d", url, i),
+				Title:           fmt.Sprintf("%s %d", url, i),
+				PublicationTime: pgtype.Timestamptz{Time: now.Add(time.Duration(i) * time.Hour), Status: pgtype.Present},
+			})
+		}
+		err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titleCursors := make(map[string]data.ItemCursor)
+	rows, err := pool.Query(context.Background(), "select title, id, coalesce(publication_time, creation_time) from items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var title string
+		var cursor data.ItemCursor
+		rows.Scan(&title, &cursor.ID, &cursor.SortTime)
+		titleCursors[title] = cursor
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	fooID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type copyFunc func(context.Context, data.Queryer, io.Writer, int32, data.ItemFilter, data.ItemPage) error
+
+	tests := []struct {
+		name     string
+		copy     copyFunc
+		filter   data.ItemFilter
+		page     data.ItemPage
+		expected []string
+	}{
+		{"first page", data.CopyUnreadItemsAsJSONByUserID, data.ItemFilter{}, data.ItemPage{Limit: 2},
+			[]string{"http://foo 0", "http://bar 0"}},
+		{"after", data.CopyUnreadItemsAsJSONByUserID, data.ItemFilter{}, data.ItemPage{After: titleCursors["http://bar 0"], Limit: 2},
+			[]string{"http://foo 1", "http://bar 1"}},
+		{"before", data.CopyUnreadItemsAsJSONByUserID, data.ItemFilter{}, data.ItemPage{Before: titleCursors["http://foo 2"], Limit: 2},
+			[]string{"http://foo 1", "http://bar 1"}},
+		{"descending", data.CopyUnreadItemsAsJSONByUserID, data.ItemFilter{}, data.ItemPage{Descending: true, Limit: 3},
+			[]string{"http://bar 2", "http://foo 2", "http://bar 1"}},
+		{"descending after", data.CopyUnreadItemsAsJSONByUserID, data.ItemFilter{}, data.ItemPage{Descending: true, After: titleCursors["http://foo 1"]},
+			[]string{"http://bar 0", "http://foo 0"}},
+		{"feed", data.CopyArchivedItemsAsJSONByUserID, data.ItemFilter{FeedID: fooID}, data.ItemPage{Descending: true},
+			[]string{"http://foo 2", "http://foo 1", "http://foo 0"}},
+		{"feed after", data.CopyArchivedItemsAsJSONByUserID, data.ItemFilter{FeedID: fooID}, data.ItemPage{Descending: true, After: titleCursors["http://foo 2"], Limit: 1},
+			[]string{"http://foo 1"}},
+	}
+
+	for _, tt := range tests {
+		buffer := &bytes.Buffer{}
+		err := tt.copy(context.Background(), pool, buffer, userID, tt.filter, tt.page)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var items []struct {
+			Title string `json:"title"`
+		}
+		err = json.Unmarshal(buffer.Bytes(), &items)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		titles := make([]string, len(items))
+		for i, item := range items {
+			titles[i] = item.Title
+		}
+		if !reflect.DeepEqual(titles, tt.expected) {
+			t.Errorf("%s: Expected %v, got %v", tt.name, tt.expected, titles)
+		}
+	}
+
+	// The cursor of a listed item still continues the listing after the item is
+	// deleted
+	buffer := &bytes.Buffer{}
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var firstPage []struct {
+		Cursor string `json:"cursor"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &firstPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := data.ParseItemCursor(firstPage[1].Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCursor := titleCursors["http://bar 0"]
+	if cursor.ID != expectedCursor.ID || !cursor.SortTime.Equal(expectedCursor.SortTime) {
+		t.Fatalf("Expected cursor %v, got %v", expectedCursor, cursor)
+	}
+
+	for _, sql := range []string{"delete from unread_items where item_id=$1", "delete from items where id=$1"} {
+		_, err = pool.Exec(context.Background(), sql, cursor.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buffer.Reset()
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{After: cursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secondPage []struct {
+		Title string `json:"title"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &secondPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secondPage) != 2 || secondPage[0].Title != "http://foo 1" || secondPage[1].Title != "http://bar 1" {
+		t.Errorf("Unexpected page after deleted item: %s", buffer.String())
+	}
+}
+
+func TestDataItemQueryMuted(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var starredID int32
+	err = pool.QueryRow(context.Background(), "select id from items where url='http://foo/1'").Scan(&starredID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.StarItem(context.Background(), pool, userID, starredID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pool.Exec(context.Background(), "update subscriptions set muted=true where user_id=$1", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    data.ItemQuery
+		expected int
+	}{
+		{"reading list", data.ItemQuery{}, 0},
+		{"unread", data.ItemQuery{Unread: true}, 0},
+		{"feed", data.ItemQuery{FeedID: feedID}, 0},
+		{"starred", data.ItemQuery{Starred: true}, 1},
+		{"item IDs", data.ItemQuery{ItemIDs: []int32{starredID}}, 1},
+	}
+
+	for _, tt := range tests {
+		refs, err := data.SelectItemRefs(context.Background(), pool, userID, tt.query, data.ItemPage{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(refs) != tt.expected {
+			t.Errorf("%s: Expected %d items, got %d", tt.name, tt.expected, len(refs))
+		}
+	}
+}
+
+func TestDataDeleteExpiredItems(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo"}
+	for i := 1; i <= 5; i++ {
+		update.Items = append(update.Items, data.ParsedItem{
+			URL:             fmt.Sprintf("http://foo/%d", i),
+			Title:           fmt.Sprintf("Item %d", i),
+			PublicationTime: pgtype.Timestamptz{Time: now.Add(time.Duration(i) * time.Hour), Status: pgtype.Present},
+		})
+	}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itemIDs := make(map[string]int32)
+	rows, _ := pool.Query(context.Background(), "select url, id from items")
+	for rows.Next() {
+		var url string
+		var id int32
+		rows.Scan(&url, &id)
+		itemIDs[url] = id
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	// Item 4 stays unread, 2 is starred, and 1 is tagged
+	for _, url := range []string{"http://foo/1", "http://foo/2", "http://foo/3", "http://foo/5"} {
+		err = data.MarkItemRead(context.Background(), pool, userID, itemIDs[url])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = data.StarItem(context.Background(), pool, userID, itemIDs["http://foo/2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.TagItems(context.Background(), pool, userID, []int32{itemIDs["http://foo/1"]}, []string{"reference"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := data.RetentionPolicy{MaxItemsPerFeed: 1, MaxItemAge: time.Hour}
+
+	// Nothing is old enough yet
+	result, err := data.DeleteExpiredItems(context.Background(), pool, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Items != 0 {
+		t.Errorf("Expected 0 items deleted, got %d", result.Items)
+	}
 
-	// It should now need fetching
-	staleFeeds, err = data.GetFeedsUncheckedSince(context.Background(), pool, tenMinutesAgo)
+	result, err = data.DeleteExpiredItems(context.Background(), pool, policy, now.Add(2*time.Hour))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(staleFeeds) != 1 {
-		t.Fatalf("Found %d stale feed, expected 1", len(staleFeeds))
+	if result.Items != 1 {
+		t.Errorf("Expected 1 item deleted, got %d", result.Items)
 	}
-	if staleFeeds[0].ID.Int != feedID {
-		t.Errorf("Expected %v, got %v", feedID, staleFeeds[0].ID)
+
+	var urls []string
+	rows, _ = pool.Query(context.Background(), "select url from items order by url")
+	for rows.Next() {
+		var url string
+		rows.Scan(&url)
+		urls = append(urls, url)
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	expected := []string{"http://foo/1", "http://foo/2", "http://foo/4", "http://foo/5"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %v, got %v", expected, urls)
 	}
 
-	// But update feed with a recent failed fetch
-	err = data.UpdateFeedWithFetchFailure(context.Background(), pool, feedID, "something went wrong", fiveMinutesAgo)
+	// The deleted item is still in the feed but must not come back as unread
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// feed should no longer be stale
-	staleFeeds, err = data.GetFeedsUncheckedSince(context.Background(), pool, tenMinutesAgo)
+	var unreadCount int64
+	err = pool.QueryRow(context.Background(), "select count(*) from unread_items where user_id=$1", userID).Scan(&unreadCount)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(staleFeeds) != 0 {
-		t.Fatalf("Found %d stale feed, expected 0", len(staleFeeds))
+	if unreadCount != 1 {
+		t.Errorf("Expected 1 unread item, got %d", unreadCount)
+	}
+
+	deletedItemCount := func() int64 {
+		var n int64
+		err := pool.QueryRow(context.Background(), "select count(*) from deleted_items where url='http://foo/3'").Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A feed that is not fetched, e.g. because it is suspended, does not forget
+	// its deleted items
+	_, err = data.DeleteExpiredItems(context.Background(), pool, data.RetentionPolicy{}, now.Add(60*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := deletedItemCount(); n != 1 {
+		t.Errorf("Expected deleted item to be remembered, got %d", n)
+	}
+
+	// The deleted item is forgotten once the feed has not listed it for long
+	// enough
+	update.Items = update.Items[3:]
+	fetchTime := now.Add(60 * 24 * time.Hour)
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, fetchTime, fetchTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = data.DeleteExpiredItems(context.Background(), pool, data.RetentionPolicy{}, fetchTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := deletedItemCount(); n != 0 {
+		t.Errorf("Expected deleted item to be forgotten, got %d", n)
+	}
+}
+
+func TestDataItemTags(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Name = pgtype.Varchar{String: "other", Status: pgtype.Present}
+	otherUserID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One", PublicationTime: pgtype.Timestamptz{Time: now.Add(-time.Hour), Status: pgtype.Present}},
+		{URL: "http://foo/2", Title: "Two", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var itemIDs []int32
+	rows, _ := pool.Query(context.Background(), "select id from items where feed_id=$1 order by url", feedID)
+	for rows.Next() {
+		var id int32
+		rows.Scan(&id)
+		itemIDs = append(itemIDs, id)
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	err = data.TagItems(context.Background(), pool, userID, itemIDs, []string{"Reference", "team-review"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users cannot tag items they cannot see
+	err = data.TagItems(context.Background(), pool, otherUserID, itemIDs, []string{"mine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type taggedItem struct {
+		ID   int32    `json:"id"`
+		Tags []string `json:"tags"`
+	}
+	taggedItems := func(userID int32, tag string) []taggedItem {
+		buffer := &bytes.Buffer{}
+		err := data.CopyTaggedItemsAsJSONByUserID(context.Background(), pool, buffer, userID, tag, data.ItemPage{Descending: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var items []taggedItem
+		err = json.Unmarshal(buffer.Bytes(), &items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	items := taggedItems(otherUserID, "mine")
+	if len(items) != 0 {
+		t.Errorf("Expected no items, got %v", items)
+	}
+
+	err = data.UntagItems(context.Background(), pool, userID, itemIDs[1:], []string{"team-review"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items = taggedItems(userID, "REFERENCE")
+	expected := []taggedItem{
+		{ID: itemIDs[1], Tags: []string{"Reference"}},
+		{ID: itemIDs[0], Tags: []string{"Reference", "team-review"}},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+
+	// Tagged items are kept after unsubscribing
+	err = data.DeleteSubscription(context.Background(), pool, userID, feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items = taggedItems(userID, "team-review")
+	expected = []taggedItem{{ID: itemIDs[0], Tags: []string{"Reference", "team-review"}}}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+
+	var tagID int32
+	err = pool.QueryRow(context.Background(), "select id from tags where user_id=$1 and name='team-review'", userID).Scan(&tagID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.DeleteTag(context.Background(), pool, otherUserID, tagID)
+	if err != data.ErrNotFound {
+		t.Errorf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	err = data.DeleteTag(context.Background(), pool, userID, tagID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items = taggedItems(userID, "team-review")
+	if len(items) != 0 {
+		t.Errorf("Expected no items, got %v", items)
+	}
+}
+
+func TestDataFilterRules(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Name = pgtype.Varchar{String: "other", Status: pgtype.Present}
+	otherUserID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int32{userID, otherUserID} {
+		err = data.InsertSubscription(context.Background(), pool, id, "http://foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagID, err := data.SelectOrCreateTag(context.Background(), pool, userID, "releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	varchar := func(s string) pgtype.Varchar { return pgtype.Varchar{String: s, Status: pgtype.Present} }
+	null := pgtype.Int4{Status: pgtype.Null}
+
+	rules := []data.FilterRule{
+		{FeedID: pgtype.Int4{Int: feedID, Status: pgtype.Present}, Field: varchar("title"), MatchType: varchar("keyword"), Pattern: varchar("sponsored:"), Action: varchar("mark_read"), TagID: null},
+		{FeedID: null, Field: varchar("author"), MatchType: varchar("regex"), Pattern: varchar("^jack"), Action: varchar("star"), TagID: null},
+		{FeedID: null, Field: varchar("content"), MatchType: varchar("keyword"), Pattern: varchar("release notes"), Action: varchar("tag"), TagID: pgtype.Int4{Int: tagID, Status: pgtype.Present}},
+	}
+	for i := range rules {
+		_, err = data.CreateFilterRule(context.Background(), pool, userID, &rules[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invalid := data.FilterRule{FeedID: null, Field: varchar("title"), MatchType: varchar("regex"), Pattern: varchar("(foo"), Action: varchar("mark_read"), TagID: null}
+	_, err = data.CreateFilterRule(context.Background(), pool, userID, &invalid)
+	if _, ok := err.(data.InvalidPatternError); !ok {
+		t.Errorf("Expected InvalidPatternError, got %v", err)
+	}
+
+	tooLong := invalid
+	tooLong.MatchType = varchar("keyword")
+	tooLong.Pattern = varchar(strings.Repeat("a", data.MaxFilterRulePatternLength+1))
+	_, err = data.CreateFilterRule(context.Background(), pool, userID, &tooLong)
+	if _, ok := err.(data.InvalidPatternError); !ok {
+		t.Errorf("Expected InvalidPatternError for long pattern, got %v", err)
+	}
+
+	// Tags of other users cannot be used
+	_, err = data.CreateFilterRule(context.Background(), pool, otherUserID, &rules[2])
+	if err != data.ErrNotFound {
+		t.Errorf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "Sponsored: Buy things"},
+		{URL: "http://foo/2", Title: "Something", Author: "Jack"},
+		{URL: "http://foo/3", Title: "Version 2", Content: "<p>Read the <b>release</b> <i>notes</i></p>"},
+		{URL: "http://foo/4", Title: "Plain"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectURLs := func(sql string, args ...interface{}) []string {
+		var urls []string
+		rows, _ := pool.Query(context.Background(), sql, args...)
+		for rows.Next() {
+			var url string
+			rows.Scan(&url)
+			urls = append(urls, url)
+		}
+		if rows.Err() != nil {
+			t.Fatal(rows.Err())
+		}
+		return urls
+	}
+
+	unreadSQL := "select items.url from unread_items join items on unread_items.item_id=items.id where user_id=$1 order by items.url"
+
+	urls := selectURLs(unreadSQL, userID)
+	expected := []string{"http://foo/2", "http://foo/3", "http://foo/4"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected unread %v, got %v", expected, urls)
+	}
+
+	// Rules of one user do not affect other subscribers
+	urls = selectURLs(unreadSQL, otherUserID)
+	expected = []string{"http://foo/1", "http://foo/2", "http://foo/3", "http://foo/4"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected unread %v, got %v", expected, urls)
+	}
+
+	urls = selectURLs("select items.url from starred_items join items on starred_items.item_id=items.id where user_id=$1", userID)
+	expected = []string{"http://foo/2"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected starred %v, got %v", expected, urls)
+	}
+
+	urls = selectURLs("select items.url from item_tags join items on item_tags.item_id=items.id where tag_id=$1", tagID)
+	expected = []string{"http://foo/3"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected tagged %v, got %v", expected, urls)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = data.CopyFilterRulesForUserAsJSON(context.Background(), pool, buffer, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var copied []struct {
+		ID  int32  `json:"id"`
+		Tag string `json:"tag"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 3 || copied[2].Tag != "releases" {
+		t.Fatalf("Unexpected filter rules: %s", buffer.String())
+	}
+
+	err = data.DeleteFilterRule(context.Background(), pool, otherUserID, copied[0].ID)
+	if err != data.ErrNotFound {
+		t.Errorf("Expected %v, got %v", data.ErrNotFound, err)
+	}
+
+	err = data.DeleteFilterRule(context.Background(), pool, userID, copied[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataSearchItems(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Name = pgtype.Varchar{String: "other", Status: pgtype.Present}
+	otherUserID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lastYear := now.Add(-365 * 24 * time.Hour)
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	feeds := []struct {
+		userID int32
+		url    string
+		items  []data.ParsedItem
+	}{
+		{userID, "http://foo", []data.ParsedItem{
+			{URL: "http://foo/1", Title: "Snow storm coming", Content: "<p>Expect heavy <b>snow</b> tonight</p>", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+			{URL: "http://foo/2", Title: "Sunny days", Content: "<p>No snow in sight</p>", PublicationTime: pgtype.Timestamptz{Time: lastYear, Status: pgtype.Present}},
+			{URL: "http://foo/3", Title: "Rain", Content: "<p>Just rain</p>", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+			{URL: "http://foo/4", Title: "Hail", Content: "<p>Hail &lt;script&gt;alert(1)&lt;/script&gt;</p><img src=x onerror=alert(1)", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+		}},
+		{otherUserID, "http://bar", []data.ParsedItem{
+			{URL: "http://bar/1", Title: "Snow everywhere", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+		}},
+	}
+
+	feedIDs := make(map[string]int32)
+	for _, f := range feeds {
+		err = data.InsertSubscription(context.Background(), pool, f.userID, f.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feedID, err := data.SelectFeedIDByURL(context.Background(), pool, f.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		feedIDs[f.url] = feedID
+
+		update := &data.ParsedFeed{Name: f.url, Items: f.items}
+		err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(s data.ItemSearch) []string {
+		s.Limit = 10
+		buffer := &bytes.Buffer{}
+		err := data.CopySearchResultsAsJSONByUserID(context.Background(), pool, buffer, userID, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var results []struct {
+			Title   string `json:"title"`
+			Snippet string `json:"snippet"`
+		}
+		err = json.Unmarshal(buffer.Bytes(), &results)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		titles := make([]string, len(results))
+		for i, r := range results {
+			titles[i] = r.Title
+		}
+		return titles
+	}
+
+	// Title matches rank above content matches and other users' feeds are not searched
+	titles := search(data.ItemSearch{Query: "snow"})
+	expected := []string{"Snow storm coming", "Sunny days"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v, got %v", expected, titles)
+	}
+
+	titles = search(data.ItemSearch{Query: "snow", Since: now.Add(-time.Hour)})
+	expected = []string{"Snow storm coming"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v, got %v", expected, titles)
+	}
+
+	titles = search(data.ItemSearch{Query: "snow", Until: now.Add(-time.Hour)})
+	expected = []string{"Sunny days"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v, got %v", expected, titles)
+	}
+
+	titles = search(data.ItemSearch{Query: "snow", FeedID: feedIDs["http://bar"]})
+	if len(titles) != 0 {
+		t.Errorf("Expected no results, got %v", titles)
+	}
+
+	// Markup in the content never becomes markup in the snippet
+	buffer := &bytes.Buffer{}
+	err = data.CopySearchResultsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemSearch{Query: "hail", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []struct {
+		Snippet string `json:"snippet"`
+	}
+	err = json.Unmarshal(buffer.Bytes(), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %s", buffer.String())
+	}
+	snippet := strings.NewReplacer("<b>", "", "</b>", "").Replace(results[0].Snippet)
+	if strings.ContainsAny(snippet, "<>") || !strings.Contains(snippet, "&lt;img") {
+		t.Errorf("Expected snippet without markup other than <b>, got %s", results[0].Snippet)
+	}
+
+	// Starred items are still found after unsubscribing
+	var itemID int32
+	err = pool.QueryRow(context.Background(), "select id from items where url='http://foo/1'").Scan(&itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.StarItem(context.Background(), pool, userID, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = data.DeleteSubscription(context.Background(), pool, userID, feedIDs["http://foo"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles = search(data.ItemSearch{Query: "snow"})
+	expected = []string{"Snow storm coming"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v, got %v", expected, titles)
 	}
 }
 
@@ -281,25 +1917,29 @@ func TestDataUpdateFeedWithFetchSuccess(t *testing.T) {
 		{
 			URL:             "http://baz/bar",
 			Title:           "Baz",
+			Author:          "John",
+			Content:         "<p>Baz</p>",
 			PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present},
 		},
 	}}
 
 	nullString := pgtype.Varchar{Status: pgtype.Null}
 
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, now)
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	buffer := &bytes.Buffer{}
-	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID)
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	type UnreadItemsFromJSON struct {
-		ID int32 `json:id`
+		ID      int32  `json:id`
+		Author  string `json:"author"`
+		Content string `json:"content"`
 	}
 
 	var unreadItems []UnreadItemsFromJSON
@@ -310,15 +1950,21 @@ func TestDataUpdateFeedWithFetchSuccess(t *testing.T) {
 	if len(unreadItems) != 1 {
 		t.Fatalf("Found %d unreadItems, expected 1", len(unreadItems))
 	}
+	if unreadItems[0].Author != "John" {
+		t.Errorf("Expected author %v, got %v", "John", unreadItems[0].Author)
+	}
+	if unreadItems[0].Content != "<p>Baz</p>" {
+		t.Errorf("Expected content %v, got %v", "<p>Baz</p>", unreadItems[0].Content)
+	}
 
 	// Update again and ensure item does not get created again
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, now)
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	buffer.Reset()
-	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID)
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -365,13 +2011,13 @@ func TestDataUpdateFeedWithFetchSuccessWithoutPublicationTime(t *testing.T) {
 
 	nullString := pgtype.Varchar{Status: pgtype.Null}
 
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, now)
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	buffer := &bytes.Buffer{}
-	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID)
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -390,13 +2036,13 @@ func TestDataUpdateFeedWithFetchSuccessWithoutPublicationTime(t *testing.T) {
 	}
 
 	// Update again and ensure item does not get created again
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, now)
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	buffer.Reset()
-	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID)
+	err = data.CopyUnreadItemsAsJSONByUserID(context.Background(), pool, buffer, userID, data.ItemFilter{}, data.ItemPage{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -434,6 +2080,11 @@ func TestDataSubscriptions(t *testing.T) {
 	if subscriptions[0].URL.String != url {
 		t.Fatalf("Expected %v, got %v", url, subscriptions[0].URL)
 	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, url)
+	if err != data.ErrAlreadySubscribed {
+		t.Fatalf("Expected %v, got %v", data.ErrAlreadySubscribed, err)
+	}
 }
 
 func TestDataDeleteSubscription(t *testing.T) {
@@ -467,7 +2118,7 @@ func TestDataDeleteSubscription(t *testing.T) {
 
 	nullString := pgtype.Varchar{Status: pgtype.Null}
 
-	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, time.Now().Add(-20*time.Minute))
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, time.Now().Add(-20*time.Minute), time.Now().Add(-10*time.Minute))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -486,7 +2137,7 @@ func TestDataDeleteSubscription(t *testing.T) {
 	}
 
 	// feed should have been deleted as it was the last user
-	staleFeeds, err := data.GetFeedsUncheckedSince(context.Background(), pool, time.Now())
+	staleFeeds, err := data.GetFeedsDueForFetch(context.Background(), pool, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
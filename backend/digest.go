@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Digest is an email of the new unread items of a user grouped by feed.
+type Digest struct {
+	Name             string
+	Feeds            []DigestFeed
+	ItemCount        int
+	UnsubscribeToken string
+}
+
+type DigestFeed struct {
+	Name  string
+	Items []data.DigestItem
+}
+
+// newDigest groups items, which must be ordered by feed, into a Digest.
+func newDigest(name, unsubscribeToken string, items []data.DigestItem) *Digest {
+	digest := &Digest{Name: name, ItemCount: len(items), UnsubscribeToken: unsubscribeToken}
+	for i, item := range items {
+		if i == 0 || item.FeedID != items[i-1].FeedID {
+			digest.Feeds = append(digest.Feeds, DigestFeed{Name: item.FeedName})
+		}
+		feed := &digest.Feeds[len(digest.Feeds)-1]
+		feed.Items = append(feed.Items, item)
+	}
+	return digest
+}
+
+// DigestSender mails the digests of users who opted in when they are due.
+type DigestSender struct {
+	interval time.Duration // how often due digests are looked for
+	maxItems int32         // most items included in one digest
+	mailer   Mailer
+	pool     *pgxpool.Pool
+	logger   log.Logger
+}
+
+func NewDigestSender(pool *pgxpool.Pool, mailer Mailer, logger log.Logger) *DigestSender {
+	sender := &DigestSender{}
+	sender.pool = pool
+	sender.mailer = mailer
+	sender.logger = logger
+	sender.interval = 5 * time.Minute
+	sender.maxItems = 100
+	return sender
+}
+
+func (s *DigestSender) KeepDigestsSent() {
+	for {
+		startTime := time.Now()
+		s.SendDueDigests(startTime)
+		sleepUntil(startTime.Add(s.interval))
+	}
+}
+
+// SendDueDigests sends the digests that are due at now and schedules the next
+// digest of each user. No mail is sent when there are no new items.
+func (s *DigestSender) SendDueDigests(now time.Time) error {
+	digests, err := data.SelectDueDigests(context.Background(), s.pool, now)
+	if err != nil {
+		s.logger.Error("SelectDueDigests failed", "error", err)
+		return err
+	}
+
+	for _, d := range digests {
+		s.send(d, now)
+	}
+
+	return nil
+}
+
+func (s *DigestSender) send(d data.DueDigest, now time.Time) {
+	loc, err := time.LoadLocation(d.Settings.TimeZone.String)
+	if err != nil {
+		s.logger.Warn("bad digest time zone, using UTC", "userID", d.UserID, "timeZone", d.Settings.TimeZone.String, "error", err)
+		loc = time.UTC
+	}
+	nextSendTime := nextDigestTime(now, loc, d.Settings.Frequency.String, int(d.Settings.Hour.Int), time.Weekday(d.Settings.Weekday.Int))
+
+	var items []data.DigestItem
+	if d.Email != "" {
+		items, err = data.SelectDigestItems(context.Background(), s.pool, d.UserID, s.maxItems)
+		if err != nil {
+			s.logger.Error("SelectDigestItems failed", "userID", d.UserID, "error", err)
+			return
+		}
+	}
+
+	if len(items) == 0 {
+		err = data.ScheduleDigest(context.Background(), s.pool, d.UserID, nextSendTime)
+		if err != nil {
+			s.logger.Error("ScheduleDigest failed", "userID", d.UserID, "error", err)
+		}
+		return
+	}
+
+	digest := newDigest(d.Name, d.Settings.UnsubscribeToken.String, items)
+	err = s.mailer.SendDigestMail(d.Email, digest)
+	if err != nil {
+		// The digest stays due so it is retried next interval
+		s.logger.Error("SendDigestMail failed", "userID", d.UserID, "error", err)
+		return
+	}
+
+	itemIDs := make([]int32, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ID
+	}
+
+	err = data.RecordDigestSent(context.Background(), s.pool, d.UserID, itemIDs, now, nextSendTime)
+	if err != nil {
+		s.logger.Error("RecordDigestSent failed", "userID", d.UserID, "error", err)
+	}
+}
+
+// nextDigestTime returns the first time after after that a digest with
+// frequency is sent at hour in loc. Weekly digests are sent on weekday.
+func nextDigestTime(after time.Time, loc *time.Location, frequency string, hour int, weekday time.Weekday) time.Time {
+	local := after.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+
+	days := 1
+	if frequency == "weekly" {
+		days = 7
+		next = time.Date(next.Year(), next.Month(), next.Day()+(int(weekday)-int(next.Weekday())+7)%7, hour, 0, 0, 0, loc)
+	}
+
+	for !next.After(after) {
+		next = time.Date(next.Year(), next.Month(), next.Day()+days, hour, 0, 0, 0, loc)
+	}
+
+	return next
+}
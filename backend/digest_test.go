@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestNextDigestTime(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		after     time.Time
+		loc       *time.Location
+		frequency string
+		hour      int
+		weekday   time.Weekday
+		expected  time.Time
+	}{
+		// Later today
+		{time.Date(2020, 3, 4, 5, 0, 0, 0, time.UTC), time.UTC, "daily", 7, time.Monday, time.Date(2020, 3, 4, 7, 0, 0, 0, time.UTC)},
+		// Exactly at the send time is the next day
+		{time.Date(2020, 3, 4, 7, 0, 0, 0, time.UTC), time.UTC, "daily", 7, time.Monday, time.Date(2020, 3, 5, 7, 0, 0, 0, time.UTC)},
+		// Local hour across the start of daylight saving time
+		{time.Date(2020, 3, 7, 20, 0, 0, 0, chicago), chicago, "daily", 7, time.Monday, time.Date(2020, 3, 8, 7, 0, 0, 0, chicago)},
+		// Local day differs from the UTC day
+		{time.Date(2020, 3, 4, 3, 0, 0, 0, time.UTC), chicago, "daily", 22, time.Monday, time.Date(2020, 3, 3, 22, 0, 0, 0, chicago)},
+		// 2020-03-04 is a Wednesday
+		{time.Date(2020, 3, 4, 5, 0, 0, 0, time.UTC), time.UTC, "weekly", 7, time.Monday, time.Date(2020, 3, 9, 7, 0, 0, 0, time.UTC)},
+		{time.Date(2020, 3, 4, 5, 0, 0, 0, time.UTC), time.UTC, "weekly", 7, time.Wednesday, time.Date(2020, 3, 4, 7, 0, 0, 0, time.UTC)},
+		{time.Date(2020, 3, 4, 8, 0, 0, 0, time.UTC), time.UTC, "weekly", 7, time.Wednesday, time.Date(2020, 3, 11, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for i, tt := range tests {
+		next := nextDigestTime(tt.after, tt.loc, tt.frequency, tt.hour, tt.weekday)
+		if !next.Equal(tt.expected) {
+			t.Errorf("%d. Expected %v, got %v", i, tt.expected, next)
+		}
+	}
+}
+
+func TestDigestMailMessage(t *testing.T) {
+	digest := newDigest("test", "abc123", []data.DigestItem{
+		{ID: 1, FeedID: 1, FeedName: "Bar", Title: "One", URL: "http://bar/1"},
+		{ID: 2, FeedID: 1, FeedName: "Bar", Title: "Two", URL: "http://bar/2"},
+		{ID: 3, FeedID: 2, FeedName: "Foo", Title: "Three", URL: "http://foo/3"},
+	})
+
+	if len(digest.Feeds) != 2 || len(digest.Feeds[0].Items) != 2 || digest.Feeds[1].Name != "Foo" {
+		t.Fatalf("Unexpected digest: %#v", digest)
+	}
+
+	msg, err := digestMailMessage("http://tpr.example", "joe@example.com", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"To: joe@example.com\r\n",
+		"Subject: The Pithy Reader Digest: 3 new items\r\n",
+		"List-Unsubscribe: <http://tpr.example/api/digest/unsubscribe?token=abc123>\r\n",
+		"Bar\r\n  One\r\n  http://bar/1\r\n  Two\r\n  http://bar/2\r\n\r\nFoo\r\n  Three\r\n",
+		"http://tpr.example/#home",
+	} {
+		if !bytes.Contains(msg, []byte(s)) {
+			t.Errorf("Expected message to contain %q, got:\n%s", s, msg)
+		}
+	}
+}
+
+func TestConfirmUnsubscribeDigestHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/digest/unsubscribe?token=abc%22123", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ConfirmUnsubscribeDigestHandler(w, req, &environment{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d", w.Code)
+	}
+
+	for _, s := range []string{`<form method="post">`, `name="token" value="abc&#34;123"`} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("Expected page to contain %q, got:\n%s", s, w.Body.String())
+		}
+	}
+}
+
+func TestDigestSender(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	user.Email = pgtype.Varchar{String: "test@example.com", Status: pgtype.Present}
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	err = data.SaveDigestSettings(context.Background(), pool, userID, &data.DigestSettings{
+		Frequency:        pgtype.Varchar{String: "daily", Status: pgtype.Present},
+		TimeZone:         pgtype.Varchar{String: "UTC", Status: pgtype.Present},
+		Hour:             pgtype.Int2{Int: 7, Status: pgtype.Present},
+		Weekday:          pgtype.Int2{Int: 1, Status: pgtype.Present},
+		UnsubscribeToken: pgtype.Varchar{String: "abc123", Status: pgtype.Present},
+		NextSendTime:     pgtype.Timestamptz{Time: now, Status: pgtype.Present},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &testMailer{}
+	sender := NewDigestSender(pool, mailer, log.Root())
+
+	err = sender.SendDueDigests(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sentDigestMails) != 1 {
+		t.Fatalf("Expected 1 digest mail, got %d", len(mailer.sentDigestMails))
+	}
+	sent := mailer.sentDigestMails[0]
+	if sent.to != "test@example.com" || sent.digest.ItemCount != 2 || sent.digest.UnsubscribeToken != "abc123" {
+		t.Errorf("Unexpected digest mail: %#v", sent)
+	}
+
+	settings, err := data.SelectDigestSettings(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.NextSendTime.Time.After(now) {
+		t.Errorf("Expected next send time to be after %v, got %v", now, settings.NextSendTime.Time)
+	}
+
+	// Items still unread are not sent again, and nothing is sent without new items
+	err = sender.SendDueDigests(settings.NextSendTime.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sentDigestMails) != 1 {
+		t.Fatalf("Expected no digest mail without new items, got %d", len(mailer.sentDigestMails))
+	}
+
+	update.Items = append(update.Items, data.ParsedItem{URL: "http://foo/3", Title: "Three"})
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err = data.SelectDigestSettings(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sender.SendDueDigests(settings.NextSendTime.Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sentDigestMails) != 2 {
+		t.Fatalf("Expected 2 digest mails, got %d", len(mailer.sentDigestMails))
+	}
+	sent = mailer.sentDigestMails[1]
+	if sent.digest.ItemCount != 1 || sent.digest.Feeds[0].Items[0].Title != "Three" {
+		t.Errorf("Expected only the new item, got %#v", sent.digest)
+	}
+
+	err = data.DeleteDigestSettingsByUnsubscribeToken(context.Background(), pool, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = data.SelectDigestSettings(context.Background(), pool, userID)
+	if err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after unsubscribe, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// feedLinkTypes are the <link rel="alternate"> types that identify a feed.
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+}
+
+// commonFeedPaths are probed when a page does not advertise any feeds.
+var commonFeedPaths = []string{"/feed", "/rss.xml", "/atom.xml"}
+
+type feedCandidate struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// discoverFeeds finds the feeds available at pageURL. If pageURL is itself a
+// feed it is the only candidate and it is also returned fetched and parsed, as
+// validateFeed would, so it does not need to be fetched again. Otherwise the
+// page is searched for <link rel="alternate"> feed links and, if it has none,
+// common feed paths on the same site are probed. An error is only returned if
+// pageURL cannot be fetched.
+func (u *FeedUpdater) discoverFeeds(pageURL string) ([]feedCandidate, *rawFeed, *data.ParsedFeed, error) {
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	page, err := u.fetchFeed(pageURL, nullString, nullString)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !isHTMLContentType(page.contentType) {
+		if feed, err := parseRawFeed(page); err == nil {
+			if page.permanentURL != "" {
+				pageURL = page.permanentURL
+			}
+			candidates := []feedCandidate{{URL: pageURL, Title: feed.Name}}
+			sanitizeFeed(feed, page.url)
+			return candidates, page, feed, nil
+		}
+	}
+
+	base, err := url.Parse(page.url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	candidates := findFeedLinks(page.body, base)
+	if len(candidates) > 0 {
+		return candidates, nil, nil, nil
+	}
+
+	for _, path := range commonFeedPaths {
+		probeURL := resolveURL(base, path).String()
+		raw, err := u.fetchFeed(probeURL, nullString, nullString)
+		if err != nil {
+			continue
+		}
+		if feed, err := parseRawFeed(raw); err == nil {
+			candidates = append(candidates, feedCandidate{URL: probeURL, Title: feed.Name})
+		}
+	}
+
+	return candidates, nil, nil, nil
+}
+
+// findFeedLinks returns the feeds linked from the <head> of the HTML document
+// body. Relative links are resolved against the document's <base> or base.
+func findFeedLinks(body []byte, base *url.URL) []feedCandidate {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	var candidates []feedCandidate
+	seen := make(map[string]bool)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Base:
+				if href := htmlAttr(n, "href"); href != "" {
+					if u := resolveURL(base, href); u != nil {
+						base = u
+					}
+				}
+			case atom.Link:
+				if isFeedLink(n) {
+					if u := resolveURL(base, strings.TrimSpace(htmlAttr(n, "href"))); u != nil && !seen[u.String()] {
+						seen[u.String()] = true
+						candidates = append(candidates, feedCandidate{URL: u.String(), Title: strings.TrimSpace(htmlAttr(n, "title"))})
+					}
+				}
+			case atom.Body:
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return candidates
+}
+
+func isFeedLink(n *html.Node) bool {
+	if htmlAttr(n, "href") == "" {
+		return false
+	}
+
+	alternate := false
+	for _, rel := range strings.Fields(strings.ToLower(htmlAttr(n, "rel"))) {
+		if rel == "alternate" {
+			alternate = true
+		}
+	}
+	if !alternate {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(htmlAttr(n, "type"))
+	return err == nil && feedLinkTypes[mediaType]
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.ToLower(a.Key) == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func isHTMLContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
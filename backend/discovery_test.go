@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestFindFeedLinks(t *testing.T) {
+	base, err := url.Parse("http://example.org/blog/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []feedCandidate
+	}{
+		{
+			name: "RSS, Atom, and JSON Feed",
+			body: `<html><head>
+<link rel="alternate" type="application/rss+xml" title="RSS" href="/rss.xml">
+<link rel="alternate" type="application/atom+xml" title="Atom" href="atom.xml">
+<link rel="alternate" type="application/feed+json" href="http://example.org/feed.json">
+</head><body></body></html>`,
+			expected: []feedCandidate{
+				{URL: "http://example.org/rss.xml", Title: "RSS"},
+				{URL: "http://example.org/blog/atom.xml", Title: "Atom"},
+				{URL: "http://example.org/feed.json"},
+			},
+		},
+		{
+			name: "Other links are ignored",
+			body: `<html><head>
+<link rel="stylesheet" type="text/css" href="/style.css">
+<link rel="alternate" hreflang="de" href="/de/">
+<link rel="alternate" type="application/json" href="/wp-json/">
+<link rel="ALTERNATE" type="application/rss+xml; charset=utf-8" href="/rss.xml">
+<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+</head><body><link rel="alternate" type="application/atom+xml" href="/body.xml"></body></html>`,
+			expected: []feedCandidate{
+				{URL: "http://example.org/rss.xml"},
+			},
+		},
+		{
+			name:     "Base element",
+			body:     `<html><head><base href="http://static.example.org/"><link rel="alternate" type="application/atom+xml" href="atom.xml"></head></html>`,
+			expected: []feedCandidate{{URL: "http://static.example.org/atom.xml"}},
+		},
+		{
+			name: "No feeds",
+			body: `<html><head><title>Blog</title></head><body></body></html>`,
+		},
+	}
+
+	for _, tt := range tests {
+		actual := findFeedLinks([]byte(tt.body), base)
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: expected %#v, but it was %#v", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestDiscoverFeeds(t *testing.T) {
+	rss := `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>News</title></channel></rss>`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/linked/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" title="News" href="/rss.xml"></head></html>`))
+	})
+	mux.HandleFunc("/unlinked/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Unlinked</title></head></html>`))
+	})
+	mux.HandleFunc("/rss.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(rss))
+	})
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Not a feed</title></head></html>`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		expected []feedCandidate
+		isFeed   bool
+	}{
+		{"/rss.xml", []feedCandidate{{URL: ts.URL + "/rss.xml", Title: "News"}}, true},
+		{"/linked/", []feedCandidate{{URL: ts.URL + "/rss.xml", Title: "News"}}, false},
+		{"/unlinked/", []feedCandidate{{URL: ts.URL + "/rss.xml", Title: "News"}}, false},
+	}
+
+	u := NewFeedUpdater(nil, log.Root())
+	for _, tt := range tests {
+		actual, rawFeed, feed, err := u.discoverFeeds(ts.URL + tt.path)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: expected %#v, but it was %#v", tt.path, tt.expected, actual)
+		}
+		if tt.isFeed && (rawFeed == nil || feed == nil || feed.Name != "News") {
+			t.Errorf("%s: expected the fetched feed to be returned, but it was %#v", tt.path, feed)
+		}
+		if !tt.isFeed && (rawFeed != nil || feed != nil) {
+			t.Errorf("%s: expected no fetched feed, but it was %#v", tt.path, feed)
+		}
+	}
+
+	_, _, _, err := u.discoverFeeds(ts.URL + "/missing")
+	if err == nil {
+		t.Error("Expected error for missing page")
+	}
+}
@@ -3,11 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"html"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgtype"
@@ -20,6 +27,11 @@ import (
 type FeedUpdater struct {
 	client                   *http.Client
 	maxConcurrentFeedFetches int
+	minFetchInterval         time.Duration
+	maxFetchInterval         time.Duration
+	suspendAfterFailures     int32         // 0 never suspends
+	websubCallbackURL        string        // base of WebSub callback URLs, "" disables WebSub
+	pushFetchInterval        time.Duration // fetch interval of feeds with an active WebSub subscription
 	pool                     *pgxpool.Pool
 	logger                   log.Logger
 }
@@ -30,6 +42,10 @@ func NewFeedUpdater(pool *pgxpool.Pool, logger log.Logger) *FeedUpdater {
 	feedUpdater.logger = logger
 	feedUpdater.client = &http.Client{Timeout: 60 * time.Second}
 	feedUpdater.maxConcurrentFeedFetches = 25
+	feedUpdater.minFetchInterval = 10 * time.Minute
+	feedUpdater.maxFetchInterval = 24 * time.Hour
+	feedUpdater.suspendAfterFailures = 20
+	feedUpdater.pushFetchInterval = 12 * time.Hour
 	return feedUpdater
 }
 
@@ -38,8 +54,8 @@ func (u *FeedUpdater) KeepFeedsFresh() {
 	for {
 		startTime := time.Now()
 
-		if staleFeeds, err := data.GetFeedsUncheckedSince(context.Background(), u.pool, startTime.Add(-10*time.Minute)); err == nil {
-			u.logger.Info("GetFeedsUncheckedSince succeeded", "n", len(staleFeeds))
+		if staleFeeds, err := data.GetFeedsDueForFetch(context.Background(), u.pool, startTime); err == nil {
+			u.logger.Info("GetFeedsDueForFetch succeeded", "n", len(staleFeeds))
 
 			staleFeedChan := make(chan data.Feed)
 			finishChan := make(chan bool)
@@ -65,7 +81,7 @@ func (u *FeedUpdater) KeepFeedsFresh() {
 			}
 
 		} else {
-			u.logger.Error("GetFeedsUncheckedSince failed", "error", err)
+			u.logger.Error("GetFeedsDueForFetch failed", "error", err)
 		}
 
 		sleepUntil(startTime.Add(time.Minute))
@@ -77,19 +93,31 @@ func sleepUntil(t time.Time) {
 	time.Sleep(t.Sub(time.Now()))
 }
 
+// errFeedGone is returned by fetchFeed when the server reports that the feed
+// has been removed for good.
+var errFeedGone = errors.New("Feed is gone: 410 Gone")
+
 type rawFeed struct {
-	url  string
-	body []byte
-	etag pgtype.Varchar
+	url           string
+	permanentURL  string // set when every redirect followed was permanent
+	body          []byte
+	etag          pgtype.Varchar
+	lastModified  pgtype.Varchar
+	contentType   string
+	notModified   bool
+	cacheLifetime time.Duration
 }
 
-func (u *FeedUpdater) fetchFeed(feedURL string, etag pgtype.Varchar) (*rawFeed, error) {
+func (u *FeedUpdater) fetchFeed(feedURL string, etag, lastModified pgtype.Varchar) (*rawFeed, error) {
 	feed := &rawFeed{url: feedURL}
 
 	req, err := http.NewRequest("GET", feed.url, nil)
 	if etag.Status == pgtype.Present {
 		req.Header.Add("If-None-Match", etag.String)
 	}
+	if lastModified.Status == pgtype.Present {
+		req.Header.Add("If-Modified-Since", lastModified.String)
+	}
 
 	resp, err := u.client.Do(req)
 	if err != nil {
@@ -97,6 +125,10 @@ func (u *FeedUpdater) fetchFeed(feedURL string, etag pgtype.Varchar) (*rawFeed,
 	}
 	defer resp.Body.Close()
 
+	feed.url = resp.Request.URL.String()
+	feed.permanentURL = permanentRedirectURL(resp)
+	feed.cacheLifetime = parseCacheLifetime(resp.Header)
+
 	switch resp.StatusCode {
 	case 200:
 		feed.body, err = ioutil.ReadAll(resp.Body)
@@ -105,41 +137,357 @@ func (u *FeedUpdater) fetchFeed(feedURL string, etag pgtype.Varchar) (*rawFeed,
 		}
 
 		feed.etag = newStringFallback(resp.Header.Get("Etag"), pgtype.Null)
+		feed.lastModified = newStringFallback(resp.Header.Get("Last-Modified"), pgtype.Null)
+		feed.contentType = resp.Header.Get("Content-Type")
 
 		return feed, nil
 	case 304:
-		return nil, nil
+		feed.notModified = true
+		return feed, nil
+	case 410:
+		return nil, errFeedGone
 	default:
 		return nil, fmt.Errorf("Bad HTTP response: %s", resp.Status)
 	}
 }
 
+// permanentRedirectURL returns the URL resp was finally fetched from if it was
+// only reached through permanent (301 or 308) redirects. Otherwise, including
+// when there were no redirects at all, it returns "".
+func permanentRedirectURL(resp *http.Response) string {
+	if resp.Request.Response == nil {
+		return ""
+	}
+
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		default:
+			return ""
+		}
+	}
+
+	return resp.Request.URL.String()
+}
+
 func (u *FeedUpdater) RefreshFeed(staleFeed data.Feed) {
-	rawFeed, err := u.fetchFeed(staleFeed.URL.String, staleFeed.ETag)
+	rawFeed, err := u.fetchFeed(staleFeed.URL.String, staleFeed.ETag, staleFeed.LastModified)
+	if err == errFeedGone {
+		u.logger.Warn("feed is gone", "url", staleFeed.URL.String, "id", staleFeed.ID.Int)
+		u.recordFeedGone(staleFeed)
+		return
+	}
 	if err != nil {
 		u.logger.Error("fetchFeed failed", "url", staleFeed.URL.String, "error", err)
-		data.UpdateFeedWithFetchFailure(context.Background(), u.pool, staleFeed.ID.Int, err.Error(), time.Now())
+		u.recordFetchFailure(staleFeed, err.Error())
 		return
 	}
+
+	feedID := staleFeed.ID.Int
+	if rawFeed.permanentURL != "" && rawFeed.permanentURL != staleFeed.URL.String {
+		u.logger.Info("feed moved permanently", "url", staleFeed.URL.String, "newURL", rawFeed.permanentURL, "id", feedID)
+		movedFeedID, err := data.UpdateFeedURL(context.Background(), u.pool, feedID, rawFeed.permanentURL)
+		if err != nil {
+			u.logger.Error("UpdateFeedURL failed", "id", feedID, "error", err)
+		} else {
+			feedID = movedFeedID
+		}
+	}
+
 	// 304 unchanged
-	if rawFeed == nil {
+	if rawFeed.notModified {
 		u.logger.Info("fetchFeed 304 unchanged", "url", staleFeed.URL.Value)
-		data.UpdateFeedWithFetchUnchanged(context.Background(), u.pool, staleFeed.ID.Int, time.Now())
+		now := time.Now()
+		interval := previousFetchInterval(staleFeed)
+		if interval < rawFeed.cacheLifetime {
+			interval = rawFeed.cacheLifetime
+		}
+		data.UpdateFeedWithFetchUnchanged(context.Background(), u.pool, feedID, now, now.Add(u.clampFetchInterval(interval)))
 		return
 	}
 
-	feed, err := parseFeed(rawFeed.body)
+	feed, err := parseRawFeed(rawFeed)
 	if err != nil {
 		u.logger.Error("parseFeed failed", "url", staleFeed.URL.Value, "error", err)
-		data.UpdateFeedWithFetchFailure(context.Background(), u.pool, staleFeed.ID.Int, fmt.Sprintf("Unable to parse feed: %v", err), time.Now())
+		staleFeed.ID.Int = feedID
+		u.recordFetchFailure(staleFeed, fmt.Sprintf("Unable to parse feed: %v", err))
+		return
+	}
+
+	sanitizeFeed(feed, rawFeed.url)
+
+	if err := u.saveFetchedFeed(feedID, rawFeed, feed); err != nil {
+		u.logger.Error("UpdateFeedWithFetchSuccess failed", "id", feedID, "error", err)
+		return
+	}
+	u.logger.Info("refreshFeed succeeded", "url", rawFeed.url, "id", feedID)
+}
+
+// saveFetchedFeed stores the items of a successfully fetched and parsed feed
+// and schedules its next fetch. Feeds whose hub pushes new content are fetched
+// less often. It also maintains the WebSub subscription of the feed.
+func (u *FeedUpdater) saveFetchedFeed(feedID int32, rawFeed *rawFeed, feed *data.ParsedFeed) error {
+	now := time.Now()
+	interval := u.fetchInterval(feed, rawFeed.cacheLifetime, now)
+
+	subscription := u.webSubSubscription(feedID)
+	if subscription != nil && subscription.IsActive(now) && interval < u.pushFetchInterval {
+		interval = u.clampFetchInterval(u.pushFetchInterval)
+	}
+
+	err := data.UpdateFeedWithFetchSuccess(context.Background(), u.pool, feedID, feed, rawFeed.etag, rawFeed.lastModified, now, now.Add(interval))
+	err = u.logFilterRulesError(feedID, err)
+	if err != nil {
+		return err
+	}
+
+	u.maintainWebSubSubscription(feedID, feed, rawFeed.url, subscription, now)
+	return nil
+}
+
+// logFilterRulesError logs and discards a data.FilterRulesError as the items
+// it was returned for were saved. Other errors are returned unchanged.
+func (u *FeedUpdater) logFilterRulesError(feedID int32, err error) error {
+	if rulesErr, ok := err.(data.FilterRulesError); ok {
+		u.logger.Warn("filter rules failed", "id", feedID, "userIDs", rulesErr.UserIDs, "error", rulesErr.Err)
+		return nil
+	}
+	return err
+}
+
+// feedValidationError explains why a URL could not be used as a feed. Code is
+// one of "fetch_failed", "gone", or "parse_failed".
+type feedValidationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *feedValidationError) Error() string {
+	return e.Message
+}
+
+// validateFeed fetches and parses feedURL the same way RefreshFeed does, but
+// without recording anything. Failures are returned as *feedValidationError.
+func (u *FeedUpdater) validateFeed(feedURL string) (*rawFeed, *data.ParsedFeed, error) {
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	rawFeed, err := u.fetchFeed(feedURL, nullString, nullString)
+	if err == errFeedGone {
+		return nil, nil, &feedValidationError{Code: "gone", Message: err.Error()}
+	}
+	if err != nil {
+		return nil, nil, &feedValidationError{Code: "fetch_failed", Message: err.Error()}
+	}
+
+	feed, err := parseRawFeed(rawFeed)
+	if err != nil {
+		return nil, nil, &feedValidationError{Code: "parse_failed", Message: fmt.Sprintf("Unable to parse feed: %v", err)}
+	}
+
+	sanitizeFeed(feed, rawFeed.url)
+
+	return rawFeed, feed, nil
+}
+
+// parseRawFeed parses a fetched feed according to its content type.
+func parseRawFeed(rawFeed *rawFeed) (*data.ParsedFeed, error) {
+	if isJSONContentType(rawFeed.contentType) {
+		return parseJSONFeed(rawFeed.body)
+	}
+	return parseFeed(rawFeed.body)
+}
+
+// recordFetchFailure records a failed fetch of feed. The next attempt is
+// delayed exponentially by the number of consecutive failures. Once there have
+// been suspendAfterFailures consecutive failures the feed is suspended.
+func (u *FeedUpdater) recordFetchFailure(feed data.Feed, failure string) {
+	failureCount := feed.FailureCount.Int + 1
+	now := time.Now()
+
+	err := data.UpdateFeedWithFetchFailure(context.Background(), u.pool, feed.ID.Int, failure, now, now.Add(u.failureBackoff(failureCount)))
+	if err != nil {
+		u.logger.Error("UpdateFeedWithFetchFailure failed", "id", feed.ID.Int, "error", err)
 		return
 	}
 
-	u.logger.Info("refreshFeed succeeded", "url", staleFeed.URL.Value, "id", staleFeed.ID.Int)
-	data.UpdateFeedWithFetchSuccess(context.Background(), u.pool, staleFeed.ID.Int, feed, rawFeed.etag, time.Now())
+	if u.suspendAfterFailures > 0 && failureCount >= u.suspendAfterFailures {
+		u.logger.Warn("suspending feed", "url", feed.URL.String, "id", feed.ID.Int, "failureCount", failureCount)
+		if err := data.SuspendFeed(context.Background(), u.pool, feed.ID.Int, now); err != nil {
+			u.logger.Error("SuspendFeed failed", "id", feed.ID.Int, "error", err)
+		}
+	}
+}
+
+// recordFeedGone records that the server said feed has been removed and
+// suspends it immediately. It will not be fetched again unless retried.
+func (u *FeedUpdater) recordFeedGone(feed data.Feed) {
+	now := time.Now()
+
+	err := data.UpdateFeedWithFetchFailure(context.Background(), u.pool, feed.ID.Int, errFeedGone.Error(), now, now.Add(u.maxFetchInterval))
+	if err != nil {
+		u.logger.Error("UpdateFeedWithFetchFailure failed", "id", feed.ID.Int, "error", err)
+		return
+	}
+
+	if err := data.SuspendFeed(context.Background(), u.pool, feed.ID.Int, now); err != nil {
+		u.logger.Error("SuspendFeed failed", "id", feed.ID.Int, "error", err)
+	}
+}
+
+// failureBackoff returns how long to wait before fetching a feed that has
+// failed failureCount consecutive times. It starts at the minimum fetch
+// interval and doubles with each failure up to the maximum fetch interval.
+func (u *FeedUpdater) failureBackoff(failureCount int32) time.Duration {
+	backoff := u.minFetchInterval
+	for i := int32(1); i < failureCount && backoff < u.maxFetchInterval; i++ {
+		backoff *= 2
+	}
+
+	return u.clampFetchInterval(backoff)
+}
+
+// fetchInterval chooses how long to wait before fetching feed again. It aims
+// to check about twice per observed publication interval, but never more often
+// than the publisher or the HTTP caching headers ask. The result is clamped to
+// the configured minimum and maximum.
+func (u *FeedUpdater) fetchInterval(feed *data.ParsedFeed, cacheLifetime time.Duration, now time.Time) time.Duration {
+	interval := publicationInterval(feed.Items, now) / 2
+	if interval < feed.UpdateInterval {
+		interval = feed.UpdateInterval
+	}
+	if interval < cacheLifetime {
+		interval = cacheLifetime
+	}
+
+	return u.clampFetchInterval(interval)
+}
+
+func (u *FeedUpdater) clampFetchInterval(interval time.Duration) time.Duration {
+	if interval < u.minFetchInterval {
+		return u.minFetchInterval
+	}
+	if interval > u.maxFetchInterval {
+		return u.maxFetchInterval
+	}
+	return interval
+}
+
+// previousFetchInterval returns the interval that was scheduled after the last
+// successful fetch of feed or 0 if unknown.
+func previousFetchInterval(feed data.Feed) time.Duration {
+	if feed.LastFetchTime.Status != pgtype.Present || feed.NextFetchTime.Status != pgtype.Present {
+		return 0
+	}
+	return feed.NextFetchTime.Time.Sub(feed.LastFetchTime.Time)
+}
+
+// publicationInterval estimates the average time between posts from the
+// publication times of the most recent items. The time since the newest item
+// counts as an interval too so that feeds that have gone quiet are checked less
+// often. It returns 0 if no items have a publication time.
+func publicationInterval(items []data.ParsedItem, now time.Time) time.Duration {
+	const maxSamples = 10
+
+	times := make([]time.Time, 0, len(items))
+	for _, item := range items {
+		if item.PublicationTime.Status == pgtype.Present && !item.PublicationTime.Time.After(now) {
+			times = append(times, item.PublicationTime.Time)
+		}
+	}
+	if len(times) == 0 {
+		return 0
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].After(times[j]) })
+	if len(times) > maxSamples {
+		times = times[:maxSamples]
+	}
+
+	oldest := times[len(times)-1]
+	return now.Sub(oldest) / time.Duration(len(times))
+}
+
+// parseCacheLifetime returns how long a response may be cached according to
+// the Cache-Control max-age directive or the Expires header. It returns 0 if
+// the response should not be cached or the headers are missing or invalid.
+func parseCacheLifetime(header http.Header) time.Duration {
+	if cacheControl := header.Get("Cache-Control"); cacheControl != "" {
+		for _, directive := range strings.Split(cacheControl, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-cache" || directive == "no-store" {
+				return 0
+			}
+			if strings.HasPrefix(directive, "max-age=") {
+				seconds, err := strconv.ParseInt(strings.Trim(directive[len("max-age="):], `"`), 10, 64)
+				if err != nil || seconds <= 0 {
+					return 0
+				}
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if expiresHeader := header.Get("Expires"); expiresHeader != "" {
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil {
+			return 0
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime
+		}
+	}
+
+	return 0
+}
+
+// parseUpdateInterval returns the update interval a publisher requests with
+// RSS ttl (in minutes) or the syndication module's sy:updatePeriod and
+// sy:updateFrequency. If both are present the longer interval is used. It
+// returns 0 if neither is present or valid.
+func parseUpdateInterval(ttl, updatePeriod, updateFrequency string) time.Duration {
+	var interval time.Duration
+
+	if minutes, err := strconv.ParseInt(strings.TrimSpace(ttl), 10, 32); err == nil && minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	var period time.Duration
+	switch strings.ToLower(strings.TrimSpace(updatePeriod)) {
+	case "hourly":
+		period = time.Hour
+	case "daily":
+		period = 24 * time.Hour
+	case "weekly":
+		period = 7 * 24 * time.Hour
+	case "monthly":
+		period = 30 * 24 * time.Hour
+	case "yearly":
+		period = 365 * 24 * time.Hour
+	}
+	if period > 0 {
+		frequency, err := strconv.ParseInt(strings.TrimSpace(updateFrequency), 10, 32)
+		if err != nil || frequency < 1 {
+			frequency = 1
+		}
+		if syInterval := period / time.Duration(frequency); syInterval > interval {
+			interval = syInterval
+		}
+	}
+
+	return interval
 }
 
 func parseFeed(body []byte) (f *data.ParsedFeed, err error) {
+	if isJSONFeed(body) {
+		return parseJSONFeed(body)
+	}
+
 	f, err = parseRSS(body)
 	if err == nil {
 		return f, nil
@@ -149,17 +497,34 @@ func parseFeed(body []byte) (f *data.ParsedFeed, err error) {
 }
 
 func parseRSS(body []byte) (*data.ParsedFeed, error) {
+	type Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Type   string `xml:"type,attr"`
+		Length string `xml:"length,attr"`
+	}
+
 	type Item struct {
-		Link    string `xml:"link"`
-		Title   string `xml:"title"`
-		Date    string `xml:"date"`
-		PubDate string `xml:"pubDate"`
+		mediaElements
+		Link        string      `xml:"link"`
+		Title       string      `xml:"title"`
+		Date        string      `xml:"date"`
+		PubDate     string      `xml:"pubDate"`
+		Description string      `xml:"description"`
+		Encoded     string      `xml:"encoded"` // content:encoded
+		Author      string      `xml:"author"`
+		Creator     string      `xml:"creator"` // dc:creator
+		Enclosure   []Enclosure `xml:"enclosure"`
+		Duration    string      `xml:"duration"` // itunes:duration
 	}
 
 	type Channel struct {
-		Title       string `xml:"title"`
-		Description string `xml:"description"`
-		Item        []Item `xml:"item"`
+		Title           string     `xml:"title"`
+		Description     string     `xml:"description"`
+		AtomLink        []feedLink `xml:"http://www.w3.org/2005/Atom link"`
+		TTL             string     `xml:"ttl"`
+		UpdatePeriod    string     `xml:"updatePeriod"`    // sy:updatePeriod
+		UpdateFrequency string     `xml:"updateFrequency"` // sy:updateFrequency
+		Item            []Item     `xml:"item"`
 	}
 
 	var rss struct {
@@ -178,6 +543,8 @@ func parseRSS(body []byte) (*data.ParsedFeed, error) {
 	} else {
 		feed.Name = rss.Channel.Description
 	}
+	feed.UpdateInterval = parseUpdateInterval(rss.Channel.TTL, rss.Channel.UpdatePeriod, rss.Channel.UpdateFrequency)
+	feed.HubURL, feed.SelfURL = parseWebSubLinks(rss.Channel.AtomLink)
 
 	var items []Item
 	if len(rss.Item) > 0 {
@@ -190,6 +557,25 @@ func parseRSS(body []byte) (*data.ParsedFeed, error) {
 	for i, item := range items {
 		feed.Items[i].URL = item.Link
 		feed.Items[i].Title = item.Title
+		feed.Items[i].Summary = strings.TrimSpace(item.Description)
+		feed.Items[i].Content = strings.TrimSpace(item.Encoded)
+		if item.Author != "" {
+			feed.Items[i].Author = strings.TrimSpace(item.Author)
+		} else {
+			feed.Items[i].Author = strings.TrimSpace(item.Creator)
+		}
+		for _, e := range item.Enclosure {
+			length, _ := strconv.ParseInt(strings.TrimSpace(e.Length), 10, 64)
+			feed.Items[i].Enclosures = appendEnclosure(feed.Items[i].Enclosures, data.ParsedEnclosure{
+				URL:    strings.TrimSpace(e.URL),
+				Type:   strings.TrimSpace(e.Type),
+				Length: length,
+			})
+		}
+		feed.Items[i].Enclosures = item.mediaElements.appendEnclosures(feed.Items[i].Enclosures)
+		if duration := parseDuration(item.Duration); duration > 0 && len(feed.Items[i].Enclosures) > 0 && feed.Items[i].Enclosures[0].Duration == 0 {
+			feed.Items[i].Enclosures[0].Duration = duration
+		}
 		if item.Date != "" {
 			feed.Items[i].PublicationTime, _ = parseTime(item.Date)
 		}
@@ -207,19 +593,35 @@ func parseRSS(body []byte) (*data.ParsedFeed, error) {
 
 func parseAtom(body []byte) (*data.ParsedFeed, error) {
 	type Link struct {
-		Href string `xml:"href,attr"`
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Type   string `xml:"type,attr"`
+		Length string `xml:"length,attr"`
+	}
+
+	type Author struct {
+		Name string `xml:"name"`
 	}
 
 	type Entry struct {
-		Link      Link   `xml:"link"`
-		Title     string `xml:"title"`
-		Published string `xml:"published"`
-		Updated   string `xml:"updated"`
+		mediaElements          // must precede Content so media:content is not taken as Atom content
+		Base          string   `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+		Link          []Link   `xml:"link"`
+		Title         string   `xml:"title"`
+		Published     string   `xml:"published"`
+		Updated       string   `xml:"updated"`
+		Summary       atomText `xml:"summary"`
+		Content       atomText `xml:"content"`
+		Author        Author   `xml:"author"`
 	}
 
 	var atom struct {
-		Title string  `xml:"title"`
-		Entry []Entry `xml:"entry"`
+		Base            string     `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+		Title           string     `xml:"title"`
+		Link            []feedLink `xml:"link"`
+		UpdatePeriod    string     `xml:"updatePeriod"`    // sy:updatePeriod
+		UpdateFrequency string     `xml:"updateFrequency"` // sy:updateFrequency
+		Entry           []Entry    `xml:"entry"`
 	}
 
 	err := parseXML(body, &atom)
@@ -229,10 +631,34 @@ func parseAtom(body []byte) (*data.ParsedFeed, error) {
 
 	var feed data.ParsedFeed
 	feed.Name = atom.Title
+	feed.UpdateInterval = parseUpdateInterval("", atom.UpdatePeriod, atom.UpdateFrequency)
+	feed.HubURL, feed.SelfURL = parseWebSubLinks(atom.Link)
 	feed.Items = make([]data.ParsedItem, len(atom.Entry))
 	for i, entry := range atom.Entry {
-		feed.Items[i].URL = entry.Link.Href
+		for _, link := range entry.Link {
+			switch link.Rel {
+			case "", "alternate":
+				if feed.Items[i].URL == "" {
+					feed.Items[i].URL = link.Href
+				}
+			case "enclosure":
+				length, _ := strconv.ParseInt(strings.TrimSpace(link.Length), 10, 64)
+				feed.Items[i].Enclosures = appendEnclosure(feed.Items[i].Enclosures, data.ParsedEnclosure{
+					URL:    strings.TrimSpace(link.Href),
+					Type:   strings.TrimSpace(link.Type),
+					Length: length,
+				})
+			}
+		}
+		if feed.Items[i].URL == "" && len(entry.Link) > 0 {
+			feed.Items[i].URL = entry.Link[0].Href
+		}
+		feed.Items[i].Enclosures = entry.mediaElements.appendEnclosures(feed.Items[i].Enclosures)
 		feed.Items[i].Title = entry.Title
+		feed.Items[i].Summary = entry.Summary.HTML()
+		feed.Items[i].Content = entry.Content.HTML()
+		feed.Items[i].Author = strings.TrimSpace(entry.Author.Name)
+		feed.Items[i].BaseURL = joinXMLBase(atom.Base, entry.Base)
 		if entry.Published != "" {
 			feed.Items[i].PublicationTime, _ = parseTime(entry.Published)
 		}
@@ -248,6 +674,367 @@ func parseAtom(body []byte) (*data.ParsedFeed, error) {
 	return &feed, nil
 }
 
+// feedLink is a link element of a feed such as Atom link or atom:link in RSS.
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// parseWebSubLinks returns the first hub and self links.
+func parseWebSubLinks(links []feedLink) (hubURL, selfURL string) {
+	for _, link := range links {
+		href := strings.TrimSpace(link.Href)
+		switch strings.ToLower(strings.TrimSpace(link.Rel)) {
+		case "hub":
+			if hubURL == "" {
+				hubURL = href
+			}
+		case "self":
+			if selfURL == "" {
+				selfURL = href
+			}
+		}
+	}
+	return hubURL, selfURL
+}
+
+type mediaContent struct {
+	URL       string           `xml:"url,attr"`
+	Type      string           `xml:"type,attr"`
+	FileSize  string           `xml:"fileSize,attr"`
+	Duration  string           `xml:"duration,attr"`
+	Thumbnail []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type mediaGroup struct {
+	Content   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnail []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// mediaElements are the Media RSS elements that may appear in an RSS item or
+// Atom entry. media:group is treated the same as its contents appearing
+// directly in the item.
+type mediaElements struct {
+	MediaContent   []mediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnail []mediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	MediaGroup     []mediaGroup     `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// appendEnclosures appends the media:content elements to enclosures. Item level
+// media:thumbnail elements are applied to enclosures without a thumbnail, or
+// become an enclosure themselves if there are no others.
+func (m mediaElements) appendEnclosures(enclosures []data.ParsedEnclosure) []data.ParsedEnclosure {
+	groups := append([]mediaGroup{{Content: m.MediaContent, Thumbnail: m.MediaThumbnail}}, m.MediaGroup...)
+
+	var thumbnailURL string
+	for _, g := range groups {
+		for _, c := range g.Content {
+			length, _ := strconv.ParseInt(strings.TrimSpace(c.FileSize), 10, 64)
+			e := data.ParsedEnclosure{
+				URL:      strings.TrimSpace(c.URL),
+				Type:     strings.TrimSpace(c.Type),
+				Length:   length,
+				Duration: parseDuration(c.Duration),
+			}
+			if len(c.Thumbnail) > 0 {
+				e.ThumbnailURL = strings.TrimSpace(c.Thumbnail[0].URL)
+			}
+			enclosures = appendEnclosure(enclosures, e)
+		}
+
+		if thumbnailURL == "" && len(g.Thumbnail) > 0 {
+			thumbnailURL = strings.TrimSpace(g.Thumbnail[0].URL)
+		}
+	}
+
+	if thumbnailURL == "" {
+		return enclosures
+	}
+
+	if len(enclosures) == 0 {
+		return []data.ParsedEnclosure{{URL: thumbnailURL, ThumbnailURL: thumbnailURL}}
+	}
+
+	for i := range enclosures {
+		if enclosures[i].ThumbnailURL == "" {
+			enclosures[i].ThumbnailURL = thumbnailURL
+		}
+	}
+
+	return enclosures
+}
+
+// appendEnclosure appends e to enclosures unless its URL is empty. If an
+// enclosure with the same URL already exists the missing attributes of that
+// enclosure are filled from e instead.
+func appendEnclosure(enclosures []data.ParsedEnclosure, e data.ParsedEnclosure) []data.ParsedEnclosure {
+	if e.URL == "" {
+		return enclosures
+	}
+
+	for i := range enclosures {
+		existing := &enclosures[i]
+		if existing.URL != e.URL {
+			continue
+		}
+		if existing.Type == "" {
+			existing.Type = e.Type
+		}
+		if existing.Length == 0 {
+			existing.Length = e.Length
+		}
+		if existing.Duration == 0 {
+			existing.Duration = e.Duration
+		}
+		if existing.ThumbnailURL == "" {
+			existing.ThumbnailURL = e.ThumbnailURL
+		}
+		return enclosures
+	}
+
+	return append(enclosures, e)
+}
+
+// parseDuration parses an itunes:duration or media:content duration. These are
+// either a number of seconds or [[HH:]MM:]SS. It returns 0 if value cannot be
+// parsed.
+func parseDuration(value string) int32 {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+
+	if seconds > math.MaxInt32 {
+		return 0
+	}
+	return int32(seconds)
+}
+
+// joinXMLBase combines a parent and child xml:base attribute.
+func joinXMLBase(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	if child == "" {
+		return parent
+	}
+
+	if u := resolveURL(nil, parent); u != nil {
+		if v := resolveURL(u, child); v != nil {
+			return v.String()
+		}
+	}
+	return child
+}
+
+// atomText is an Atom text construct such as content or summary.
+type atomText struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// HTML returns the text construct as an HTML fragment.
+func (t atomText) HTML() string {
+	switch t.Type {
+	case "html":
+		return strings.TrimSpace(t.Text)
+	case "xhtml":
+		return strings.TrimSpace(t.InnerXML)
+	default:
+		return strings.TrimSpace(html.EscapeString(t.Text))
+	}
+}
+
+// isJSONContentType returns true if contentType is application/feed+json or
+// application/json.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/feed+json" || mediaType == "application/json"
+}
+
+// isJSONFeed sniffs body for a JSON Feed version declaration. It is used when
+// the server does not send a useful Content-Type.
+func isJSONFeed(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return false
+	}
+
+	var doc struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(doc.Version, "https://jsonfeed.org/version/")
+}
+
+// parseJSONFeed parses JSON Feed version 1 and 1.1 (https://jsonfeed.org).
+func parseJSONFeed(body []byte) (*data.ParsedFeed, error) {
+	type Author struct {
+		Name string `json:"name"`
+	}
+
+	type Attachment struct {
+		URL               string  `json:"url"`
+		MimeType          string  `json:"mime_type"`
+		SizeInBytes       int64   `json:"size_in_bytes"`
+		DurationInSeconds float64 `json:"duration_in_seconds"`
+	}
+
+	type Item struct {
+		ID            json.RawMessage `json:"id"`
+		URL           string          `json:"url"`
+		ExternalURL   string          `json:"external_url"`
+		Title         string          `json:"title"`
+		Summary       string          `json:"summary"`
+		ContentHTML   string          `json:"content_html"`
+		ContentText   string          `json:"content_text"`
+		Author        Author          `json:"author"`  // version 1
+		Authors       []Author        `json:"authors"` // version 1.1
+		DatePublished string          `json:"date_published"`
+		DateModified  string          `json:"date_modified"`
+		Image         string          `json:"image"`
+		Attachments   []Attachment    `json:"attachments"`
+	}
+
+	type Hub struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+
+	var jsonFeed struct {
+		Version     string `json:"version"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		FeedURL     string `json:"feed_url"`
+		Hubs        []Hub  `json:"hubs"`
+		Items       []Item `json:"items"`
+	}
+
+	err := json.Unmarshal(body, &jsonFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed data.ParsedFeed
+	if jsonFeed.Title != "" {
+		feed.Name = jsonFeed.Title
+	} else {
+		feed.Name = jsonFeed.Description
+	}
+
+	for _, hub := range jsonFeed.Hubs {
+		if strings.EqualFold(hub.Type, "WebSub") {
+			feed.HubURL = strings.TrimSpace(hub.URL)
+			feed.SelfURL = strings.TrimSpace(jsonFeed.FeedURL)
+			break
+		}
+	}
+
+	feed.Items = make([]data.ParsedItem, len(jsonFeed.Items))
+	for i, item := range jsonFeed.Items {
+		feed.Items[i].URL = item.URL
+		if feed.Items[i].URL == "" {
+			feed.Items[i].URL = item.ExternalURL
+		}
+		if feed.Items[i].URL == "" {
+			// id is required to be a string by the spec but some publishers use numbers
+			var id string
+			if json.Unmarshal(item.ID, &id) == nil && (strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://")) {
+				feed.Items[i].URL = id
+			}
+		}
+
+		// Title is optional in JSON Feed (e.g. microblog posts) but required by
+		// TPR, so fall back to the summary or the start of the text content.
+		feed.Items[i].Title = item.Title
+		if feed.Items[i].Title == "" {
+			feed.Items[i].Title = item.Summary
+		}
+		if feed.Items[i].Title == "" {
+			feed.Items[i].Title = truncateText(item.ContentText, 100)
+		}
+
+		feed.Items[i].Summary = html.EscapeString(item.Summary)
+		if item.ContentHTML != "" {
+			feed.Items[i].Content = item.ContentHTML
+		} else {
+			feed.Items[i].Content = html.EscapeString(item.ContentText)
+		}
+
+		for _, a := range item.Attachments {
+			e := data.ParsedEnclosure{URL: a.URL, Type: a.MimeType, Length: a.SizeInBytes}
+			if a.DurationInSeconds > 0 && a.DurationInSeconds <= math.MaxInt32 {
+				e.Duration = int32(a.DurationInSeconds)
+			}
+			feed.Items[i].Enclosures = appendEnclosure(feed.Items[i].Enclosures, e)
+		}
+		if item.Image != "" {
+			if len(feed.Items[i].Enclosures) == 0 {
+				feed.Items[i].Enclosures = []data.ParsedEnclosure{{URL: item.Image}}
+			}
+			for j := range feed.Items[i].Enclosures {
+				if feed.Items[i].Enclosures[j].ThumbnailURL == "" {
+					feed.Items[i].Enclosures[j].ThumbnailURL = item.Image
+				}
+			}
+		}
+
+		if len(item.Authors) > 0 {
+			feed.Items[i].Author = item.Authors[0].Name
+		} else {
+			feed.Items[i].Author = item.Author.Name
+		}
+
+		if item.DatePublished != "" {
+			feed.Items[i].PublicationTime, _ = parseTime(item.DatePublished)
+		} else if item.DateModified != "" {
+			feed.Items[i].PublicationTime, _ = parseTime(item.DateModified)
+		}
+	}
+
+	if !feed.IsValid() {
+		return nil, errors.New("Invalid JSON Feed")
+	}
+
+	return &feed, nil
+}
+
+// truncateText returns the first line of s cut to at most n runes.
+func truncateText(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
+
 // Parse XML laxly
 func parseXML(body []byte, doc interface{}) error {
 	buf := bytes.NewBuffer(body)
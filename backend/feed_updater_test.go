@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -244,6 +245,282 @@ var feedParsingTests = []struct {
 			}},
 		"",
 	},
+	{"RSS - Content and author",
+		[]byte(`<?xml version="1.0" encoding="utf-8" ?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>News</title>
+    <item>
+      <title>Snow Storm</title>
+      <link>http://example.org/snow-storm</link>
+      <description>&lt;p&gt;Snow is coming&lt;/p&gt;</description>
+      <content:encoded><![CDATA[<p>Snow is coming. <b>Lots</b> of it.</p>]]></content:encoded>
+      <dc:creator>John Doe</dc:creator>
+    </item>
+    <item>
+      <title>Blizzard</title>
+      <link>http://example.org/blizzard</link>
+      <author>jane@example.org (Jane Doe)</author>
+    </item>
+  </channel>
+</rss>
+`),
+		&data.ParsedFeed{
+			Name: "News",
+			Items: []data.ParsedItem{
+				{
+					Title:   "Snow Storm",
+					URL:     "http://example.org/snow-storm",
+					Author:  "John Doe",
+					Summary: "<p>Snow is coming</p>",
+					Content: "<p>Snow is coming. <b>Lots</b> of it.</p>",
+				},
+				{
+					Title:  "Blizzard",
+					URL:    "http://example.org/blizzard",
+					Author: "jane@example.org (Jane Doe)",
+				},
+			}},
+		"",
+	},
+	{"Atom - Content and author",
+		[]byte(`<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>News</title>
+  <entry>
+    <title>Snow Storm</title>
+    <link href="http://example.org/snow-storm" />
+    <author><name>John Doe</name></author>
+    <summary>Snow &amp; ice</summary>
+    <content type="html">&lt;p&gt;Snow is coming&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>Blizzard</title>
+    <link href="http://example.org/blizzard" />
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Wind</p></div></content>
+  </entry>
+</feed>
+`),
+		&data.ParsedFeed{
+			Name: "News",
+			Items: []data.ParsedItem{
+				{
+					Title:   "Snow Storm",
+					URL:     "http://example.org/snow-storm",
+					Author:  "John Doe",
+					Summary: "Snow &amp; ice",
+					Content: "<p>Snow is coming</p>",
+				},
+				{
+					Title:   "Blizzard",
+					URL:     "http://example.org/blizzard",
+					Content: `<div xmlns="http://www.w3.org/1999/xhtml"><p>Wind</p></div>`,
+				},
+			}},
+		"",
+	},
+	{"RSS - Podcast enclosures",
+		[]byte(`<?xml version="1.0" encoding="utf-8" ?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:media="http://search.yahoo.com/mrss/">
+  <channel>
+    <title>Podcast</title>
+    <item>
+      <title>Episode 1</title>
+      <link>http://example.org/1</link>
+      <enclosure url="http://example.org/1.mp3" length="12345678" type="audio/mpeg" />
+      <itunes:duration>1:02:03</itunes:duration>
+      <media:thumbnail url="http://example.org/1.jpg" />
+    </item>
+    <item>
+      <title>Episode 2</title>
+      <link>http://example.org/2</link>
+      <enclosure url="http://example.org/2.mp4" type="video/mp4" />
+      <media:content url="http://example.org/2.mp4" fileSize="1000" duration="90">
+        <media:thumbnail url="http://example.org/2.jpg" />
+      </media:content>
+    </item>
+  </channel>
+</rss>
+`),
+		&data.ParsedFeed{
+			Name: "Podcast",
+			Items: []data.ParsedItem{
+				{
+					Title: "Episode 1",
+					URL:   "http://example.org/1",
+					Enclosures: []data.ParsedEnclosure{
+						{URL: "http://example.org/1.mp3", Type: "audio/mpeg", Length: 12345678, Duration: 3723, ThumbnailURL: "http://example.org/1.jpg"},
+					},
+				},
+				{
+					Title: "Episode 2",
+					URL:   "http://example.org/2",
+					Enclosures: []data.ParsedEnclosure{
+						{URL: "http://example.org/2.mp4", Type: "video/mp4", Length: 1000, Duration: 90, ThumbnailURL: "http://example.org/2.jpg"},
+					},
+				},
+			}},
+		"",
+	},
+	{"Atom - Enclosure links and media group",
+		[]byte(`<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <title>Videos</title>
+  <entry>
+    <title>Snow Storm</title>
+    <link rel="enclosure" href="http://example.org/snow-storm.ogg" type="audio/ogg" length="2048" />
+    <link rel="alternate" href="http://example.org/snow-storm" />
+  </entry>
+  <entry>
+    <title>Blizzard</title>
+    <link href="http://example.org/blizzard" />
+    <media:group>
+      <media:content url="http://example.org/blizzard.swf" type="application/x-shockwave-flash" />
+      <media:thumbnail url="http://example.org/blizzard.jpg" />
+    </media:group>
+  </entry>
+</feed>
+`),
+		&data.ParsedFeed{
+			Name: "Videos",
+			Items: []data.ParsedItem{
+				{
+					Title: "Snow Storm",
+					URL:   "http://example.org/snow-storm",
+					Enclosures: []data.ParsedEnclosure{
+						{URL: "http://example.org/snow-storm.ogg", Type: "audio/ogg", Length: 2048},
+					},
+				},
+				{
+					Title: "Blizzard",
+					URL:   "http://example.org/blizzard",
+					Enclosures: []data.ParsedEnclosure{
+						{URL: "http://example.org/blizzard.swf", Type: "application/x-shockwave-flash", ThumbnailURL: "http://example.org/blizzard.jpg"},
+					},
+				},
+			}},
+		"",
+	},
+	{"RSS - Update interval",
+		[]byte(`<?xml version="1.0" encoding="utf-8" ?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+  <channel>
+    <title>News</title>
+    <ttl>60</ttl>
+    <sy:updatePeriod>daily</sy:updatePeriod>
+    <sy:updateFrequency>4</sy:updateFrequency>
+    <item>
+      <title>Snow Storm</title>
+      <link>http://example.org/snow-storm</link>
+    </item>
+  </channel>
+</rss>
+`),
+		&data.ParsedFeed{
+			Name:           "News",
+			UpdateInterval: 6 * time.Hour,
+			Items: []data.ParsedItem{
+				{
+					Title: "Snow Storm",
+					URL:   "http://example.org/snow-storm",
+				},
+			}},
+		"",
+	},
+	{"JSON Feed - Minimal",
+		[]byte(`{
+  "version": "https://jsonfeed.org/version/1",
+  "title": "News",
+  "items": [
+    {
+      "id": "1",
+      "title": "Snow Storm",
+      "url": "http://example.org/snow-storm",
+      "date_published": "2014-01-03T22:45:00Z"
+    },
+    {
+      "id": "2",
+      "title": "Blizzard",
+      "url": "http://example.org/blizzard",
+      "date_modified": "2014-01-04T08:15:00Z"
+    }
+  ]
+}`),
+		&data.ParsedFeed{
+			Name: "News",
+			Items: []data.ParsedItem{
+				{
+					Title:           "Snow Storm",
+					URL:             "http://example.org/snow-storm",
+					PublicationTime: pgtype.Timestamptz{Time: time.Date(2014, 1, 3, 22, 45, 0, 0, time.UTC), Status: pgtype.Present},
+				},
+				{
+					Title:           "Blizzard",
+					URL:             "http://example.org/blizzard",
+					PublicationTime: pgtype.Timestamptz{Time: time.Date(2014, 1, 4, 8, 15, 0, 0, time.UTC), Status: pgtype.Present},
+				},
+			}},
+		"",
+	},
+	{"JSON Feed - v1.1 without titles",
+		[]byte(`{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Microblog",
+  "items": [
+    {
+      "id": "http://example.org/2014/01/03/snow",
+      "content_text": "Snow storm tonight.\nStay inside.",
+      "date_published": "2014-01-03T17:45:00-05:00"
+    },
+    {
+      "id": "2",
+      "external_url": "http://example.org/blizzard",
+      "summary": "Blizzard"
+    }
+  ]
+}`),
+		&data.ParsedFeed{
+			Name: "Microblog",
+			Items: []data.ParsedItem{
+				{
+					Title:           "Snow storm tonight.",
+					URL:             "http://example.org/2014/01/03/snow",
+					Content:         "Snow storm tonight.\nStay inside.",
+					PublicationTime: pgtype.Timestamptz{Time: time.Date(2014, 1, 3, 22, 45, 0, 0, time.UTC), Status: pgtype.Present},
+				},
+				{
+					Title:   "Blizzard",
+					URL:     "http://example.org/blizzard",
+					Summary: "Blizzard",
+				},
+			}},
+		"",
+	},
+	{"JSON Feed - Missing version is not a feed",
+		[]byte(`{"title": "News", "items": []}`),
+		nil,
+		"EOF",
+	},
+}
+
+func TestIsJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"application/feed+json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/rss+xml", false},
+		{"text/html", false},
+		{"", false},
+	}
+
+	for i, tt := range tests {
+		actual := isJSONContentType(tt.contentType)
+		if actual != tt.expected {
+			t.Errorf("%d. %s: expected %v, but it was %v", i, tt.contentType, tt.expected, actual)
+		}
+	}
 }
 
 func TestParseFeed(t *testing.T) {
@@ -265,6 +542,9 @@ func TestParseFeed(t *testing.T) {
 		if actual.Name != tt.parsedFeed.Name {
 			t.Errorf("%d. %s: Expected name to be %#v, but it was %#v", i, tt.name, tt.parsedFeed.Name, actual.Name)
 		}
+		if actual.UpdateInterval != tt.parsedFeed.UpdateInterval {
+			t.Errorf("%d. %s: Expected update interval to be %v, but it was %v", i, tt.name, tt.parsedFeed.UpdateInterval, actual.UpdateInterval)
+		}
 		if len(actual.Items) != len(tt.parsedFeed.Items) {
 			t.Errorf("%d. %s: Expected %d items, but instead found %d items", i, tt.name, len(tt.parsedFeed.Items), len(actual.Items))
 			continue
@@ -277,6 +557,18 @@ func TestParseFeed(t *testing.T) {
 			if actualItem.URL != expectedItem.URL {
 				t.Errorf("%d. %s Item %d: Expected url %#v, but is was %#v", i, tt.name, j, expectedItem.URL, actualItem.URL)
 			}
+			if actualItem.Author != expectedItem.Author {
+				t.Errorf("%d. %s Item %d: Expected author %#v, but is was %#v", i, tt.name, j, expectedItem.Author, actualItem.Author)
+			}
+			if actualItem.Summary != expectedItem.Summary {
+				t.Errorf("%d. %s Item %d: Expected summary %#v, but is was %#v", i, tt.name, j, expectedItem.Summary, actualItem.Summary)
+			}
+			if actualItem.Content != expectedItem.Content {
+				t.Errorf("%d. %s Item %d: Expected content %#v, but is was %#v", i, tt.name, j, expectedItem.Content, actualItem.Content)
+			}
+			if !reflect.DeepEqual(actualItem.Enclosures, expectedItem.Enclosures) {
+				t.Errorf("%d. %s Item %d: Expected enclosures %#v, but is was %#v", i, tt.name, j, expectedItem.Enclosures, actualItem.Enclosures)
+			}
 			if actualItem.PublicationTime.Status == expectedItem.PublicationTime.Status {
 				if actualItem.PublicationTime.Status == pgtype.Present && !actualItem.PublicationTime.Time.Equal(expectedItem.PublicationTime.Time) {
 					t.Errorf("%d. %s Item %d: Expected publicationTime %v, but is was %v", i, tt.name, j, expectedItem.PublicationTime, actualItem.PublicationTime)
@@ -316,6 +608,124 @@ func TestParseTime(t *testing.T) {
 	}
 }
 
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		unparsed string
+		expected int32
+	}{
+		{"3600", 3600},
+		{"90.5", 90},
+		{"05:30", 330},
+		{"1:02:03", 3723},
+		{"", 0},
+		{"unknown", 0},
+		{"-5", 0},
+	}
+
+	for i, tt := range tests {
+		actual := parseDuration(tt.unparsed)
+		if actual != tt.expected {
+			t.Errorf("%d. %s: expected %d, but it was %d", i, tt.unparsed, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseUpdateInterval(t *testing.T) {
+	tests := []struct {
+		ttl             string
+		updatePeriod    string
+		updateFrequency string
+		expected        time.Duration
+	}{
+		{"", "", "", 0},
+		{"30", "", "", 30 * time.Minute},
+		{"", "hourly", "", time.Hour},
+		{"", "daily", "2", 12 * time.Hour},
+		{"", "weekly", "bad", 7 * 24 * time.Hour},
+		{"120", "hourly", "1", 2 * time.Hour},
+		{"bad", "sometimes", "", 0},
+	}
+
+	for i, tt := range tests {
+		actual := parseUpdateInterval(tt.ttl, tt.updatePeriod, tt.updateFrequency)
+		if actual != tt.expected {
+			t.Errorf("%d. %v: expected %v, but it was %v", i, tt, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseCacheLifetime(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		{http.Header{}, 0},
+		{http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour},
+		{http.Header{"Cache-Control": {"no-cache"}, "Expires": {"Sat, 04 Jan 2014 09:00:00 GMT"}}, 0},
+		{http.Header{"Cache-Control": {"max-age=0"}}, 0},
+		{http.Header{"Date": {"Sat, 04 Jan 2014 08:00:00 GMT"}, "Expires": {"Sat, 04 Jan 2014 09:30:00 GMT"}}, 90 * time.Minute},
+		{http.Header{"Date": {"Sat, 04 Jan 2014 08:00:00 GMT"}, "Expires": {"0"}}, 0},
+	}
+
+	for i, tt := range tests {
+		actual := parseCacheLifetime(tt.header)
+		if actual != tt.expected {
+			t.Errorf("%d. %v: expected %v, but it was %v", i, tt.header, tt.expected, actual)
+		}
+	}
+}
+
+func TestFeedUpdaterFetchInterval(t *testing.T) {
+	now := time.Date(2014, 1, 10, 12, 0, 0, 0, time.UTC)
+	hoursAgo := func(n int) data.ParsedItem {
+		return data.ParsedItem{PublicationTime: pgtype.Timestamptz{Time: now.Add(time.Duration(-n) * time.Hour), Status: pgtype.Present}}
+	}
+
+	tests := []struct {
+		descr         string
+		feed          *data.ParsedFeed
+		cacheLifetime time.Duration
+		expected      time.Duration
+	}{
+		{"No publication times", &data.ParsedFeed{Items: []data.ParsedItem{{}}}, 0, 10 * time.Minute},
+		{"Hourly posts", &data.ParsedFeed{Items: []data.ParsedItem{hoursAgo(1), hoursAgo(2), hoursAgo(3), hoursAgo(4)}}, 0, 30 * time.Minute},
+		{"Quiet feed", &data.ParsedFeed{Items: []data.ParsedItem{hoursAgo(24 * 30), hoursAgo(24 * 60)}}, 0, 24 * time.Hour},
+		{"Publisher update interval", &data.ParsedFeed{UpdateInterval: 3 * time.Hour, Items: []data.ParsedItem{hoursAgo(1), hoursAgo(2)}}, 0, 3 * time.Hour},
+		{"Cache lifetime", &data.ParsedFeed{Items: []data.ParsedItem{hoursAgo(1), hoursAgo(2)}}, 2 * time.Hour, 2 * time.Hour},
+		{"Minimum interval", &data.ParsedFeed{Items: []data.ParsedItem{hoursAgo(0), hoursAgo(0)}}, 0, 10 * time.Minute},
+	}
+
+	u := NewFeedUpdater(nil, log.Root())
+	for _, tt := range tests {
+		actual := u.fetchInterval(tt.feed, tt.cacheLifetime, now)
+		if actual != tt.expected {
+			t.Errorf("%s: expected %v, but it was %v", tt.descr, tt.expected, actual)
+		}
+	}
+}
+
+func TestFeedUpdaterFailureBackoff(t *testing.T) {
+	tests := []struct {
+		failureCount int32
+		expected     time.Duration
+	}{
+		{1, 10 * time.Minute},
+		{2, 20 * time.Minute},
+		{3, 40 * time.Minute},
+		{8, 1280 * time.Minute},
+		{9, 24 * time.Hour},
+		{1000, 24 * time.Hour},
+	}
+
+	u := NewFeedUpdater(nil, log.Root())
+	for _, tt := range tests {
+		actual := u.failureBackoff(tt.failureCount)
+		if actual != tt.expected {
+			t.Errorf("%d failures: expected %v, but it was %v", tt.failureCount, tt.expected, actual)
+		}
+	}
+}
+
 func TestFetchFeed(t *testing.T) {
 	pool := newConnPool(t)
 
@@ -343,7 +753,7 @@ func TestFetchFeed(t *testing.T) {
 	defer ts.Close()
 
 	u := NewFeedUpdater(pool, log.Root())
-	rawFeed, err := u.fetchFeed(ts.URL, pgtype.Varchar{})
+	rawFeed, err := u.fetchFeed(ts.URL, pgtype.Varchar{}, pgtype.Varchar{})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -356,4 +766,136 @@ func TestFetchFeed(t *testing.T) {
 	if rawFeed.etag.Status != pgtype.Null {
 		t.Errorf("Expected no ETag to be null but instead it was: %v", rawFeed.etag)
 	}
+	if rawFeed.lastModified.Status != pgtype.Null {
+		t.Errorf("Expected no Last-Modified to be null but instead it was: %v", rawFeed.lastModified)
+	}
+}
+
+func TestFetchFeedConditionalGet(t *testing.T) {
+	lastModified := "Sat, 04 Jan 2014 08:15:00 GMT"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte("<rss></rss>"))
+	}))
+	defer ts.Close()
+
+	u := NewFeedUpdater(nil, log.Root())
+	rawFeed, err := u.fetchFeed(ts.URL, pgtype.Varchar{Status: pgtype.Null}, pgtype.Varchar{Status: pgtype.Null})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rawFeed.notModified {
+		t.Fatal("Expected first fetch to not be a 304")
+	}
+	if rawFeed.lastModified.String != lastModified {
+		t.Fatalf("Expected Last-Modified to be %v but instead it was: %v", lastModified, rawFeed.lastModified)
+	}
+
+	rawFeed, err = u.fetchFeed(ts.URL, pgtype.Varchar{Status: pgtype.Null}, rawFeed.lastModified)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rawFeed.notModified {
+		t.Error("Expected second fetch with If-Modified-Since to be a 304")
+	}
+}
+
+func TestFetchFeedRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<rss></rss>"))
+	})
+	mux.Handle("/moved", http.RedirectHandler("/feed", http.StatusMovedPermanently))
+	mux.Handle("/moved-again", http.RedirectHandler("/moved", http.StatusPermanentRedirect))
+	mux.Handle("/temporary", http.RedirectHandler("/feed", http.StatusFound))
+	mux.Handle("/temporary-then-moved", http.RedirectHandler("/moved", http.StatusTemporaryRedirect))
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path         string
+		permanentURL string
+	}{
+		{"/feed", ""},
+		{"/moved", ts.URL + "/feed"},
+		{"/moved-again", ts.URL + "/feed"},
+		{"/temporary", ""},
+		{"/temporary-then-moved", ""},
+	}
+
+	u := NewFeedUpdater(nil, log.Root())
+	for _, tt := range tests {
+		rawFeed, err := u.fetchFeed(ts.URL+tt.path, pgtype.Varchar{Status: pgtype.Null}, pgtype.Varchar{Status: pgtype.Null})
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.path, err)
+			continue
+		}
+		if rawFeed.url != ts.URL+"/feed" {
+			t.Errorf("%s: Expected url to be %v but instead it was: %v", tt.path, ts.URL+"/feed", rawFeed.url)
+		}
+		if rawFeed.permanentURL != tt.permanentURL {
+			t.Errorf("%s: Expected permanentURL to be %#v but instead it was: %#v", tt.path, tt.permanentURL, rawFeed.permanentURL)
+		}
+	}
+
+	_, err := u.fetchFeed(ts.URL+"/gone", pgtype.Varchar{Status: pgtype.Null}, pgtype.Varchar{Status: pgtype.Null})
+	if err != errFeedGone {
+		t.Errorf("Expected errFeedGone but instead it was: %v", err)
+	}
+}
+
+func TestFeedUpdaterValidateFeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.rss", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss version="2.0"><channel><title>News</title><item><title>Snow</title><link>/snow</link></item></channel></rss>`))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>Not a feed</title></head></html>`))
+	})
+	mux.HandleFunc("/gone.rss", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	u := NewFeedUpdater(nil, log.Root())
+
+	_, feed, err := u.validateFeed(ts.URL + "/feed.rss")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if feed.Name != "News" {
+		t.Errorf("Expected name %v, got %v", "News", feed.Name)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].URL != ts.URL+"/snow" {
+		t.Errorf("Expected one sanitized item, got %#v", feed.Items)
+	}
+
+	tests := []struct {
+		path string
+		code string
+	}{
+		{"/missing.rss", "fetch_failed"},
+		{"/gone.rss", "gone"},
+		{"/page.html", "parse_failed"},
+	}
+	for _, tt := range tests {
+		_, _, err := u.validateFeed(ts.URL + tt.path)
+		verr, ok := err.(*feedValidationError)
+		if !ok {
+			t.Errorf("%s: Expected *feedValidationError, got %#v", tt.path, err)
+			continue
+		}
+		if verr.Code != tt.code {
+			t.Errorf("%s: Expected code %v, got %v", tt.path, tt.code, verr.Code)
+		}
+	}
 }
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// feverAPIVersion is the version of the Fever API that is implemented.
+// See https://feedafever.com/api.
+const feverAPIVersion = 3
+
+// maxFeverItems is how many items the Fever API returns per request.
+const maxFeverItems = 50
+
+// feverAPIKey derives the Fever API key of login (user name or email) and
+// password the same way Fever clients do.
+func feverAPIKey(login, password string) string {
+	sum := md5.Sum([]byte(login + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// feverAPIKeys returns the Fever API keys of a user so Fever clients can log in
+// with either the user name or email.
+func feverAPIKeys(name string, email pgtype.Varchar, password string) []string {
+	keys := []string{feverAPIKey(name, password)}
+	if email.Status == pgtype.Present && email.String != "" {
+		keys = append(keys, feverAPIKey(email.String, password))
+	}
+	return keys
+}
+
+// setFeverAPIKeys enables the Fever API for a user by storing the keys derived
+// from password. The keys are unsalted hashes of the password so they are
+// only stored for users who opt in.
+func setFeverAPIKeys(db *pgxpool.Pool, userID int32, name string, email pgtype.Varchar, password string) error {
+	return data.SetFeverAPIKeys(context.Background(), db, userID, feverAPIKeys(name, email, password))
+}
+
+// updateFeverAPIKeys keeps the Fever API keys of a user in sync with a changed
+// password or email. It does nothing for users who have not enabled the Fever
+// API.
+func updateFeverAPIKeys(db *pgxpool.Pool, userID int32, name string, email pgtype.Varchar, password string) error {
+	_, err := data.UpdateFeverAPIKeys(context.Background(), db, userID, feverAPIKeys(name, email, password))
+	return err
+}
+
+// NewFeverHandler serves the Fever API. Clients authenticate every request
+// with an api_key derived from their credentials by feverAPIKey. Keys are
+// only stored for users who enable the Fever API with EnableFeverHandler.
+func NewFeverHandler(pool *pgxpool.Pool, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		response := map[string]interface{}{"api_version": feverAPIVersion, "auth": 0}
+
+		userID, err := data.SelectUserIDByFeverAPIKey(context.Background(), pool, strings.ToLower(req.FormValue("api_key")))
+		if err == nil {
+			response["auth"] = 1
+			err = serveFever(req, pool, userID, response)
+		} else if err == data.ErrNotFound {
+			err = nil
+		}
+		if err != nil {
+			logger.Error("fever request failed", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// serveFever adds the data requested by req to response.
+func serveFever(req *http.Request, pool *pgxpool.Pool, userID int32, response map[string]interface{}) error {
+	ctx := context.Background()
+
+	lastFetchTime, err := data.SelectLastFetchTimeForUser(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+	response["last_refreshed_on_time"] = feverTime(lastFetchTime)
+
+	if hasFeverParam(req, "mark") {
+		if err := markFever(req, pool, userID, response); err != nil {
+			return err
+		}
+	}
+
+	if hasFeverParam(req, "groups") || hasFeverParam(req, "feeds") {
+		subscriptions, err := data.SelectSubscriptions(ctx, pool, userID)
+		if err != nil {
+			return err
+		}
+
+		if hasFeverParam(req, "groups") {
+			folders, err := data.SelectFolders(ctx, pool, userID)
+			if err != nil {
+				return err
+			}
+
+			groups := make([]feverGroup, 0, len(folders))
+			for _, f := range folders {
+				groups = append(groups, feverGroup{ID: f.ID.Int, Title: f.Name.String})
+			}
+			response["groups"] = groups
+		}
+
+		if hasFeverParam(req, "feeds") {
+			feeds := make([]feverFeed, 0, len(subscriptions))
+			for _, s := range subscriptions {
+				feeds = append(feeds, feverFeed{
+					ID:                s.FeedID.Int,
+					Title:             s.Name.String,
+					URL:               s.URL.String,
+					LastUpdatedOnTime: feverTime(s.LastFetchTime),
+				})
+			}
+			response["feeds"] = feeds
+		}
+
+		response["feeds_groups"] = feverFeedsGroups(subscriptions)
+	}
+
+	if hasFeverParam(req, "favicons") {
+		// Favicons are not stored so there are none to return
+		response["favicons"] = []struct{}{}
+	}
+
+	if hasFeverParam(req, "items") {
+		query := data.FeverItemQuery{Limit: maxFeverItems}
+		query.SinceID = parseFeverID(req.FormValue("since_id"))
+		query.MaxID = parseFeverID(req.FormValue("max_id"))
+		if hasFeverParam(req, "with_ids") {
+			query.WithIDs = parseFeverIDs(req.FormValue("with_ids"))
+			if len(query.WithIDs) > maxFeverItems {
+				query.WithIDs = query.WithIDs[:maxFeverItems]
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if err := data.CopyFeverItemsAsJSON(ctx, pool, buf, userID, query); err != nil {
+			return err
+		}
+		response["items"] = json.RawMessage(buf.Bytes())
+
+		total, err := data.CountFeverItems(ctx, pool, userID)
+		if err != nil {
+			return err
+		}
+		response["total_items"] = total
+	}
+
+	if hasFeverParam(req, "unread_item_ids") {
+		if err := addFeverUnreadItemIDs(pool, userID, response); err != nil {
+			return err
+		}
+	}
+
+	if hasFeverParam(req, "saved_item_ids") {
+		if err := addFeverSavedItemIDs(pool, userID, response); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markFever changes the state of an item or marks a feed or group read as
+// requested by the mark, as, id, and before parameters. Group 0 is all feeds.
+func markFever(req *http.Request, pool *pgxpool.Pool, userID int32, response map[string]interface{}) error {
+	ctx := context.Background()
+	id := parseFeverID(req.FormValue("id"))
+	as := req.FormValue("as")
+
+	var err error
+	switch req.FormValue("mark") {
+	case "item":
+		switch as {
+		case "read":
+			err = data.MarkItemRead(ctx, pool, userID, id)
+		case "unread":
+			err = data.MarkItemUnread(ctx, pool, userID, id)
+		case "saved":
+			err = data.StarItem(ctx, pool, userID, id)
+		case "unsaved":
+			err = data.UnstarItem(ctx, pool, userID, id)
+		default:
+			return nil
+		}
+	case "feed":
+		if as != "read" || id == 0 {
+			return nil
+		}
+		_, err = data.MarkItemsRead(ctx, pool, userID, data.ItemFilter{FeedID: id}, parseFeverTime(req.FormValue("before")))
+	case "group":
+		// Negative groups are special groups of Fever itself
+		n, parseErr := strconv.ParseInt(req.FormValue("id"), 10, 32)
+		if as != "read" || parseErr != nil || n < 0 {
+			return nil
+		}
+		_, err = data.MarkItemsRead(ctx, pool, userID, data.ItemFilter{FolderID: id}, parseFeverTime(req.FormValue("before")))
+	default:
+		return nil
+	}
+	if err != nil && err != data.ErrNotFound {
+		return err
+	}
+
+	if as == "saved" || as == "unsaved" {
+		return addFeverSavedItemIDs(pool, userID, response)
+	}
+	return addFeverUnreadItemIDs(pool, userID, response)
+}
+
+type feverGroup struct {
+	ID    int32  `json:"id"`
+	Title string `json:"title"`
+}
+
+type feverFeed struct {
+	ID                int32  `json:"id"`
+	FaviconID         int32  `json:"favicon_id"`
+	Title             string `json:"title"`
+	URL               string `json:"url"`
+	SiteURL           string `json:"site_url"`
+	IsSpark           int32  `json:"is_spark"`
+	LastUpdatedOnTime int64  `json:"last_updated_on_time"`
+}
+
+type feverFeedsGroup struct {
+	GroupID int32  `json:"group_id"`
+	FeedIDs string `json:"feed_ids"`
+}
+
+// feverFeedsGroups lists the feeds of each folder.
+func feverFeedsGroups(subscriptions []data.Subscription) []feverFeedsGroup {
+	feedsGroups := make([]feverFeedsGroup, 0)
+	feedIDsByFolder := make(map[int32][]int32)
+	for _, s := range subscriptions {
+		if s.FolderID.Status != pgtype.Present {
+			continue
+		}
+
+		folderID := s.FolderID.Int
+		if _, ok := feedIDsByFolder[folderID]; !ok {
+			feedsGroups = append(feedsGroups, feverFeedsGroup{GroupID: folderID})
+		}
+		feedIDsByFolder[folderID] = append(feedIDsByFolder[folderID], s.FeedID.Int)
+	}
+
+	for i := range feedsGroups {
+		feedsGroups[i].FeedIDs = joinFeverIDs(feedIDsByFolder[feedsGroups[i].GroupID])
+	}
+
+	return feedsGroups
+}
+
+func addFeverUnreadItemIDs(pool *pgxpool.Pool, userID int32, response map[string]interface{}) error {
+	itemIDs, err := data.SelectUnreadItemIDs(context.Background(), pool, userID)
+	if err != nil {
+		return err
+	}
+	response["unread_item_ids"] = joinFeverIDs(itemIDs)
+	return nil
+}
+
+func addFeverSavedItemIDs(pool *pgxpool.Pool, userID int32, response map[string]interface{}) error {
+	itemIDs, err := data.SelectStarredItemIDs(context.Background(), pool, userID)
+	if err != nil {
+		return err
+	}
+	response["saved_item_ids"] = joinFeverIDs(itemIDs)
+	return nil
+}
+
+// hasFeverParam returns true if the request has the parameter name. Fever
+// requests are things like "?api&items" so the parameter value is often empty.
+func hasFeverParam(req *http.Request, name string) bool {
+	_, ok := req.Form[name]
+	return ok
+}
+
+// parseFeverID parses s as an ID. Anything that is not a valid ID is 0.
+func parseFeverID(s string) int32 {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return int32(n)
+}
+
+// parseFeverIDs parses a comma separated list of IDs. Invalid IDs are skipped.
+func parseFeverIDs(s string) []int32 {
+	ids := make([]int32, 0)
+	for _, field := range strings.Split(s, ",") {
+		if id := parseFeverID(strings.TrimSpace(field)); id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// parseFeverTime parses s as Unix time. Anything that is not a valid time is
+// the zero time.
+func parseFeverTime(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
+
+// joinFeverIDs formats ids as a comma separated list.
+func joinFeverIDs(ids []int32) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.FormatInt(int64(id), 10)
+	}
+	return strings.Join(fields, ",")
+}
+
+// feverTime converts t to Unix time. It is 0 if t is not present.
+func feverTime(t pgtype.Timestamptz) int64 {
+	if t.Status != pgtype.Present {
+		return 0
+	}
+	return t.Time.Unix()
+}
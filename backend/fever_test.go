@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+)
+
+func TestFeverAPIKey(t *testing.T) {
+	// md5 of "test@example.com:password"
+	expected := "6f817426bb911f5813a32cbfd00ffcd3"
+	if key := feverAPIKey("test@example.com", "password"); key != expected {
+		t.Errorf("Expected %s, got %s", expected, key)
+	}
+}
+
+func TestParseFeverIDs(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected []int32
+	}{
+		{"", []int32{}},
+		{"1", []int32{1}},
+		{"1,2, 3", []int32{1, 2, 3}},
+		{"1,foo,-2,3", []int32{1, 3}},
+	}
+
+	for i, tt := range tests {
+		ids := parseFeverIDs(tt.s)
+		if !reflect.DeepEqual(ids, tt.expected) {
+			t.Errorf("%d. Expected %v, got %v", i, tt.expected, ids)
+		}
+	}
+}
+
+func TestJoinFeverIDs(t *testing.T) {
+	if s := joinFeverIDs([]int32{1, 2, 3}); s != "1,2,3" {
+		t.Errorf(`Expected "1,2,3", got %q`, s)
+	}
+	if s := joinFeverIDs(nil); s != "" {
+		t.Errorf(`Expected "", got %q`, s)
+	}
+}
+
+func TestFeverHandler(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	user.Email = pgtype.Varchar{String: "test@example.com", Status: pgtype.Present}
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = setFeverAPIKeys(pool, userID, "test", user.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderID, err := data.CreateFolder(context.Background(), pool, userID, "News")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.SetSubscriptionFolder(context.Background(), pool, userID, feedID, pgtype.Int4{Int: folderID, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewFeverHandler(pool, getLogger(t))
+
+	fever := func(query string, apiKey string) map[string]json.RawMessage {
+		form := url.Values{"api_key": {apiKey}}
+		req, err := http.NewRequest("POST", "http://example.com/fever/?api&"+query, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var response map[string]json.RawMessage
+		err = json.Unmarshal(w.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	response := fever("items", feverAPIKey("test", "wrong"))
+	if string(response["auth"]) != "0" || response["items"] != nil {
+		t.Fatalf("Expected unauthenticated response, got %v", response)
+	}
+
+	apiKey := feverAPIKey("test@example.com", "password")
+
+	response = fever("groups", apiKey)
+	var feedsGroups []feverFeedsGroup
+	err = json.Unmarshal(response["feeds_groups"], &feedsGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFeedsGroups := []feverFeedsGroup{{GroupID: folderID, FeedIDs: joinFeverIDs([]int32{feedID})}}
+	if !reflect.DeepEqual(feedsGroups, expectedFeedsGroups) {
+		t.Errorf("Expected %v, got %v", expectedFeedsGroups, feedsGroups)
+	}
+
+	var items []struct {
+		ID     int32  `json:"id"`
+		Title  string `json:"title"`
+		IsRead int    `json:"is_read"`
+	}
+	response = fever("items&since_id=0", apiKey)
+	err = json.Unmarshal(response["items"], &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Title != "One" || items[0].IsRead != 0 {
+		t.Fatalf("Unexpected items: %s", response["items"])
+	}
+
+	response = fever("items&since_id="+joinFeverIDs([]int32{items[0].ID}), apiKey)
+	err = json.Unmarshal(response["items"], &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Title != "Two" {
+		t.Fatalf("Unexpected items: %s", response["items"])
+	}
+
+	response = fever("mark=item&as=read&id="+joinFeverIDs([]int32{items[0].ID}), apiKey)
+	var unreadItemIDs string
+	err = json.Unmarshal(response["unread_item_ids"], &unreadItemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(unreadItemIDs, ",") != 0 || unreadItemIDs == "" {
+		t.Errorf("Expected one unread item, got %q", unreadItemIDs)
+	}
+
+	response = fever("mark=group&as=read&id=0", apiKey)
+	err = json.Unmarshal(response["unread_item_ids"], &unreadItemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unreadItemIDs != "" {
+		t.Errorf("Expected no unread items, got %q", unreadItemIDs)
+	}
+}
+
+func TestFeverOptIn(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	SetPassword(user, "password")
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = data.SelectUserByPK(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are not stored for users who have not enabled the Fever API
+	err = updateFeverAPIKeys(pool, userID, "test", user.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled, err := data.SelectFeverEnabled(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Fatal("Expected Fever API to not be enabled")
+	}
+
+	env := &environment{user: user, pool: pool}
+	enable := func(password string) int {
+		req, err := http.NewRequest("PUT", "http://example.com/api/account/fever", strings.NewReader(`{"password":"`+password+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		EnableFeverHandler(w, req, env)
+		return w.Code
+	}
+
+	if code := enable("wrong"); code != 422 {
+		t.Errorf("Expected HTTP status 422 for bad password, got %d", code)
+	}
+	if code := enable("password"); code != http.StatusNoContent {
+		t.Fatalf("Expected HTTP status 204, got %d", code)
+	}
+
+	keyUserID, err := data.SelectUserIDByFeverAPIKey(context.Background(), pool, feverAPIKey("test", "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyUserID != userID {
+		t.Errorf("Expected user %d, got %d", userID, keyUserID)
+	}
+
+	// A new password replaces the keys of a user who enabled the Fever API
+	err = updateFeverAPIKeys(pool, userID, "test", user.Email, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = data.SelectUserIDByFeverAPIKey(context.Background(), pool, feverAPIKey("test", "password"))
+	if err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for key of old password, got %v", err)
+	}
+	_, err = data.SelectUserIDByFeverAPIKey(context.Background(), pool, feverAPIKey("test", "secret"))
+	if err != nil {
+		t.Errorf("Expected key of new password to be stored, got %v", err)
+	}
+
+	req, err := http.NewRequest("DELETE", "http://example.com/api/account/fever", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	DisableFeverHandler(w, req, env)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected HTTP status 204, got %d", w.Code)
+	}
+
+	enabled, err = data.SelectFeverEnabled(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Error("Expected Fever API to be disabled")
+	}
+}
@@ -0,0 +1,843 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Stream and tag IDs of the Google Reader API. User specific IDs are always
+// written with "-" as the user.
+const (
+	greaderReadingList = "user/-/state/com.google/reading-list"
+	greaderRead        = "user/-/state/com.google/read"
+	greaderKeptUnread  = "user/-/state/com.google/kept-unread"
+	greaderStarred     = "user/-/state/com.google/starred"
+	greaderLabelPrefix = "user/-/label/"
+	greaderFeedPrefix  = "feed/"
+	greaderItemPrefix  = "tag:google.com,2005:reader/item/"
+)
+
+const (
+	defaultGReaderItems = 20
+	maxGReaderItemIDs   = 10000
+)
+
+var greaderUserPrefix = regexp.MustCompile(`^user/[^/]+/`)
+
+// NewGReaderHandler serves the Google Reader API used by many feed reading
+// clients. Clients get a session token from /accounts/ClientLogin and send it
+// in the Authorization header of every /reader/api/0/ request.
+func NewGReaderHandler(pool *pgxpool.Pool, logger log.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	handle := func(path string, f EnvHandlerFunc) {
+		mux.Handle(path, GReaderEnvHandler(pool, logger, f))
+	}
+
+	handle("/accounts/ClientLogin", GReaderClientLoginHandler)
+	handle("/reader/api/0/token", GReaderAuthenticatedHandler(GReaderTokenHandler))
+	handle("/reader/api/0/user-info", GReaderAuthenticatedHandler(GReaderUserInfoHandler))
+	handle("/reader/api/0/subscription/list", GReaderAuthenticatedHandler(GReaderSubscriptionListHandler))
+	handle("/reader/api/0/subscription/quickadd", GReaderAuthenticatedHandler(GReaderPostHandler(GReaderQuickAddHandler)))
+	handle("/reader/api/0/subscription/edit", GReaderAuthenticatedHandler(GReaderPostHandler(GReaderSubscriptionEditHandler)))
+	handle("/reader/api/0/tag/list", GReaderAuthenticatedHandler(GReaderTagListHandler))
+	handle("/reader/api/0/unread-count", GReaderAuthenticatedHandler(GReaderUnreadCountHandler))
+	handle("/reader/api/0/stream/items/ids", GReaderAuthenticatedHandler(GReaderStreamItemIDsHandler))
+	handle("/reader/api/0/stream/items/contents", GReaderAuthenticatedHandler(GReaderStreamItemContentsHandler))
+	handle("/reader/api/0/stream/contents/", GReaderAuthenticatedHandler(GReaderStreamContentsHandler))
+	handle("/reader/api/0/edit-tag", GReaderAuthenticatedHandler(GReaderPostHandler(GReaderEditTagHandler)))
+	handle("/reader/api/0/mark-all-as-read", GReaderAuthenticatedHandler(GReaderPostHandler(GReaderMarkAllAsReadHandler)))
+
+	return mux
+}
+
+// GReaderEnvHandler is EnvHandler for the Google Reader API. Clients send the
+// token from ClientLogin in the Authorization header instead of
+// X-Authentication, so it is only accepted here.
+func GReaderEnvHandler(pool *pgxpool.Pool, logger log.Logger, f EnvHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		env := &environment{pool: pool, logger: logger}
+		if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "GoogleLogin auth=") {
+			env.user = getUserBySessionToken(strings.TrimPrefix(auth, "GoogleLogin auth="), pool)
+		}
+		f(w, req, env)
+	})
+}
+
+func GReaderAuthenticatedHandler(f EnvHandlerFunc) EnvHandlerFunc {
+	return EnvHandlerFunc(func(w http.ResponseWriter, req *http.Request, env *environment) {
+		if env.user == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		f(w, req, env)
+	})
+}
+
+// GReaderPostHandler rejects requests to endpoints that change state unless
+// they are POSTs. The mux only routes by path.
+func GReaderPostHandler(f EnvHandlerFunc) EnvHandlerFunc {
+	return EnvHandlerFunc(func(w http.ResponseWriter, req *http.Request, env *environment) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		f(w, req, env)
+	})
+}
+
+// GReaderClientLoginHandler logs in with Email, which may also be the user
+// name, and Passwd. The token it returns is a session ID.
+func GReaderClientLoginHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	login := req.FormValue("Email")
+	password := req.FormValue("Passwd")
+
+	user, err := data.SelectUserByName(context.Background(), env.pool, login)
+	if err == data.ErrNotFound {
+		user, err = data.SelectUserByEmail(context.Background(), env.pool, login)
+	}
+	if err != nil || !IsPassword(user, password) {
+		http.Error(w, "Error=BadAuthentication", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := genSessionID()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = data.InsertSession(context.Background(),
+		env.pool,
+		&data.Session{
+			ID:     pgtype.Bytea{Bytes: sessionID, Status: pgtype.Present},
+			UserID: user.ID,
+		},
+	)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token := hex.EncodeToString(sessionID)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+}
+
+// GReaderTokenHandler returns the token clients send with edits. The session
+// already authenticates edits so it is not checked.
+func GReaderTokenHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, "tpr")
+}
+
+func GReaderUserInfoHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	userID := strconv.FormatInt(int64(env.user.ID.Int), 10)
+	writeGReaderJSON(w, map[string]interface{}{
+		"userId":        userID,
+		"userName":      env.user.Name.String,
+		"userProfileId": userID,
+		"userEmail":     env.user.Email.String,
+	})
+}
+
+type greaderCategory struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type greaderSubscription struct {
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Categories []greaderCategory `json:"categories"`
+	URL        string            `json:"url"`
+	HTMLURL    string            `json:"htmlUrl"`
+	IconURL    string            `json:"iconUrl"`
+}
+
+func GReaderSubscriptionListHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	subscriptions, err := data.SelectSubscriptions(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := selectFolderNames(env)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]greaderSubscription, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		categories := make([]greaderCategory, 0, 1)
+		if s.FolderID.Status == pgtype.Present {
+			name := folders[s.FolderID.Int]
+			categories = append(categories, greaderCategory{ID: greaderLabelPrefix + name, Label: name})
+		}
+
+		result = append(result, greaderSubscription{
+			ID:         greaderFeedStreamID(s.FeedID.Int),
+			Title:      s.Name.String,
+			Categories: categories,
+			URL:        s.URL.String,
+			HTMLURL:    s.URL.String,
+		})
+	}
+
+	writeGReaderJSON(w, map[string]interface{}{"subscriptions": result})
+}
+
+// GReaderQuickAddHandler subscribes to the feed at quickadd.
+func GReaderQuickAddHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	feedURL := strings.TrimPrefix(req.FormValue("quickadd"), greaderFeedPrefix)
+	if feedURL == "" {
+		http.Error(w, `Request must include the parameter "quickadd"`, http.StatusBadRequest)
+		return
+	}
+
+	feedID, err := subscribeGReader(env, feedURL)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeGReaderJSON(w, map[string]interface{}{
+		"query":      feedURL,
+		"numResults": 1,
+		"streamId":   greaderFeedStreamID(feedID),
+	})
+}
+
+// subscribeGReader subscribes to feedURL and returns its feed ID. Clients
+// subscribe to feeds that are already subscribed so that is not an error.
+func subscribeGReader(env *environment, feedURL string) (int32, error) {
+	err := data.InsertSubscription(context.Background(), env.pool, env.user.ID.Int, feedURL)
+	if err != nil && err != data.ErrAlreadySubscribed {
+		return 0, err
+	}
+
+	return data.SelectFeedIDByURL(context.Background(), env.pool, feedURL)
+}
+
+// GReaderSubscriptionEditHandler subscribes (s is feed/ followed by the feed
+// URL), unsubscribes, or edits (s is the feed stream ID) a subscription
+// depending on ac. t renames the subscription, a moves it into a folder, and r
+// removes it from its folder.
+func GReaderSubscriptionEditHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	ctx := context.Background()
+	streamID := req.FormValue("s")
+
+	var feedID int32
+	var err error
+	if req.FormValue("ac") == "subscribe" {
+		feedID, err = subscribeGReader(env, strings.TrimPrefix(streamID, greaderFeedPrefix))
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var ok bool
+		feedID, ok = parseGReaderFeedStreamID(streamID)
+		if !ok {
+			http.Error(w, `Parameter "s" must be a feed`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch req.FormValue("ac") {
+	case "unsubscribe":
+		err = data.DeleteSubscription(ctx, env.pool, env.user.ID.Int, feedID)
+	case "subscribe", "edit":
+		if title := req.FormValue("t"); title != "" {
+			settings := &data.SubscriptionSettings{Name: pgtype.Varchar{String: title, Status: pgtype.Present}}
+			err = data.UpdateSubscription(ctx, env.pool, env.user.ID.Int, feedID, settings)
+			if err != nil {
+				break
+			}
+		}
+
+		if add := normalizeGReaderID(req.FormValue("a")); strings.HasPrefix(add, greaderLabelPrefix) {
+			var folderID int32
+			folderID, err = data.SelectOrCreateFolder(ctx, env.pool, env.user.ID.Int, strings.TrimPrefix(add, greaderLabelPrefix))
+			if err != nil {
+				break
+			}
+			err = data.SetSubscriptionFolder(ctx, env.pool, env.user.ID.Int, feedID, pgtype.Int4{Int: folderID, Status: pgtype.Present})
+		} else if strings.HasPrefix(normalizeGReaderID(req.FormValue("r")), greaderLabelPrefix) {
+			err = data.SetSubscriptionFolder(ctx, env.pool, env.user.ID.Int, feedID, pgtype.Int4{Status: pgtype.Null})
+		}
+	default:
+		http.Error(w, `Parameter "ac" must be subscribe, unsubscribe, or edit`, http.StatusBadRequest)
+		return
+	}
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeGReaderOK(w)
+}
+
+type greaderTag struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+// GReaderTagListHandler lists the starred state, folders, and item tags.
+// Folders and item tags are both labels.
+func GReaderTagListHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	folders, err := data.SelectFolders(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	tagNames, err := data.SelectTagNames(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	tags := []greaderTag{{ID: greaderStarred}}
+	for _, f := range folders {
+		tags = append(tags, greaderTag{ID: greaderLabelPrefix + f.Name.String, Type: "folder"})
+	}
+	for _, name := range tagNames {
+		tags = append(tags, greaderTag{ID: greaderLabelPrefix + name, Type: "tag"})
+	}
+
+	writeGReaderJSON(w, map[string]interface{}{"tags": tags})
+}
+
+type greaderUnreadCount struct {
+	ID                      string `json:"id"`
+	Count                   int64  `json:"count"`
+	NewestItemTimestampUsec string `json:"newestItemTimestampUsec"`
+}
+
+func GReaderUnreadCountHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	counts, err := data.SelectUnreadCounts(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	total := greaderUnreadCount{ID: greaderReadingList}
+	var newest time.Time
+	unreadCounts := make([]greaderUnreadCount, 0, len(counts)+1)
+	for _, c := range counts {
+		unreadCounts = append(unreadCounts, greaderUnreadCount{
+			ID:                      greaderFeedStreamID(c.FeedID),
+			Count:                   c.Count,
+			NewestItemTimestampUsec: greaderUsec(c.NewestTime),
+		})
+		total.Count += c.Count
+		if c.NewestTime.After(newest) {
+			newest = c.NewestTime
+		}
+	}
+	total.NewestItemTimestampUsec = greaderUsec(newest)
+	unreadCounts = append(unreadCounts, total)
+
+	writeGReaderJSON(w, map[string]interface{}{"max": total.Count, "unreadcounts": unreadCounts})
+}
+
+type greaderItemRef struct {
+	ID              string   `json:"id"`
+	DirectStreamIDs []string `json:"directStreamIds"`
+	TimestampUsec   string   `json:"timestampUsec"`
+}
+
+// GReaderStreamItemIDsHandler lists the IDs of the items in stream s.
+func GReaderStreamItemIDsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	query, page, ok, err := parseGReaderStreamQuery(req, env, req.FormValue("s"), maxGReaderItemIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	itemRefs := make([]greaderItemRef, 0)
+	var continuation string
+	if ok {
+		refs, err := data.SelectItemRefs(context.Background(), env.pool, env.user.ID.Int, query, page)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, r := range refs {
+			itemRefs = append(itemRefs, greaderItemRef{
+				ID:              strconv.FormatInt(int64(r.ID), 10),
+				DirectStreamIDs: []string{},
+				TimestampUsec:   greaderUsec(r.SortTime),
+			})
+		}
+		if len(refs) > 0 {
+			continuation = refs[len(refs)-1].Cursor().String()
+		}
+	}
+
+	response := map[string]interface{}{"itemRefs": itemRefs}
+	if len(itemRefs) > 0 && int32(len(itemRefs)) == page.Limit {
+		response["continuation"] = continuation
+	}
+
+	writeGReaderJSON(w, response)
+}
+
+// GReaderStreamContentsHandler lists the items in the stream named by the rest
+// of the path.
+func GReaderStreamContentsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	streamID := strings.TrimPrefix(req.URL.Path, "/reader/api/0/stream/contents/")
+	if streamID == "" {
+		streamID = req.FormValue("s")
+	}
+	if streamID == "" {
+		streamID = greaderReadingList
+	}
+
+	query, page, ok, err := parseGReaderStreamQuery(req, env, streamID, maxItemPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeGReaderItems(w, env, streamID, query, page, ok)
+}
+
+// GReaderStreamItemContentsHandler returns the items with the IDs in i.
+func GReaderStreamItemContentsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	req.ParseForm()
+	itemIDs, err := parseGReaderItemIDs(req.Form["i"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := data.ItemQuery{ItemIDs: itemIDs}
+	page := data.ItemPage{Descending: req.FormValue("r") != "o"}
+	writeGReaderItems(w, env, greaderReadingList, query, page, len(itemIDs) > 0)
+}
+
+// greaderListedItem is an item as listed by data.CopyItemsAsJSONByUserID.
+type greaderListedItem struct {
+	ID              int32    `json:"id"`
+	FeedID          int32    `json:"feed_id"`
+	FeedName        string   `json:"feed_name"`
+	Title           string   `json:"title"`
+	URL             string   `json:"url"`
+	Author          string   `json:"author"`
+	Summary         string   `json:"summary"`
+	Content         string   `json:"content"`
+	PublicationTime float64  `json:"publication_time"`
+	Cursor          string   `json:"cursor"`
+	Tags            []string `json:"tags"`
+	Starred         bool     `json:"starred"`
+	Unread          bool     `json:"unread"`
+}
+
+type greaderLink struct {
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+type greaderContent struct {
+	Direction string `json:"direction"`
+	Content   string `json:"content"`
+}
+
+type greaderOrigin struct {
+	StreamID string `json:"streamId"`
+	Title    string `json:"title"`
+	HTMLURL  string `json:"htmlUrl"`
+}
+
+type greaderItem struct {
+	ID            string         `json:"id"`
+	CrawlTimeMsec string         `json:"crawlTimeMsec"`
+	TimestampUsec string         `json:"timestampUsec"`
+	Published     int64          `json:"published"`
+	Updated       int64          `json:"updated"`
+	Title         string         `json:"title"`
+	Canonical     []greaderLink  `json:"canonical"`
+	Alternate     []greaderLink  `json:"alternate"`
+	Summary       greaderContent `json:"summary"`
+	Author        string         `json:"author"`
+	Categories    []string       `json:"categories"`
+	Origin        greaderOrigin  `json:"origin"`
+}
+
+func writeGReaderItems(w http.ResponseWriter, env *environment, streamID string, query data.ItemQuery, page data.ItemPage, ok bool) {
+	var listed []greaderListedItem
+	if ok {
+		buf := &bytes.Buffer{}
+		err := data.CopyItemsAsJSONByUserID(context.Background(), env.pool, buf, env.user.ID.Int, query, page)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = json.Unmarshal(buf.Bytes(), &listed)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	items := make([]greaderItem, 0, len(listed))
+	for _, li := range listed {
+		items = append(items, newGReaderItem(li))
+	}
+
+	response := map[string]interface{}{
+		"id":      streamID,
+		"updated": time.Now().Unix(),
+		"items":   items,
+	}
+	if len(items) > 0 && int32(len(items)) == page.Limit {
+		response["continuation"] = listed[len(listed)-1].Cursor
+	}
+
+	writeGReaderJSON(w, response)
+}
+
+func newGReaderItem(li greaderListedItem) greaderItem {
+	sec, frac := math.Modf(li.PublicationTime)
+	published := time.Unix(int64(sec), int64(frac*1e9))
+
+	categories := []string{greaderReadingList}
+	if !li.Unread {
+		categories = append(categories, greaderRead)
+	}
+	if li.Starred {
+		categories = append(categories, greaderStarred)
+	}
+	for _, tag := range li.Tags {
+		categories = append(categories, greaderLabelPrefix+tag)
+	}
+
+	content := li.Content
+	if content == "" {
+		content = li.Summary
+	}
+
+	return greaderItem{
+		ID:            greaderLongItemID(li.ID),
+		CrawlTimeMsec: strconv.FormatInt(published.UnixNano()/int64(time.Millisecond), 10),
+		TimestampUsec: greaderUsec(published),
+		Published:     published.Unix(),
+		Updated:       published.Unix(),
+		Title:         li.Title,
+		Canonical:     []greaderLink{{Href: li.URL}},
+		Alternate:     []greaderLink{{Href: li.URL, Type: "text/html"}},
+		Summary:       greaderContent{Direction: "ltr", Content: content},
+		Author:        li.Author,
+		Categories:    categories,
+		Origin: greaderOrigin{
+			StreamID: greaderFeedStreamID(li.FeedID),
+			Title:    li.FeedName,
+		},
+	}
+}
+
+// GReaderEditTagHandler adds (a) and removes (r) the read and starred states
+// and labels of the items in i. Labels of items are item tags.
+func GReaderEditTagHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	req.ParseForm()
+	itemIDs, err := parseGReaderItemIDs(req.Form["i"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, tag := range req.Form["a"] {
+		if err := editGReaderItemTag(env, itemIDs, normalizeGReaderID(tag), true); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, tag := range req.Form["r"] {
+		if err := editGReaderItemTag(env, itemIDs, normalizeGReaderID(tag), false); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeGReaderOK(w)
+}
+
+func editGReaderItemTag(env *environment, itemIDs []int32, tag string, add bool) error {
+	ctx := context.Background()
+	userID := env.user.ID.Int
+
+	if strings.HasPrefix(tag, greaderLabelPrefix) {
+		names := []string{strings.TrimPrefix(tag, greaderLabelPrefix)}
+		if add {
+			return data.TagItems(ctx, env.pool, userID, itemIDs, names)
+		}
+		return data.UntagItems(ctx, env.pool, userID, itemIDs, names)
+	}
+
+	var f func(context.Context, data.Queryer, int32, []int32) (int64, error)
+	switch {
+	case tag == greaderRead && add, tag == greaderKeptUnread && !add:
+		f = data.MarkMultipleItemsRead
+	case tag == greaderRead && !add, tag == greaderKeptUnread && add:
+		f = data.MarkMultipleItemsUnread
+	case tag == greaderStarred && add:
+		f = data.StarMultipleItems
+	case tag == greaderStarred && !add:
+		f = data.UnstarMultipleItems
+	default:
+		// Other states such as broadcast are not supported
+		return nil
+	}
+
+	_, err := f(ctx, env.pool, userID, itemIDs)
+	return err
+}
+
+// GReaderMarkAllAsReadHandler marks the items in stream s read. ts, in
+// microseconds, limits it to items published before then.
+func GReaderMarkAllAsReadHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	streamID := normalizeGReaderID(req.FormValue("s"))
+
+	var filter data.ItemFilter
+	switch {
+	case streamID == greaderReadingList:
+	case strings.HasPrefix(streamID, greaderFeedPrefix):
+		var ok bool
+		filter.FeedID, ok = parseGReaderFeedStreamID(streamID)
+		if !ok {
+			http.Error(w, `Parameter "s" is not a valid feed`, http.StatusBadRequest)
+			return
+		}
+	case strings.HasPrefix(streamID, greaderLabelPrefix):
+		folderID, err := selectGReaderFolderID(env, strings.TrimPrefix(streamID, greaderLabelPrefix))
+		if err == data.ErrNotFound {
+			writeGReaderOK(w)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		filter.FolderID = folderID
+	default:
+		http.Error(w, `Parameter "s" is not a supported stream`, http.StatusBadRequest)
+		return
+	}
+
+	var before time.Time
+	if usec, err := strconv.ParseInt(req.FormValue("ts"), 10, 64); err == nil && usec > 0 {
+		before = time.Unix(0, usec*int64(time.Microsecond))
+	}
+
+	_, err := data.MarkItemsRead(context.Background(), env.pool, env.user.ID.Int, filter, before)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeGReaderOK(w)
+}
+
+// parseGReaderStreamQuery converts a stream ID and the n, r, c, xt, it, ot,
+// and nt parameters to an item query. ok is false if the stream cannot have
+// any items, e.g. because it is a label that does not exist.
+func parseGReaderStreamQuery(req *http.Request, env *environment, streamID string, maxItems int32) (query data.ItemQuery, page data.ItemPage, ok bool, err error) {
+	streamID = normalizeGReaderID(streamID)
+	switch {
+	case streamID == greaderReadingList:
+	case streamID == greaderRead:
+		query.Read = true
+	case streamID == greaderStarred:
+		query.Starred = true
+	case strings.HasPrefix(streamID, greaderFeedPrefix):
+		query.FeedID, ok = parseGReaderFeedStreamID(streamID)
+		if !ok {
+			return query, page, false, nil
+		}
+	case strings.HasPrefix(streamID, greaderLabelPrefix):
+		// A label is a folder if there is one with its name and an item tag otherwise
+		label := strings.TrimPrefix(streamID, greaderLabelPrefix)
+		folderID, err := selectGReaderFolderID(env, label)
+		if err == data.ErrNotFound {
+			query.Tag = label
+		} else if err != nil {
+			return query, page, false, err
+		} else {
+			query.FolderID = folderID
+		}
+	default:
+		return query, page, false, fmt.Errorf("Unsupported stream: %s", streamID)
+	}
+
+	switch normalizeGReaderID(req.FormValue("xt")) {
+	case greaderRead:
+		query.Unread = true
+	case greaderStarred:
+		// Excluding starred items is not supported
+	}
+
+	switch normalizeGReaderID(req.FormValue("it")) {
+	case greaderRead:
+		query.Read = true
+	case greaderStarred:
+		query.Starred = true
+	}
+
+	if query.Read && query.Unread {
+		return query, page, false, nil
+	}
+
+	if s := req.FormValue("ot"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return query, page, false, fmt.Errorf(`Parameter "ot" must be a Unix time`)
+		}
+		query.Until = time.Unix(n, 0)
+	}
+
+	if s := req.FormValue("nt"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return query, page, false, fmt.Errorf(`Parameter "nt" must be a Unix time`)
+		}
+		query.Since = time.Unix(n, 0)
+	}
+
+	page.Limit = defaultGReaderItems
+	if s := req.FormValue("n"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 1 {
+			return query, page, false, fmt.Errorf(`Parameter "n" must be a positive integer`)
+		}
+		page.Limit = int32(n)
+	}
+	if page.Limit > maxItems {
+		page.Limit = maxItems
+	}
+
+	page.Descending = req.FormValue("r") != "o"
+
+	if s := req.FormValue("c"); s != "" {
+		page.After, err = data.ParseItemCursor(s)
+		if err != nil {
+			return query, page, false, fmt.Errorf(`Parameter "c" is not a valid continuation`)
+		}
+	}
+
+	return query, page, true, nil
+}
+
+// parseGReaderItemIDs parses item IDs in either the long form
+// (tag:google.com,2005:reader/item/ followed by 16 hex digits) or the short
+// form (a decimal number).
+func parseGReaderItemIDs(ids []string) ([]int32, error) {
+	itemIDs := make([]int32, 0, len(ids))
+	for _, s := range ids {
+		var n int64
+		var err error
+		if strings.HasPrefix(s, greaderItemPrefix) {
+			var u uint64
+			u, err = strconv.ParseUint(strings.TrimPrefix(s, greaderItemPrefix), 16, 64)
+			n = int64(u)
+		} else {
+			n, err = strconv.ParseInt(s, 10, 64)
+		}
+		if err != nil || n <= 0 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Invalid item ID: %s", s)
+		}
+		itemIDs = append(itemIDs, int32(n))
+	}
+
+	return itemIDs, nil
+}
+
+// normalizeGReaderID replaces the user in user specific IDs with "-".
+func normalizeGReaderID(id string) string {
+	return greaderUserPrefix.ReplaceAllString(id, "user/-/")
+}
+
+func greaderFeedStreamID(feedID int32) string {
+	return greaderFeedPrefix + strconv.FormatInt(int64(feedID), 10)
+}
+
+func parseGReaderFeedStreamID(streamID string) (int32, bool) {
+	n, err := strconv.ParseInt(strings.TrimPrefix(streamID, greaderFeedPrefix), 10, 32)
+	if err != nil || !strings.HasPrefix(streamID, greaderFeedPrefix) {
+		return 0, false
+	}
+	return int32(n), true
+}
+
+func greaderLongItemID(itemID int32) string {
+	return fmt.Sprintf("%s%016x", greaderItemPrefix, itemID)
+}
+
+func greaderUsec(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 10)
+}
+
+// selectFolderNames returns the names of the folders of the user by ID.
+func selectFolderNames(env *environment) (map[int32]string, error) {
+	folders, err := data.SelectFolders(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int32]string, len(folders))
+	for _, f := range folders {
+		names[f.ID.Int] = f.Name.String
+	}
+	return names, nil
+}
+
+// selectGReaderFolderID returns the ID of the folder of the user called name.
+func selectGReaderFolderID(env *environment, name string) (int32, error) {
+	folders, err := data.SelectFolders(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range folders {
+		if strings.EqualFold(f.Name.String, name) {
+			return f.ID.Int, nil
+		}
+	}
+	return 0, data.ErrNotFound
+}
+
+func writeGReaderJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeGReaderOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, "OK")
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+)
+
+func TestParseGReaderItemIDs(t *testing.T) {
+	tests := []struct {
+		ids      []string
+		expected []int32
+	}{
+		{[]string{}, []int32{}},
+		{[]string{"42"}, []int32{42}},
+		{[]string{"tag:google.com,2005:reader/item/000000000000002a", "7"}, []int32{42, 7}},
+	}
+
+	for i, tt := range tests {
+		itemIDs, err := parseGReaderItemIDs(tt.ids)
+		if err != nil {
+			t.Errorf("%d. %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(itemIDs, tt.expected) {
+			t.Errorf("%d. Expected %v, got %v", i, tt.expected, itemIDs)
+		}
+	}
+
+	for _, s := range []string{"", "foo", "-1", "0", "tag:google.com,2005:reader/item/ffffffffffffffff"} {
+		if _, err := parseGReaderItemIDs([]string{s}); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+
+	if s := greaderLongItemID(42); s != "tag:google.com,2005:reader/item/000000000000002a" {
+		t.Errorf("Unexpected long item ID: %s", s)
+	}
+}
+
+func TestNormalizeGReaderID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{"user/-/state/com.google/read", "user/-/state/com.google/read"},
+		{"user/12345/state/com.google/starred", "user/-/state/com.google/starred"},
+		{"user/12345/label/News", "user/-/label/News"},
+		{"feed/1", "feed/1"},
+	}
+
+	for i, tt := range tests {
+		if id := normalizeGReaderID(tt.id); id != tt.expected {
+			t.Errorf("%d. Expected %s, got %s", i, tt.expected, id)
+		}
+	}
+}
+
+func TestGReaderHandler(t *testing.T) {
+	pool := newConnPool(t)
+
+	user := newUser()
+	SetPassword(user, "password")
+	userID, err := data.CreateUser(context.Background(), pool, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderID, err := data.CreateFolder(context.Background(), pool, userID, "News")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.SetSubscriptionFolder(context.Background(), pool, userID, feedID, pgtype.Int4{Int: folderID, Status: pgtype.Present})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One", PublicationTime: pgtype.Timestamptz{Time: now.Add(-time.Hour), Status: pgtype.Present}},
+		{URL: "http://foo/2", Title: "Two", PublicationTime: pgtype.Timestamptz{Time: now, Status: pgtype.Present}},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewGReaderHandler(pool, getLogger(t))
+
+	greader := func(path string, form url.Values, auth string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://example.com"+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth != "" {
+			req.Header.Set("Authorization", "GoogleLogin auth="+auth)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := greader("/accounts/ClientLogin", url.Values{"Email": {"test"}, "Passwd": {"wrong"}}, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected HTTP status 401, got %d", w.Code)
+	}
+
+	w = greader("/reader/api/0/subscription/list", url.Values{}, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected HTTP status 401, got %d", w.Code)
+	}
+
+	w = greader("/accounts/ClientLogin", url.Values{"Email": {"test"}, "Passwd": {"password"}}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var auth string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "Auth=") {
+			auth = strings.TrimPrefix(line, "Auth=")
+		}
+	}
+	if auth == "" {
+		t.Fatalf("Expected Auth in response, got %s", w.Body.String())
+	}
+
+	w = greader("/reader/api/0/subscription/list?output=json", url.Values{}, auth)
+	var subscriptions struct {
+		Subscriptions []greaderSubscription `json:"subscriptions"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &subscriptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSubscriptions := []greaderSubscription{{
+		ID:         greaderFeedStreamID(feedID),
+		Title:      "Foo",
+		Categories: []greaderCategory{{ID: "user/-/label/News", Label: "News"}},
+		URL:        "http://foo",
+		HTMLURL:    "http://foo",
+	}}
+	if !reflect.DeepEqual(subscriptions.Subscriptions, expectedSubscriptions) {
+		t.Errorf("Expected %v, got %v", expectedSubscriptions, subscriptions.Subscriptions)
+	}
+
+	var stream struct {
+		Items        []greaderItem `json:"items"`
+		Continuation string        `json:"continuation"`
+	}
+	w = greader("/reader/api/0/stream/contents/user/-/label/News?n=1", url.Values{}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Items) != 1 || stream.Items[0].Title != "Two" || stream.Continuation == "" {
+		t.Fatalf("Unexpected stream: %s", w.Body.String())
+	}
+	twoID := stream.Items[0].ID
+
+	w = greader("/reader/api/0/stream/contents/user/-/label/News?n=1&c="+stream.Continuation, url.Values{}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Items) != 1 || stream.Items[0].Title != "One" {
+		t.Fatalf("Unexpected stream: %s", w.Body.String())
+	}
+
+	// State is only changed by POST
+	req, err := http.NewRequest("GET", "http://example.com/reader/api/0/edit-tag?i="+url.QueryEscape(twoID)+"&a=user/-/state/com.google/read", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "GoogleLogin auth="+auth)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected HTTP status 405, got %d", w.Code)
+	}
+
+	w = greader("/reader/api/0/edit-tag", url.Values{
+		"i": {twoID},
+		"a": {"user/-/state/com.google/read", "user/-/state/com.google/starred", "user/-/label/Later"},
+	}, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var itemIDs struct {
+		ItemRefs []greaderItemRef `json:"itemRefs"`
+	}
+	w = greader("/reader/api/0/stream/items/ids?s=user/-/state/com.google/reading-list&xt=user/-/state/com.google/read", url.Values{}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &itemIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(itemIDs.ItemRefs) != 1 {
+		t.Fatalf("Expected 1 unread item, got %s", w.Body.String())
+	}
+
+	w = greader("/reader/api/0/stream/items/contents", url.Values{"i": {itemIDs.ItemRefs[0].ID, twoID}}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Items) != 2 {
+		t.Fatalf("Expected 2 items, got %s", w.Body.String())
+	}
+	expectedCategories := []string{
+		"user/-/state/com.google/reading-list",
+		"user/-/state/com.google/read",
+		"user/-/state/com.google/starred",
+		"user/-/label/Later",
+	}
+	if stream.Items[0].ID != twoID || !reflect.DeepEqual(stream.Items[0].Categories, expectedCategories) {
+		t.Errorf("Expected %s to have categories %v, got %v", twoID, expectedCategories, stream.Items[0])
+	}
+
+	w = greader("/reader/api/0/stream/contents/user/-/label/Later", url.Values{}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Items) != 1 || stream.Items[0].ID != twoID {
+		t.Fatalf("Unexpected stream: %s", w.Body.String())
+	}
+
+	w = greader("/reader/api/0/mark-all-as-read", url.Values{"s": {greaderFeedStreamID(feedID)}}, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var unreadCounts struct {
+		Max int64 `json:"max"`
+	}
+	w = greader("/reader/api/0/unread-count", url.Values{}, auth)
+	err = json.Unmarshal(w.Body.Bytes(), &unreadCounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unreadCounts.Max != 0 {
+		t.Errorf("Expected no unread items, got %s", w.Body.String())
+	}
+
+	// Subscribing again is not an error
+	w = greader("/reader/api/0/subscription/quickadd", url.Values{"quickadd": {"http://foo"}}, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), greaderFeedStreamID(feedID)) {
+		t.Errorf("Expected stream ID %s, got %s", greaderFeedStreamID(feedID), w.Body.String())
+	}
+
+	w = greader("/reader/api/0/subscription/edit", url.Values{"ac": {"unsubscribe"}, "s": {greaderFeedStreamID(feedID)}}, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	subscriptionsAfter, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptionsAfter) != 0 {
+		t.Errorf("Expected no subscriptions, got %v", subscriptionsAfter)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"html/template"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgtype"
@@ -20,10 +25,10 @@ import (
 
 type EnvHandlerFunc func(w http.ResponseWriter, req *http.Request, env *environment)
 
-func EnvHandler(pool *pgxpool.Pool, mailer Mailer, logger log.Logger, f EnvHandlerFunc) http.Handler {
+func EnvHandler(pool *pgxpool.Pool, mailer Mailer, feedUpdater *FeedUpdater, logger log.Logger, f EnvHandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		user := getUserFromSession(req, pool)
-		env := &environment{user: user, pool: pool, mailer: mailer, logger: logger}
+		env := &environment{user: user, pool: pool, mailer: mailer, feedUpdater: feedUpdater, logger: logger}
 		f(w, req, env)
 	})
 }
@@ -40,31 +45,64 @@ func AuthenticatedHandler(f EnvHandlerFunc) EnvHandlerFunc {
 }
 
 type environment struct {
-	user   *data.User
-	pool   *pgxpool.Pool
-	logger log.Logger
-	mailer Mailer
+	user        *data.User
+	pool        *pgxpool.Pool
+	logger      log.Logger
+	mailer      Mailer
+	feedUpdater *FeedUpdater
 }
 
-func NewAPIHandler(pool *pgxpool.Pool, mailer Mailer, logger log.Logger) http.Handler {
+func NewAPIHandler(pool *pgxpool.Pool, mailer Mailer, feedUpdater *FeedUpdater, logger log.Logger) http.Handler {
 	router := qv.NewRouter()
 
-	router.Post("/register", EnvHandler(pool, mailer, logger, RegisterHandler))
-	router.Post("/sessions", EnvHandler(pool, mailer, logger, CreateSessionHandler))
-	router.Delete("/sessions/:id", EnvHandler(pool, mailer, logger, AuthenticatedHandler(DeleteSessionHandler)))
-	router.Post("/subscriptions", EnvHandler(pool, mailer, logger, AuthenticatedHandler(CreateSubscriptionHandler)))
-	router.Delete("/subscriptions/:id", EnvHandler(pool, mailer, logger, AuthenticatedHandler(DeleteSubscriptionHandler)))
-	router.Post("/request_password_reset", EnvHandler(pool, mailer, logger, RequestPasswordResetHandler))
-	router.Post("/reset_password", EnvHandler(pool, mailer, logger, ResetPasswordHandler))
-	router.Get("/feeds", EnvHandler(pool, mailer, logger, AuthenticatedHandler(GetFeedsHandler)))
-	router.Post("/feeds/import", EnvHandler(pool, mailer, logger, AuthenticatedHandler(ImportFeedsHandler)))
-	router.Get("/feeds.xml", EnvHandler(pool, mailer, logger, AuthenticatedHandler(ExportFeedsHandler)))
-	router.Get("/items/unread", EnvHandler(pool, mailer, logger, AuthenticatedHandler(GetUnreadItemsHandler)))
-	router.Post("/items/unread/mark_multiple_read", EnvHandler(pool, mailer, logger, AuthenticatedHandler(MarkMultipleItemsReadHandler)))
-	router.Delete("/items/unread/:id", EnvHandler(pool, mailer, logger, AuthenticatedHandler(MarkItemReadHandler)))
-	router.Get("/items/archived", EnvHandler(pool, mailer, logger, AuthenticatedHandler(GetArchivedItemsHandler)))
-	router.Get("/account", EnvHandler(pool, mailer, logger, AuthenticatedHandler(GetAccountHandler)))
-	router.Patch("/account", EnvHandler(pool, mailer, logger, AuthenticatedHandler(UpdateAccountHandler)))
+	router.Post("/register", EnvHandler(pool, mailer, feedUpdater, logger, RegisterHandler))
+	router.Post("/sessions", EnvHandler(pool, mailer, feedUpdater, logger, CreateSessionHandler))
+	router.Delete("/sessions/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteSessionHandler)))
+	router.Post("/subscriptions", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(CreateSubscriptionHandler)))
+	router.Patch("/subscriptions/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UpdateSubscriptionHandler)))
+	router.Delete("/subscriptions/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteSubscriptionHandler)))
+	router.Get("/folders", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetFoldersHandler)))
+	router.Post("/folders", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(CreateFolderHandler)))
+	router.Patch("/folders/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UpdateFolderHandler)))
+	router.Delete("/folders/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteFolderHandler)))
+	router.Get("/filter_rules", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetFilterRulesHandler)))
+	router.Post("/filter_rules", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(CreateFilterRuleHandler)))
+	router.Delete("/filter_rules/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteFilterRuleHandler)))
+	router.Get("/webhooks", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetWebhooksHandler)))
+	router.Post("/webhooks", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(CreateWebhookHandler)))
+	router.Delete("/webhooks/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteWebhookHandler)))
+	router.Get("/webhooks/:id/deliveries", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetWebhookDeliveriesHandler)))
+	router.Post("/request_password_reset", EnvHandler(pool, mailer, feedUpdater, logger, RequestPasswordResetHandler))
+	router.Post("/reset_password", EnvHandler(pool, mailer, feedUpdater, logger, ResetPasswordHandler))
+	router.Get("/feeds", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetFeedsHandler)))
+	router.Post("/feeds/import", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(ImportFeedsHandler)))
+	router.Get("/feeds.xml", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(ExportFeedsHandler)))
+	router.Post("/feeds/:id/retry", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(RetryFeedHandler)))
+	router.Get("/tags", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetTagsHandler)))
+	router.Delete("/tags/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteTagHandler)))
+	router.Get("/items", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetItemsHandler)))
+	router.Post("/items/tag_multiple", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(TagMultipleItemsHandler)))
+	router.Post("/items/untag_multiple", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UntagMultipleItemsHandler)))
+	router.Get("/items/unread", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetUnreadItemsHandler)))
+	router.Post("/items/unread/mark_multiple_read", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(MarkMultipleItemsReadHandler)))
+	router.Post("/items/unread/mark_all_read", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(MarkAllItemsReadHandler)))
+	router.Post("/items/unread/mark_multiple_unread", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(MarkMultipleItemsUnreadHandler)))
+	router.Put("/items/unread/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(MarkItemUnreadHandler)))
+	router.Delete("/items/unread/:id", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(MarkItemReadHandler)))
+	router.Get("/items/archived", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetArchivedItemsHandler)))
+	router.Get("/items/search", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(SearchItemsHandler)))
+	router.Get("/items/starred", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetStarredItemsHandler)))
+	router.Put("/items/:id/star", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(StarItemHandler)))
+	router.Delete("/items/:id/star", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UnstarItemHandler)))
+	router.Get("/account", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetAccountHandler)))
+	router.Patch("/account", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UpdateAccountHandler)))
+	router.Put("/account/fever", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(EnableFeverHandler)))
+	router.Delete("/account/fever", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DisableFeverHandler)))
+	router.Get("/account/digest", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(GetDigestSettingsHandler)))
+	router.Put("/account/digest", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(UpdateDigestSettingsHandler)))
+	router.Delete("/account/digest", EnvHandler(pool, mailer, feedUpdater, logger, AuthenticatedHandler(DeleteDigestSettingsHandler)))
+	router.Get("/digest/unsubscribe", EnvHandler(pool, mailer, feedUpdater, logger, ConfirmUnsubscribeDigestHandler))
+	router.Post("/digest/unsubscribe", EnvHandler(pool, mailer, feedUpdater, logger, UnsubscribeDigestHandler))
 
 	return router
 }
@@ -75,6 +113,12 @@ func getUserFromSession(req *http.Request, pool *pgxpool.Pool) *data.User {
 		token = req.FormValue("session")
 	}
 
+	return getUserBySessionToken(token, pool)
+}
+
+// getUserBySessionToken returns the user of the hex encoded session ID token
+// or nil if there is no such session.
+func getUserBySessionToken(token string, pool *pgxpool.Pool) *data.User {
 	var sessionID []byte
 	sessionID, err := hex.DecodeString(token)
 	if err != nil {
@@ -183,7 +227,8 @@ func RegisterHandler(w http.ResponseWriter, req *http.Request, env *environment)
 
 func CreateSubscriptionHandler(w http.ResponseWriter, req *http.Request, env *environment) {
 	var subscription struct {
-		URL string `json:"url"`
+		URL      string `json:"url"`
+		Validate bool   `json:"validate"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -199,15 +244,168 @@ func CreateSubscriptionHandler(w http.ResponseWriter, req *http.Request, env *en
 		return
 	}
 
-	if err := data.InsertSubscription(context.Background(), env.pool, env.user.ID.Int, subscription.URL); err != nil {
+	candidates, discoveredRawFeed, discoveredFeed, err := env.feedUpdater.discoverFeeds(subscription.URL)
+	if err != nil && subscription.Validate {
+		writeFeedValidationError(w, &feedValidationError{Code: "fetch_failed", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		// The site may only be temporarily unavailable. Subscribe to the URL as
+		// given and let the feed updater keep trying.
+		env.logger.Info("feed discovery failed", "url", subscription.URL, "error", err)
+		candidates = []feedCandidate{{URL: subscription.URL}}
+	}
+
+	switch len(candidates) {
+	case 0:
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `No feed found at "url"`)
+		return
+	case 1:
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultipleChoices)
+		json.NewEncoder(w).Encode(struct {
+			Feeds []feedCandidate `json:"feeds"`
+		}{candidates})
+		return
+	}
+
+	feedURL := candidates[0].URL
+
+	var rawFeed *rawFeed
+	var feed *data.ParsedFeed
+	if subscription.Validate && discoveredFeed != nil {
+		// Discovery already fetched and parsed the URL as a feed
+		rawFeed, feed = discoveredRawFeed, discoveredFeed
+	} else if subscription.Validate {
+		rawFeed, feed, err = env.feedUpdater.validateFeed(feedURL)
+		if err, ok := err.(*feedValidationError); ok {
+			writeFeedValidationError(w, err)
+			return
+		}
+	}
+
+	err = data.InsertSubscription(context.Background(), env.pool, env.user.ID.Int, feedURL)
+	if err == data.ErrAlreadySubscribed {
 		w.WriteHeader(422)
-		fmt.Fprintln(w, `Bad user name or password`)
+		fmt.Fprintln(w, `Already subscribed to feed`)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if feed != nil {
+		feedID, err := data.SelectFeedIDByURL(context.Background(), env.pool, feedURL)
+		if err == nil {
+			err = env.feedUpdater.saveFetchedFeed(feedID, rawFeed, feed)
+		}
+		if err != nil {
+			// The subscription exists so the feed updater will fill the feed in later
+			env.logger.Error("saving validated feed failed", "url", feedURL, "error", err)
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
+// decodePatchString decodes an optional string attribute of a PATCH request.
+// An absent attribute is Undefined and null or "" is Null.
+func decodePatchString(raw json.RawMessage) (pgtype.Varchar, error) {
+	if len(raw) == 0 {
+		return pgtype.Varchar{Status: pgtype.Undefined}, nil
+	}
+	if string(raw) == "null" {
+		return pgtype.Varchar{Status: pgtype.Null}, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return pgtype.Varchar{}, err
+	}
+
+	return newStringFallback(s, pgtype.Null), nil
+}
+
+func writeFeedValidationError(w http.ResponseWriter, err *feedValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(422)
+	json.NewEncoder(w).Encode(struct {
+		Error *feedValidationError `json:"error"`
+	}{err})
+}
+
+func UpdateSubscriptionHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	feedID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	// Nullable attributes are left raw to tell an absent attribute (leave it
+	// alone) from a null one (clear it).
+	var update struct {
+		Name     json.RawMessage `json:"name"`
+		Notes    json.RawMessage `json:"notes"`
+		Muted    *bool           `json:"muted"`
+		FolderID json.RawMessage `json:"folderID"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&update); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	settings := &data.SubscriptionSettings{}
+	name, err := decodePatchString(update.Name)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+	settings.Name = name
+
+	notes, err := decodePatchString(update.Notes)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+	settings.Notes = pgtype.Text{String: notes.String, Status: notes.Status}
+
+	if update.Muted != nil {
+		settings.Muted = pgtype.Bool{Bool: *update.Muted, Status: pgtype.Present}
+	}
+
+	if len(update.FolderID) > 0 {
+		settings.FolderID = pgtype.Int4{Status: pgtype.Null}
+		if string(update.FolderID) != "null" {
+			if err := json.Unmarshal(update.FolderID, &settings.FolderID); err != nil {
+				w.WriteHeader(422)
+				fmt.Fprintf(w, "Error decoding request: %v", err)
+				return
+			}
+		}
+	}
+
+	// Unknown subscriptions and folders are both not found. Nothing is changed
+	// in either case.
+	err = data.UpdateSubscription(context.Background(), env.pool, env.user.ID.Int, int32(feedID), settings)
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
 func DeleteSubscriptionHandler(w http.ResponseWriter, req *http.Request, env *environment) {
 	feedID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
 	if err != nil {
@@ -314,12 +512,130 @@ func DeleteSessionHandler(w http.ResponseWriter, req *http.Request, env *environ
 }
 
 func GetUnreadItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	filter, err := parseItemFilter(req)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	page, err := parseItemPage(req, data.ItemPage{})
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := data.CopyUnreadItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+	if err := data.CopyUnreadItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int, filter, page); err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
+// parseItemFilter reads the optional folder_id and feed_id query parameters.
+func parseItemFilter(req *http.Request) (data.ItemFilter, error) {
+	var filter data.ItemFilter
+	var err error
+
+	filter.FolderID, err = parseOptionalInt32(req, "folder_id")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.FeedID, err = parseOptionalInt32(req, "feed_id")
+	return filter, err
+}
+
+// parseOptionalUnixTime parses the request parameter name as seconds since the
+// Unix epoch. A missing parameter is the zero time.
+func parseOptionalUnixTime(req *http.Request, name string) (time.Time, error) {
+	s := req.FormValue(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q must be a Unix time", name)
+	}
+
+	return time.Unix(n, 0), nil
+}
+
+// parseOptionalItemCursor reads the cursor of an item from a listing in the
+// query parameter name.
+func parseOptionalItemCursor(req *http.Request, name string) (data.ItemCursor, error) {
+	value := req.FormValue(name)
+	if value == "" {
+		return data.ItemCursor{}, nil
+	}
+
+	cursor, err := data.ParseItemCursor(value)
+	if err != nil {
+		return cursor, fmt.Errorf(`"%s" must be the cursor of an item`, name)
+	}
+	return cursor, nil
+}
+
+// maxItemPageSize is the largest limit an item listing may be asked for.
+const maxItemPageSize = 1000
+
+// parseItemPage reads the optional before, after, limit, and sort ("asc" or
+// "desc") query parameters. before and after are the cursor attributes of
+// listed items. Missing parameters keep their value in defaults.
+func parseItemPage(req *http.Request, defaults data.ItemPage) (data.ItemPage, error) {
+	page := defaults
+	var err error
+
+	page.Before, err = parseOptionalItemCursor(req, "before")
+	if err != nil {
+		return page, err
+	}
+
+	page.After, err = parseOptionalItemCursor(req, "after")
+	if err != nil {
+		return page, err
+	}
+
+	limit, err := parseOptionalInt32(req, "limit")
+	if err != nil {
+		return page, err
+	}
+	if limit < 0 || limit > maxItemPageSize {
+		return page, fmt.Errorf(`"limit" must be between 1 and %d`, maxItemPageSize)
+	}
+	if limit > 0 {
+		page.Limit = limit
+	}
+
+	switch req.FormValue("sort") {
+	case "":
+	case "asc":
+		page.Descending = false
+	case "desc":
+		page.Descending = true
+	default:
+		return page, errors.New(`"sort" must be "asc" or "desc"`)
+	}
+
+	return page, nil
+}
+
+// parseOptionalInt32 parses the request parameter name. A missing parameter is 0.
+func parseOptionalInt32(req *http.Request, name string) (int32, error) {
+	s := req.FormValue(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q must be an integer", name)
+	}
+
+	return int32(n), nil
+}
+
 func MarkItemReadHandler(w http.ResponseWriter, req *http.Request, env *environment) {
 	itemID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
 	if err != nil {
@@ -350,138 +666,855 @@ func MarkMultipleItemsReadHandler(w http.ResponseWriter, req *http.Request, env
 		return
 	}
 
-	for _, itemID := range request.ItemIDs {
-		err := data.MarkItemRead(context.Background(), env.pool, env.user.ID.Int, itemID)
-		if err != nil && err != data.ErrNotFound {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+	_, err := data.MarkMultipleItemsRead(context.Background(), env.pool, env.user.ID.Int, request.ItemIDs)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 }
 
-func GetArchivedItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := data.CopyArchivedItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+// MarkAllItemsReadHandler marks every unread item matching the request read.
+// feedID and folderID restrict it to one feed or folder and before, in Unix
+// seconds, to items published before then. Without any of them all must be
+// true so a malformed request cannot mark everything read.
+func MarkAllItemsReadHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var request struct {
+		FeedID   int32 `json:"feedID"`
+		FolderID int32 `json:"folderID"`
+		Before   int64 `json:"before"`
+		All      bool  `json:"all"`
 	}
-}
 
-func ImportFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	file, _, err := req.FormFile("file")
-	if err != nil {
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&request); err != nil {
 		w.WriteHeader(422)
-		fmt.Fprintln(w, `No uploaded file found`)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
 		return
 	}
-	defer file.Close()
 
-	var doc OpmlDocument
-	err = xml.NewDecoder(file).Decode(&doc)
-	if err != nil {
+	if request.FeedID == 0 && request.FolderID == 0 && request.Before == 0 && !request.All {
 		w.WriteHeader(422)
-		fmt.Fprintln(w, "Error parsing OPML upload")
+		fmt.Fprintln(w, `Request must include "feedID", "folderID", or "before", or "all" to mark every item read`)
 		return
 	}
 
-	type subscriptionResult struct {
-		Title   string `json:"title"`
-		URL     string `json:"url"`
-		Success bool   `json:"success"`
-	}
-
-	results := make([]subscriptionResult, 0, len(doc.Body.Outlines))
-	resultsChan := make(chan subscriptionResult)
-
-	for _, outline := range doc.Body.Outlines {
-		go func(outline OpmlOutline) {
-			r := subscriptionResult{Title: outline.Title, URL: outline.URL}
-			err := data.InsertSubscription(context.Background(), env.pool, env.user.ID.Int, outline.URL)
-			r.Success = err == nil
-			resultsChan <- r
-		}(outline)
+	var before time.Time
+	if request.Before != 0 {
+		before = time.Unix(request.Before, 0)
 	}
 
-	for _ = range doc.Body.Outlines {
-		r := <-resultsChan
-		results = append(results, r)
+	filter := data.ItemFilter{FeedID: request.FeedID, FolderID: request.FolderID}
+	count, err := data.MarkItemsRead(context.Background(), env.pool, env.user.ID.Int, filter, before)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(struct {
+		Count int64 `json:"count"`
+	}{count})
 }
 
-func ExportFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	subs, err := data.SelectSubscriptions(context.Background(), env.pool, env.user.ID.Int)
+func MarkItemUnreadHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	itemID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
 		return
 	}
 
-	doc := OpmlDocument{Version: "1.0"}
-	doc.Head.Title = "The Pithy Reader Export for " + env.user.Name.String
+	err = data.MarkItemUnread(context.Background(), env.pool, env.user.ID.Int, int32(itemID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
 
-	for _, s := range subs {
-		doc.Body.Outlines = append(doc.Body.Outlines, OpmlOutline{
-			Text:  s.Name.String,
-			Title: s.Name.String,
-			Type:  "rss",
-			URL:   s.URL.String,
-		})
+func MarkMultipleItemsUnreadHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var request struct {
+		ItemIDs []int32 `json:"itemIDs"`
 	}
 
-	w.Header().Set("Content-Type", "application/xml")
-	w.Header().Set("Content-Disposition", `attachment; filename="opml.xml"`)
-	fmt.Fprint(w, xml.Header)
-	xml.NewEncoder(w).Encode(doc)
-}
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&request); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
 
-func GetFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := data.CopySubscriptionsForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+	_, err := data.MarkMultipleItemsUnread(context.Background(), env.pool, env.user.ID.Int, request.ItemIDs)
+	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 }
 
-func GetAccountHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	var user struct {
-		ID    int32  `json:"id"`
-		Name  string `json:"name"`
-		Email string `json:"email"`
+func GetArchivedItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	filter, err := parseItemFilter(req)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
 	}
 
-	user.ID = env.user.ID.Int
-	user.Name = env.user.Name.String
-	user.Email = env.user.Email.String
+	page, err := parseItemPage(req, data.ItemPage{Limit: 250, Descending: true})
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
-}
-
-func UpdateAccountHandler(w http.ResponseWriter, req *http.Request, env *environment) {
-	var update struct {
-		Email            string `json:"email"`
-		ExistingPassword string `json:"existingPassword"`
-		NewPassword      string `json:"newPassword"`
+	if err := data.CopyArchivedItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int, filter, page); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
+}
 
-	decoder := json.NewDecoder(req.Body)
-	if err := decoder.Decode(&update); err != nil {
+// GetItemsHandler lists the items tagged with the tag parameter.
+func GetItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	tag := req.FormValue("tag")
+	if tag == "" {
 		w.WriteHeader(422)
-		fmt.Fprintf(w, "Error decoding request: %v", err)
+		fmt.Fprintln(w, `Request must include the parameter "tag"`)
 		return
 	}
 
-	if !IsPassword(env.user, update.ExistingPassword) {
+	page, err := parseItemPage(req, data.ItemPage{Limit: 250, Descending: true})
+	if err != nil {
 		w.WriteHeader(422)
-		fmt.Fprintln(w, "Bad existing password")
+		fmt.Fprintln(w, err)
 		return
 	}
 
-	user := &data.User{}
-	user.Email = newStringFallback(update.Email, pgtype.Null)
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyTaggedItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int, tag, page); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
 
-	if update.NewPassword != "" {
-		err := SetPassword(user, update.NewPassword)
-		if err != nil {
+type itemTagsRequest struct {
+	ItemIDs []int32  `json:"itemIDs"`
+	Tags    []string `json:"tags"`
+}
+
+func decodeItemTagsRequest(w http.ResponseWriter, req *http.Request) (*itemTagsRequest, bool) {
+	var request itemTagsRequest
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&request); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return nil, false
+	}
+
+	for _, tag := range request.Tags {
+		if tag == "" {
+			w.WriteHeader(422)
+			fmt.Fprintln(w, "Tags must not be empty")
+			return nil, false
+		}
+	}
+
+	return &request, true
+}
+
+func TagMultipleItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	request, ok := decodeItemTagsRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := data.TagItems(context.Background(), env.pool, env.user.ID.Int, request.ItemIDs, request.Tags)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func UntagMultipleItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	request, ok := decodeItemTagsRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := data.UntagItems(context.Background(), env.pool, env.user.ID.Int, request.ItemIDs, request.Tags)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// SearchItemsHandler searches items for the q parameter. feed_id limits the
+// search to one feed and since and until, in Unix seconds, to a range of
+// publication times.
+func SearchItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	search := data.ItemSearch{Query: req.FormValue("q"), Limit: 50}
+	if search.Query == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must include the parameter "q"`)
+		return
+	}
+
+	var err error
+	search.FeedID, err = parseOptionalInt32(req, "feed_id")
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	search.Since, err = parseOptionalUnixTime(req, "since")
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	search.Until, err = parseOptionalUnixTime(req, "until")
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	limit, err := parseOptionalInt32(req, "limit")
+	if err != nil || limit < 0 || limit > maxItemPageSize {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "\"limit\" must be between 1 and %d\n", maxItemPageSize)
+		return
+	}
+	if limit > 0 {
+		search.Limit = limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopySearchResultsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int, search); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func GetStarredItemsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	page, err := parseItemPage(req, data.ItemPage{Limit: 250, Descending: true})
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyStarredItemsAsJSONByUserID(context.Background(), env.pool, w, env.user.ID.Int, page); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func StarItemHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	itemID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.StarItem(context.Background(), env.pool, env.user.ID.Int, int32(itemID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func UnstarItemHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	itemID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.UnstarItem(context.Background(), env.pool, env.user.ID.Int, int32(itemID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func ImportFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `No uploaded file found`)
+		return
+	}
+	defer file.Close()
+
+	var doc OpmlDocument
+	err = xml.NewDecoder(file).Decode(&doc)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Error parsing OPML upload")
+		return
+	}
+
+	type subscriptionResult struct {
+		Title   string `json:"title"`
+		URL     string `json:"url"`
+		Folder  string `json:"folder,omitempty"`
+		Success bool   `json:"success"`
+	}
+
+	feeds := doc.Body.Feeds()
+
+	results := make([]subscriptionResult, 0, len(feeds))
+	resultsChan := make(chan subscriptionResult)
+
+	for _, feed := range feeds {
+		go func(feed OpmlFeed) {
+			r := subscriptionResult{Title: feed.Title, URL: feed.URL, Folder: feed.Folder}
+			r.Success = importSubscription(env, feed) == nil
+			resultsChan <- r
+		}(feed)
+	}
+
+	for _ = range feeds {
+		r := <-resultsChan
+		results = append(results, r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// importSubscription subscribes to an imported feed and files it in its
+// folder, creating the folder if needed.
+func importSubscription(env *environment, feed OpmlFeed) error {
+	err := data.InsertSubscription(context.Background(), env.pool, env.user.ID.Int, feed.URL)
+	if err != nil || feed.Folder == "" {
+		return err
+	}
+
+	folderID, err := data.SelectOrCreateFolder(context.Background(), env.pool, env.user.ID.Int, feed.Folder)
+	if err != nil {
+		return err
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), env.pool, feed.URL)
+	if err != nil {
+		return err
+	}
+
+	return data.SetSubscriptionFolder(context.Background(), env.pool, env.user.ID.Int, feedID, pgtype.Int4{Int: folderID, Status: pgtype.Present})
+}
+
+func ExportFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	subs, err := data.SelectSubscriptions(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := data.SelectFolders(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	doc := OpmlDocument{Version: "1.0"}
+	doc.Head.Title = "The Pithy Reader Export for " + env.user.Name.String
+
+	folderOutlines := make(map[int32]*OpmlOutline, len(folders))
+	for _, f := range folders {
+		folderOutlines[f.ID.Int] = &OpmlOutline{Text: f.Name.String, Title: f.Name.String}
+	}
+
+	for _, s := range subs {
+		outline := OpmlOutline{
+			Text:  s.Name.String,
+			Title: s.Name.String,
+			Type:  "rss",
+			URL:   s.URL.String,
+		}
+		if folder, ok := folderOutlines[s.FolderID.Int]; ok && s.FolderID.Status == pgtype.Present {
+			folder.Outlines = append(folder.Outlines, outline)
+		} else {
+			doc.Body.Outlines = append(doc.Body.Outlines, outline)
+		}
+	}
+
+	for _, f := range folders {
+		if folder := folderOutlines[f.ID.Int]; len(folder.Outlines) > 0 {
+			doc.Body.Outlines = append(doc.Body.Outlines, *folder)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="opml.xml"`)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(doc)
+}
+
+func GetFeedsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopySubscriptionsForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func RetryFeedHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	feedID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.RetryFeed(context.Background(), env.pool, env.user.ID.Int, int32(feedID), time.Now())
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func GetFoldersHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyFoldersForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func CreateFolderHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var folder struct {
+		Name string `json:"name"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&folder); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if folder.Name == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must include the attribute "name"`)
+		return
+	}
+
+	folderID, err := data.CreateFolder(context.Background(), env.pool, env.user.ID.Int, folder.Name)
+	if err, ok := err.(data.DuplicationError); ok {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `"%s" is already taken`, err.Field)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID   int32  `json:"id"`
+		Name string `json:"name"`
+	}{folderID, folder.Name})
+}
+
+func UpdateFolderHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	folderID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	var folder struct {
+		Name string `json:"name"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&folder); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if folder.Name == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must include the attribute "name"`)
+		return
+	}
+
+	err = data.RenameFolder(context.Background(), env.pool, env.user.ID.Int, int32(folderID), folder.Name)
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err, ok := err.(data.DuplicationError); ok {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `"%s" is already taken`, err.Field)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func DeleteFolderHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	folderID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.DeleteFolder(context.Background(), env.pool, env.user.ID.Int, int32(folderID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func GetTagsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyTagsForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func DeleteTagHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	tagID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.DeleteTag(context.Background(), env.pool, env.user.ID.Int, int32(tagID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func GetFilterRulesHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyFilterRulesForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+var filterRuleFields = []string{"title", "content", "author", "url"}
+var filterRuleMatchTypes = []string{"keyword", "regex"}
+var filterRuleActions = []string{"mark_read", "star", "tag"}
+
+func CreateFilterRuleHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var rule struct {
+		FeedID    *int32 `json:"feedID"`
+		Field     string `json:"field"`
+		MatchType string `json:"matchType"`
+		Pattern   string `json:"pattern"`
+		Action    string `json:"action"`
+		Tag       string `json:"tag"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&rule); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if rule.MatchType == "" {
+		rule.MatchType = "keyword"
+	}
+
+	if !containsString(filterRuleFields, rule.Field) {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `Attribute "field" must be one of %s`, strings.Join(filterRuleFields, ", "))
+		return
+	}
+	if !containsString(filterRuleMatchTypes, rule.MatchType) {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `Attribute "matchType" must be one of %s`, strings.Join(filterRuleMatchTypes, ", "))
+		return
+	}
+	if rule.Pattern == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must include the attribute "pattern"`)
+		return
+	}
+	if !containsString(filterRuleActions, rule.Action) {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, `Attribute "action" must be one of %s`, strings.Join(filterRuleActions, ", "))
+		return
+	}
+	if rule.Action == "tag" && rule.Tag == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must include the attribute "tag" when "action" is "tag"`)
+		return
+	}
+
+	filterRule := &data.FilterRule{
+		Field:     pgtype.Varchar{String: rule.Field, Status: pgtype.Present},
+		MatchType: pgtype.Varchar{String: rule.MatchType, Status: pgtype.Present},
+		Pattern:   pgtype.Varchar{String: rule.Pattern, Status: pgtype.Present},
+		Action:    pgtype.Varchar{String: rule.Action, Status: pgtype.Present},
+		FeedID:    pgtype.Int4{Status: pgtype.Null},
+		TagID:     pgtype.Int4{Status: pgtype.Null},
+	}
+	if rule.FeedID != nil {
+		filterRule.FeedID = pgtype.Int4{Int: *rule.FeedID, Status: pgtype.Present}
+	}
+
+	if rule.Action == "tag" {
+		tagID, err := data.SelectOrCreateTag(context.Background(), env.pool, env.user.ID.Int, rule.Tag)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		filterRule.TagID = pgtype.Int4{Int: tagID, Status: pgtype.Present}
+	}
+
+	ruleID, err := data.CreateFilterRule(context.Background(), env.pool, env.user.ID.Int, filterRule)
+	if err == data.ErrNotFound {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Not subscribed to feed")
+		return
+	}
+	if err, ok := err.(data.InvalidPatternError); ok {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, err.Message)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID int32 `json:"id"`
+	}{ruleID})
+}
+
+func DeleteFilterRuleHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	ruleID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.DeleteFilterRule(context.Background(), env.pool, env.user.ID.Int, int32(ruleID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func GetWebhooksHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := data.CopyWebhooksForUserAsJSON(context.Background(), env.pool, w, env.user.ID.Int); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateWebhookHandler registers a webhook. A secret is generated if none is
+// given. The secret is only included in this response.
+func CreateWebhookHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var webhook struct {
+		URL      string `json:"url"`
+		Secret   string `json:"secret"`
+		FeedID   *int32 `json:"feedID"`
+		FolderID *int32 `json:"folderID"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&webhook); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Attribute "url" must be an http or https URL`)
+		return
+	}
+	if webhook.FeedID != nil && webhook.FolderID != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Request must not include both "feedID" and "folderID"`)
+		return
+	}
+
+	if webhook.Secret == "" {
+		secret, err := genRandToken(20)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	input := &data.Webhook{
+		URL:      pgtype.Varchar{String: webhook.URL, Status: pgtype.Present},
+		Secret:   pgtype.Varchar{String: webhook.Secret, Status: pgtype.Present},
+		FeedID:   pgtype.Int4{Status: pgtype.Null},
+		FolderID: pgtype.Int4{Status: pgtype.Null},
+	}
+	if webhook.FeedID != nil {
+		input.FeedID = pgtype.Int4{Int: *webhook.FeedID, Status: pgtype.Present}
+	}
+	if webhook.FolderID != nil {
+		input.FolderID = pgtype.Int4{Int: *webhook.FolderID, Status: pgtype.Present}
+	}
+
+	webhookID, err := data.CreateWebhook(context.Background(), env.pool, env.user.ID.Int, input)
+	if err == data.ErrNotFound {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Not subscribed to feed or folder not found")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID     int32  `json:"id"`
+		Secret string `json:"secret"`
+	}{webhookID, webhook.Secret})
+}
+
+func DeleteWebhookHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	webhookID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	err = data.DeleteWebhook(context.Background(), env.pool, env.user.ID.Int, int32(webhookID))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+const (
+	defaultWebhookDeliveries = 50
+	maxWebhookDeliveries     = 500
+)
+
+// GetWebhookDeliveriesHandler lists the most recent deliveries of a webhook,
+// newest first. limit defaults to 50.
+func GetWebhookDeliveriesHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	webhookID, err := strconv.ParseInt(req.FormValue("id"), 10, 32)
+	if err != nil {
+		// If not an integer it clearly can't be found
+		http.NotFound(w, req)
+		return
+	}
+
+	limit := int64(defaultWebhookDeliveries)
+	if s := req.FormValue("limit"); s != "" {
+		limit, err = strconv.ParseInt(s, 10, 32)
+		if err != nil || limit < 1 || limit > maxWebhookDeliveries {
+			w.WriteHeader(422)
+			fmt.Fprintf(w, `Parameter "limit" must be between 1 and %d`, maxWebhookDeliveries)
+			return
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err = data.CopyWebhookDeliveriesAsJSON(context.Background(), env.pool, buf, env.user.ID.Int, int32(webhookID), int32(limit))
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	buf.WriteTo(w)
+}
+
+func GetAccountHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var user struct {
+		ID           int32  `json:"id"`
+		Name         string `json:"name"`
+		Email        string `json:"email"`
+		FeverEnabled bool   `json:"fever_enabled"`
+	}
+
+	user.ID = env.user.ID.Int
+	user.Name = env.user.Name.String
+	user.Email = env.user.Email.String
+
+	var err error
+	user.FeverEnabled, err = data.SelectFeverEnabled(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func UpdateAccountHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var update struct {
+		Email            string `json:"email"`
+		ExistingPassword string `json:"existingPassword"`
+		NewPassword      string `json:"newPassword"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&update); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if !IsPassword(env.user, update.ExistingPassword) {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Bad existing password")
+		return
+	}
+
+	user := &data.User{}
+	user.Email = newStringFallback(update.Email, pgtype.Null)
+
+	if update.NewPassword != "" {
+		err := SetPassword(user, update.NewPassword)
+		if err != nil {
 			w.WriteHeader(422)
 			fmt.Fprintln(w, err)
 			return
@@ -493,7 +1526,239 @@ func UpdateAccountHandler(w http.ResponseWriter, req *http.Request, env *environ
 		w.WriteHeader(500)
 		fmt.Fprintln(w, `Internal server error`)
 		env.logger.Error("UpdateUser", "err", err)
+		return
+	}
+
+	password := update.ExistingPassword
+	if update.NewPassword != "" {
+		password = update.NewPassword
+	}
+	// The account is already updated so a failure only leaves Fever clients
+	// with the old credentials
+	err = updateFeverAPIKeys(env.pool, env.user.ID.Int, env.user.Name.String, user.Email, password)
+	if err != nil {
+		env.logger.Error("updateFeverAPIKeys", "err", err)
+	}
+}
+
+// EnableFeverHandler enables the Fever API for the user. The password is
+// required because the Fever API keys are derived from it.
+func EnableFeverHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var enable struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&enable); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if !IsPassword(env.user, enable.Password) {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Bad password")
+		return
+	}
+
+	err := setFeverAPIKeys(env.pool, env.user.ID.Int, env.user.Name.String, env.user.Email, enable.Password)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		env.logger.Error("setFeverAPIKeys", "err", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func DisableFeverHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	err := data.DeleteFeverAPIKeys(context.Background(), env.pool, env.user.ID.Int)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetDigestSettingsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	settings, err := data.SelectDigestSettings(context.Background(), env.pool, env.user.ID.Int)
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Frequency    string     `json:"frequency"`
+		TimeZone     string     `json:"time_zone"`
+		Hour         int16      `json:"hour"`
+		Weekday      int16      `json:"weekday"`
+		NextSendTime time.Time  `json:"next_send_time"`
+		LastSendTime *time.Time `json:"last_send_time"`
+	}
+	response.Frequency = settings.Frequency.String
+	response.TimeZone = settings.TimeZone.String
+	response.Hour = settings.Hour.Int
+	response.Weekday = settings.Weekday.Int
+	response.NextSendTime = settings.NextSendTime.Time
+	if settings.LastSendTime.Status == pgtype.Present {
+		response.LastSendTime = &settings.LastSendTime.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateDigestSettingsHandler opts the user in to email digests of new unread
+// items or changes when they are sent.
+func UpdateDigestSettingsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	var update struct {
+		Frequency string `json:"frequency"`
+		TimeZone  string `json:"timeZone"`
+		Hour      *int16 `json:"hour"`
+		Weekday   *int16 `json:"weekday"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&update); err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintf(w, "Error decoding request: %v", err)
+		return
+	}
+
+	if env.mailer == nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Mail is not configured on this server")
+		return
+	}
+	if env.user.Email.Status != pgtype.Present || env.user.Email.String == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Account must have an email address to receive digests")
+		return
+	}
+	if update.Frequency != "daily" && update.Frequency != "weekly" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Attribute "frequency" must be "daily" or "weekly"`)
+		return
+	}
+	if update.TimeZone == "" {
+		update.TimeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(update.TimeZone)
+	if err != nil {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Attribute "timeZone" must be an IANA time zone name`)
+		return
+	}
+	if update.Hour == nil || *update.Hour < 0 || *update.Hour > 23 {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Attribute "hour" must be between 0 and 23`)
+		return
+	}
+	weekday := int16(time.Monday)
+	if update.Weekday != nil {
+		weekday = *update.Weekday
+	}
+	if weekday < 0 || weekday > 6 {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, `Attribute "weekday" must be between 0 (Sunday) and 6`)
+		return
+	}
+
+	// Only used when the user was not already opted in
+	token, err := genRandToken(24)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	nextSendTime := nextDigestTime(time.Now(), loc, update.Frequency, int(*update.Hour), time.Weekday(weekday))
+
+	settings := &data.DigestSettings{
+		Frequency:        pgtype.Varchar{String: update.Frequency, Status: pgtype.Present},
+		TimeZone:         pgtype.Varchar{String: update.TimeZone, Status: pgtype.Present},
+		Hour:             pgtype.Int2{Int: *update.Hour, Status: pgtype.Present},
+		Weekday:          pgtype.Int2{Int: weekday, Status: pgtype.Present},
+		UnsubscribeToken: pgtype.Varchar{String: token, Status: pgtype.Present},
+		NextSendTime:     pgtype.Timestamptz{Time: nextSendTime, Status: pgtype.Present},
+	}
+	err = data.SaveDigestSettings(context.Background(), env.pool, env.user.ID.Int, settings)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		env.logger.Error("SaveDigestSettings failed", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func DeleteDigestSettingsHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	err := data.DeleteDigestSettings(context.Background(), env.pool, env.user.ID.Int)
+	if err == data.ErrNotFound {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnsubscribeDigestHandler opts out the user whose digest mail linked here.
+// It does not require a session. POST is used by mail clients that support
+// one-click List-Unsubscribe.
+var confirmUnsubscribeDigestTmpl = template.Must(template.New("confirmUnsubscribeDigest").Parse(`<!DOCTYPE html>
+<html>
+<head><title>The Pithy Reader</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="token" value="{{.}}">
+<p>Stop receiving The Pithy Reader digest emails?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+</body>
+</html>
+`))
+
+// ConfirmUnsubscribeDigestHandler shows the page the unsubscribe link in a
+// digest opens. Link scanners and prefetchers follow links so it only asks to
+// confirm with a POST to UnsubscribeDigestHandler.
+func ConfirmUnsubscribeDigestHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	token := req.FormValue("token")
+	if token == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Missing token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	confirmUnsubscribeDigestTmpl.Execute(w, token)
+}
+
+// UnsubscribeDigestHandler opts the user with token out of digests. Mail
+// clients also POST to it for one-click unsubscribe.
+func UnsubscribeDigestHandler(w http.ResponseWriter, req *http.Request, env *environment) {
+	token := req.FormValue("token")
+	if token == "" {
+		w.WriteHeader(422)
+		fmt.Fprintln(w, "Missing token")
+		return
 	}
+
+	// An unknown token was already unsubscribed, e.g. by following the link twice
+	err := data.DeleteDigestSettingsByUnsubscribeToken(context.Background(), env.pool, token)
+	if err != nil && err != data.ErrNotFound {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintln(w, "You will no longer receive The Pithy Reader digest emails.")
 }
 
 func RequestPasswordResetHandler(w http.ResponseWriter, req *http.Request, env *environment) {
@@ -622,6 +1887,11 @@ func ResetPasswordHandler(w http.ResponseWriter, req *http.Request, env *environ
 		return
 	}
 
+	err = updateFeverAPIKeys(env.pool, user.ID.Int, user.Name.String, user.Email, resetPassword.Password)
+	if err != nil {
+		env.logger.Error("updateFeverAPIKeys", "err", err)
+	}
+
 	sessionID, err := genSessionID()
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
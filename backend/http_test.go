@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,7 +69,7 @@ func newConnPool(t testing.TB) *pgxpool.Pool {
 
 // Empty all data in the entire database
 func empty(pool *pgxpool.Pool) error {
-	tables := []string{"feeds", "items", "password_resets", "sessions", "subscriptions", "unread_items", "users"}
+	tables := []string{"starred_items", "item_tags", "deleted_items", "digest_settings", "digested_items", "feeds", "items", "enclosures", "fever_api_keys", "filter_rules", "folders", "tags", "password_resets", "sessions", "subscriptions", "unread_items", "users", "webhook_deliveries", "webhooks", "websub_subscriptions"}
 	for _, table := range tables {
 		_, err := pool.Exec(context.Background(), fmt.Sprintf("delete from %s", table))
 		if err != nil {
@@ -120,6 +121,85 @@ func TestExportOPML(t *testing.T) {
 	}
 }
 
+func TestCreateSubscriptionHandlerValidate(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedFetchCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.rss" {
+			http.NotFound(w, r)
+			return
+		}
+		feedFetchCount++
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>News</title><item><title>Snow</title><link>http://example.com/snow</link></item></channel></rss>`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		url       string
+		code      int
+		errorCode string
+	}{
+		{url: ts.URL + "/missing.rss", code: 422, errorCode: "fetch_failed"},
+		{url: ts.URL + "/feed.rss", code: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "http://example.com/subscriptions", strings.NewReader(fmt.Sprintf(`{"url": %q, "validate": true}`, tt.url)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env := &environment{pool: pool, feedUpdater: NewFeedUpdater(pool, getLogger(t)), logger: getLogger(t)}
+		env.user = &data.User{ID: pgtype.Int4{Int: userID, Status: pgtype.Present}}
+
+		w := httptest.NewRecorder()
+		CreateSubscriptionHandler(w, req, env)
+
+		if w.Code != tt.code {
+			t.Errorf("%s: Expected HTTP status %d, instead received %d", tt.url, tt.code, w.Code)
+			continue
+		}
+
+		if tt.errorCode != "" {
+			var response struct {
+				Error feedValidationError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error.Code != tt.errorCode {
+				t.Errorf("%s: Expected error code %s, instead received %s", tt.url, tt.errorCode, response.Error.Code)
+			}
+		}
+	}
+
+	// Discovery and validation share one fetch
+	if feedFetchCount != 1 {
+		t.Errorf("Expected the feed to be fetched once, but it was fetched %d times", feedFetchCount)
+	}
+
+	subscriptions, err := data.SelectSubscriptions(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 {
+		t.Fatalf("Found %d subscriptions, expected 1", len(subscriptions))
+	}
+	if subscriptions[0].Name.String != "News" {
+		t.Errorf("Expected feed name %v, got %v", "News", subscriptions[0].Name.String)
+	}
+	if subscriptions[0].ItemCount.Int != 1 {
+		t.Errorf("Expected %d items, got %d", 1, subscriptions[0].ItemCount.Int)
+	}
+}
+
 func TestGetAccountHandler(t *testing.T) {
 	pool := newConnPool(t)
 	user := &data.User{
@@ -560,3 +640,62 @@ func TestResetPasswordHandlerTokenDoesNotMatchPasswordReset(t *testing.T) {
 		t.Errorf("Expected HTTP status %d, instead received %d", 404, w.Code)
 	}
 }
+
+func TestMarkAllItemsReadHandlerRequiresScope(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/api/items/unread/mark_all_read", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &environment{user: &data.User{ID: pgtype.Int4{Int: 1, Status: pgtype.Present}}}
+	w := httptest.NewRecorder()
+	MarkAllItemsReadHandler(w, req, env)
+
+	if w.Code != 422 {
+		t.Errorf("Expected HTTP status 422, got %d", w.Code)
+	}
+}
+
+func TestParseItemPage(t *testing.T) {
+	defaults := data.ItemPage{Limit: 250, Descending: true}
+
+	tests := []struct {
+		query    string
+		expected data.ItemPage
+		err      bool
+	}{
+		{"", defaults, false},
+		{"before=1583301600000000_10&limit=20&sort=asc", data.ItemPage{Before: data.ItemCursor{SortTime: time.Unix(1583301600, 0), ID: 10}, Limit: 20}, false},
+		{"after=1583301600000001_7", data.ItemPage{After: data.ItemCursor{SortTime: time.Unix(1583301600, 1000), ID: 7}, Limit: 250, Descending: true}, false},
+		{"after=-5_7", data.ItemPage{After: data.ItemCursor{SortTime: time.Unix(0, -5000), ID: 7}, Limit: 250, Descending: true}, false},
+		{"limit=0", defaults, false},
+		{"limit=1001", data.ItemPage{}, true},
+		{"limit=-1", data.ItemPage{}, true},
+		{"after=x", data.ItemPage{}, true},
+		{"after=7", data.ItemPage{}, true},
+		{"after=1583301600000000_0", data.ItemPage{}, true},
+		{"sort=sideways", data.ItemPage{}, true},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "http://example.com/items/archived?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := parseItemPage(req, defaults)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: Expected an error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tt.query, err)
+			continue
+		}
+		if page != tt.expected {
+			t.Errorf("%s: Expected %#v, got %#v", tt.query, tt.expected, page)
+		}
+	}
+}
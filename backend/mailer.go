@@ -2,4 +2,5 @@ package main
 
 type Mailer interface {
 	SendPasswordResetMail(to, token string) error
+	SendDigestMail(to string, digest *Digest) error
 }
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/jackc/cli"
 	"github.com/jackc/pgx/v4/log/log15adapter"
@@ -62,6 +63,26 @@ func main() {
 			},
 			Action: ResetPassword,
 		},
+		{
+			Name:        "retry-feed",
+			Usage:       "retry fetching a failing or suspended feed",
+			Synopsis:    "[command options] url",
+			Description: "unsuspend a feed and fetch it on the next update cycle",
+			Flags: []cli.Flag{
+				cli.StringFlag{"config, c", "tpr.conf", "path to config file"},
+			},
+			Action: RetryFeed,
+		},
+		{
+			Name:        "prune-items",
+			Usage:       "delete expired items",
+			Synopsis:    "[command options]",
+			Description: "delete the items expired under the retention policy",
+			Flags: []cli.Flag{
+				cli.StringFlag{"config, c", "tpr.conf", "path to config file"},
+			},
+			Action: PruneItems,
+		},
 	}
 
 	app.Run(os.Args)
@@ -169,6 +190,81 @@ func loadHTTPConfig(c *cli.Context, conf ini.File) (httpConfig, error) {
 	return config, nil
 }
 
+func configureFeedUpdater(u *FeedUpdater, conf ini.File) error {
+	if s, ok := conf.Get("feeds", "min_fetch_interval"); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Bad feeds -- min_fetch_interval: %v", err)
+		}
+		u.minFetchInterval = d
+	}
+
+	if s, ok := conf.Get("feeds", "max_fetch_interval"); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Bad feeds -- max_fetch_interval: %v", err)
+		}
+		u.maxFetchInterval = d
+	}
+
+	if s, ok := conf.Get("feeds", "suspend_after_failures"); ok {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Bad feeds -- suspend_after_failures: %s", s)
+		}
+		u.suspendAfterFailures = int32(n)
+	}
+
+	if s, ok := conf.Get("websub", "callback_url"); ok {
+		if _, err := url.Parse(s); err != nil {
+			return fmt.Errorf("Bad websub -- callback_url: %v", err)
+		}
+		u.websubCallbackURL = s
+	}
+
+	if s, ok := conf.Get("websub", "push_fetch_interval"); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("Bad websub -- push_fetch_interval: %v", err)
+		}
+		u.pushFetchInterval = d
+	}
+
+	if u.minFetchInterval > u.maxFetchInterval {
+		return errors.New("Bad feeds -- min_fetch_interval must not be greater than max_fetch_interval")
+	}
+
+	return nil
+}
+
+func configureItemPruner(p *ItemPruner, conf ini.File) error {
+	if s, ok := conf.Get("retention", "max_items_per_feed"); ok {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Bad retention -- max_items_per_feed: %s", s)
+		}
+		p.policy.MaxItemsPerFeed = int32(n)
+	}
+
+	if s, ok := conf.Get("retention", "max_item_age_days"); ok {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Bad retention -- max_item_age_days: %s", s)
+		}
+		p.policy.MaxItemAge = time.Duration(n) * 24 * time.Hour
+	}
+
+	if s, ok := conf.Get("retention", "interval"); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Bad retention -- interval: %s", s)
+		}
+		p.interval = d
+	}
+
+	return nil
+}
+
 func newMailer(conf ini.File, logger log.Logger) (Mailer, error) {
 	mailConf := conf.Section("mail")
 	if len(mailConf) == 0 {
@@ -243,8 +339,27 @@ func Serve(c *cli.Context) {
 		os.Exit(1)
 	}
 
-	apiHandler := NewAPIHandler(pool, mailer, logger.New("module", "http"))
+	feedUpdater := NewFeedUpdater(pool, logger.New("module", "feedUpdater"))
+	if err := configureFeedUpdater(feedUpdater, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	itemPruner := NewItemPruner(pool, logger.New("module", "itemPruner"))
+	if err := configureItemPruner(itemPruner, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	webhookDeliverer := NewWebhookDeliverer(pool, logger.New("module", "webhookDeliverer"))
+
+	apiHandler := NewAPIHandler(pool, mailer, feedUpdater, logger.New("module", "http"))
 	http.Handle("/api/", http.StripPrefix("/api", apiHandler))
+	http.Handle("/fever/", NewFeverHandler(pool, logger.New("module", "fever")))
+	http.Handle("/websub/", NewWebSubHandler(feedUpdater, logger.New("module", "websub")))
+	greaderHandler := NewGReaderHandler(pool, logger.New("module", "greader"))
+	http.Handle("/accounts/ClientLogin", greaderHandler)
+	http.Handle("/reader/api/0/", greaderHandler)
 
 	if httpConfig.staticURL != "" {
 		staticURL, err := url.Parse(httpConfig.staticURL)
@@ -258,8 +373,14 @@ func Serve(c *cli.Context) {
 	listenAt := fmt.Sprintf("%s:%s", httpConfig.listenAddress, httpConfig.listenPort)
 	fmt.Printf("Starting to listen on: %s\n", listenAt)
 
-	feedUpdater := NewFeedUpdater(pool, logger.New("module", "feedUpdater"))
 	go feedUpdater.KeepFeedsFresh()
+	if itemPruner.policy.IsEnabled() {
+		go itemPruner.KeepItemsPruned()
+	}
+	go webhookDeliverer.KeepWebhooksDelivered()
+	if mailer != nil {
+		go NewDigestSender(pool, mailer, logger.New("module", "digestSender")).KeepDigestsSent()
+	}
 
 	if err := http.ListenAndServe(listenAt, nil); err != nil {
 		os.Stderr.WriteString("Could not start web server!\n")
@@ -314,6 +435,84 @@ func ResetPassword(c *cli.Context) {
 		os.Exit(1)
 	}
 
+	err = updateFeverAPIKeys(pool, user.ID.Int, user.Name.String, user.Email, password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fever API keys were not updated:", err)
+	}
+
 	fmt.Println("User:", name)
 	fmt.Println("Password:", password)
 }
+
+func RetryFeed(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		cli.ShowCommandHelp(c, c.Command.Name)
+		os.Exit(1)
+	}
+
+	feedURL := c.Args()[0]
+
+	conf, err := loadConfig(c.String("config"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger, err := newLogger(conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pool, err := newPool(conf, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = data.RetryFeedByURL(context.Background(), pool, feedURL, time.Now())
+	if err == data.ErrNotFound {
+		fmt.Fprintln(os.Stderr, "Feed not found:", feedURL)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Feed will be retried:", feedURL)
+}
+
+func PruneItems(c *cli.Context) {
+	conf, err := loadConfig(c.String("config"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger, err := newLogger(conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pool, err := newPool(conf, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	itemPruner := NewItemPruner(pool, logger.New("module", "itemPruner"))
+	if err := configureItemPruner(itemPruner, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	result, err := itemPruner.PruneItems(time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Items deleted:", result.Items)
+}
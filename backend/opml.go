@@ -19,9 +19,45 @@ type OpmlBody struct {
 	Outlines []OpmlOutline `xml:"outline"`
 }
 
+// OpmlOutline is either a feed (URL is set) or a folder of other outlines.
 type OpmlOutline struct {
-	Text  string `xml:"text,attr"`
-	Title string `xml:"title,attr"`
-	Type  string `xml:"type,attr"`
-	URL   string `xml:"xmlUrl,attr"`
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	URL      string        `xml:"xmlUrl,attr,omitempty"`
+	Outlines []OpmlOutline `xml:"outline"`
+}
+
+// OpmlFeed is a feed found in an OPML document along with the name of the
+// folder it was in.
+type OpmlFeed struct {
+	Title  string
+	URL    string
+	Folder string
+}
+
+// Feeds returns all feeds in the document. Folders are not nested in The Pithy
+// Reader, so a feed nested several folders deep belongs to the innermost one.
+func (b OpmlBody) Feeds() []OpmlFeed {
+	var feeds []OpmlFeed
+	appendOpmlFeeds(&feeds, b.Outlines, "")
+	return feeds
+}
+
+func appendOpmlFeeds(feeds *[]OpmlFeed, outlines []OpmlOutline, folder string) {
+	for _, o := range outlines {
+		if o.URL != "" {
+			*feeds = append(*feeds, OpmlFeed{Title: o.Title, URL: o.URL, Folder: folder})
+			continue
+		}
+
+		name := o.Title
+		if name == "" {
+			name = o.Text
+		}
+		if name == "" {
+			name = folder
+		}
+		appendOpmlFeeds(feeds, o.Outlines, name)
+	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestOpmlBodyFeeds(t *testing.T) {
+	body := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Top" title="Top" type="rss" xmlUrl="http://example.com/top.xml"/>
+    <outline text="News">
+      <outline text="Daily" title="Daily" type="rss" xmlUrl="http://example.com/daily.xml"/>
+      <outline title="Local">
+        <outline text="Town" title="Town" type="rss" xmlUrl="http://example.com/town.xml"/>
+      </outline>
+      <outline>
+        <outline text="Weather" title="Weather" type="rss" xmlUrl="http://example.com/weather.xml"/>
+      </outline>
+    </outline>
+  </body>
+</opml>`)
+
+	var doc OpmlDocument
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []OpmlFeed{
+		{Title: "Top", URL: "http://example.com/top.xml"},
+		{Title: "Daily", URL: "http://example.com/daily.xml", Folder: "News"},
+		{Title: "Town", URL: "http://example.com/town.xml", Folder: "Local"},
+		{Title: "Weather", URL: "http://example.com/weather.xml", Folder: "News"},
+	}
+
+	actual := doc.Body.Feeds()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, but it was %#v", expected, actual)
+	}
+}
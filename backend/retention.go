@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// ItemPruner periodically deletes items that have expired under its retention
+// policy.
+type ItemPruner struct {
+	policy   data.RetentionPolicy
+	interval time.Duration
+	pool     *pgxpool.Pool
+	logger   log.Logger
+}
+
+func NewItemPruner(pool *pgxpool.Pool, logger log.Logger) *ItemPruner {
+	itemPruner := &ItemPruner{}
+	itemPruner.pool = pool
+	itemPruner.logger = logger
+	itemPruner.interval = time.Hour
+	return itemPruner
+}
+
+func (p *ItemPruner) KeepItemsPruned() {
+	for {
+		startTime := time.Now()
+		p.PruneItems(startTime)
+		sleepUntil(startTime.Add(p.interval))
+	}
+}
+
+// PruneItems deletes what has expired at now.
+func (p *ItemPruner) PruneItems(now time.Time) (data.RetentionResult, error) {
+	result, err := data.DeleteExpiredItems(context.Background(), p.pool, p.policy, now)
+	if err != nil {
+		p.logger.Error("DeleteExpiredItems failed", "error", err)
+		return result, err
+	}
+
+	p.logger.Info("DeleteExpiredItems succeeded", "items", result.Items)
+	return result, nil
+}
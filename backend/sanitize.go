@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/tpr/backend/data"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedElements maps each element that may appear in sanitized content to
+// the attributes it may keep. Elements not in this map are unwrapped (their
+// children are kept) unless they are in droppedElements.
+var allowedElements = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"audio":      {"src", "controls"},
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"caption":    nil,
+	"cite":       nil,
+	"code":       nil,
+	"col":        {"span"},
+	"colgroup":   {"span"},
+	"dd":         nil,
+	"del":        {"cite", "datetime"},
+	"details":    nil,
+	"dfn":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"iframe":     {"src", "width", "height", "allowfullscreen", "title"},
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        {"cite", "datetime"},
+	"kbd":        nil,
+	"li":         {"value"},
+	"mark":       nil,
+	"ol":         {"start", "type"},
+	"p":          nil,
+	"picture":    nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"samp":       nil,
+	"small":      nil,
+	"source":     {"src", "type"},
+	"span":       nil,
+	"strike":     nil,
+	"strong":     nil,
+	"sub":        nil,
+	"summary":    nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan", "align"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan", "align"},
+	"thead":      nil,
+	"time":       {"datetime"},
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+	"video":      {"src", "poster", "controls", "width", "height"},
+}
+
+// droppedElements are removed along with all their children.
+var droppedElements = map[string]bool{
+	"applet":   true,
+	"base":     true,
+	"button":   true,
+	"embed":    true,
+	"form":     true,
+	"frame":    true,
+	"frameset": true,
+	"head":     true,
+	"input":    true,
+	"link":     true,
+	"math":     true,
+	"meta":     true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"select":   true,
+	"style":    true,
+	"svg":      true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+}
+
+var voidElements = map[string]bool{
+	"br":     true,
+	"col":    true,
+	"hr":     true,
+	"img":    true,
+	"source": true,
+}
+
+var urlAttributes = map[string]bool{
+	"cite":   true,
+	"href":   true,
+	"poster": true,
+	"src":    true,
+}
+
+// allowedIframeHosts are the only origins iframes may be loaded from.
+var allowedIframeHosts = map[string]bool{
+	"www.youtube.com":          true,
+	"youtube.com":              true,
+	"www.youtube-nocookie.com": true,
+	"player.vimeo.com":         true,
+}
+
+// sanitizeFeed sanitizes the HTML content of all items in feed in place.
+// Relative URLs are resolved against the item's xml:base, the item's URL, or
+// feedURL in that order of preference. Items whose link is not an http or
+// https URL are removed.
+func sanitizeFeed(feed *data.ParsedFeed, feedURL string) {
+	feedBase, _ := url.Parse(feedURL)
+
+	items := feed.Items[:0]
+	for _, item := range feed.Items {
+		var xmlBase *url.URL
+		if item.BaseURL != "" {
+			xmlBase = resolveURL(feedBase, item.BaseURL)
+		}
+
+		base := feedBase
+		if xmlBase != nil {
+			base = xmlBase
+		}
+
+		item.URL = sanitizeURL(item.URL, base, false)
+		if item.URL == "" {
+			continue
+		}
+		if xmlBase == nil {
+			base, _ = url.Parse(item.URL)
+		}
+
+		item.Summary = sanitizeHTML(item.Summary, base)
+		item.Content = sanitizeHTML(item.Content, base)
+
+		enclosures := make([]data.ParsedEnclosure, 0, len(item.Enclosures))
+		for _, e := range item.Enclosures {
+			e.URL = sanitizeURL(e.URL, base, false)
+			if e.URL == "" {
+				continue
+			}
+			e.ThumbnailURL = sanitizeURL(e.ThumbnailURL, base, false)
+			enclosures = append(enclosures, e)
+		}
+		item.Enclosures = enclosures
+
+		items = append(items, item)
+	}
+	feed.Items = items
+}
+
+// resolveURL resolves ref against base. base may be nil. It returns nil if ref
+// is not a valid URL.
+func resolveURL(base *url.URL, ref string) *url.URL {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil
+	}
+	if base == nil {
+		return u
+	}
+	return base.ResolveReference(u)
+}
+
+// sanitizeHTML removes anything from the HTML fragment s that could run script
+// or load content from an untrusted origin. Relative URLs are resolved against
+// base, which may be nil.
+func sanitizeHTML(s string, base *url.URL) string {
+	if s == "" {
+		return ""
+	}
+
+	parent := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(s), parent)
+	if err != nil {
+		return html.EscapeString(s)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, n := range nodes {
+		writeSanitizedNode(buf, n, base)
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+func writeSanitizedNode(buf *bytes.Buffer, n *html.Node, base *url.URL) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		// Comments, doctypes, etc. are dropped
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedElements[tag] || n.Namespace != "" {
+		return
+	}
+
+	allowedAttrs, ok := allowedElements[tag]
+	if !ok {
+		writeSanitizedChildren(buf, n, base)
+		return
+	}
+
+	attrs := make([]html.Attribute, 0, len(n.Attr))
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !containsString(allowedAttrs, key) {
+			continue
+		}
+
+		if urlAttributes[key] {
+			u := sanitizeURL(a.Val, base, tag == "a" && key == "href")
+			if u == "" {
+				continue
+			}
+			a.Val = u
+		}
+
+		attrs = append(attrs, html.Attribute{Key: key, Val: a.Val})
+	}
+
+	if tag == "iframe" && !isAllowedIframe(attrs) {
+		return
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(tag)
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(a.Key)
+		buf.WriteString(`="`)
+		buf.WriteString(html.EscapeString(a.Val))
+		buf.WriteByte('"')
+	}
+	if tag == "a" {
+		buf.WriteString(` rel="noopener noreferrer"`)
+	}
+	buf.WriteByte('>')
+
+	if voidElements[tag] {
+		return
+	}
+
+	writeSanitizedChildren(buf, n, base)
+
+	buf.WriteString("</")
+	buf.WriteString(tag)
+	buf.WriteByte('>')
+}
+
+func writeSanitizedChildren(buf *bytes.Buffer, n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeSanitizedNode(buf, c, base)
+	}
+}
+
+// sanitizeURL resolves rawURL against base and returns it if it uses a safe
+// scheme. It returns "" if the URL is unsafe or invalid. mailto: is only
+// allowed when allowMailto is true.
+func sanitizeURL(rawURL string, base *url.URL, allowMailto bool) string {
+	// Browsers ignore leading and trailing whitespace and control characters
+	// and strip tabs and newlines anywhere in a URL. Do the same so that things
+	// like "java\tscript:" cannot slip past the scheme check.
+	rawURL = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, rawURL)
+	rawURL = strings.TrimFunc(rawURL, func(r rune) bool { return r <= ' ' })
+	if rawURL == "" {
+		return ""
+	}
+
+	u := resolveURL(base, rawURL)
+	if u == nil {
+		return ""
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "mailto":
+		if !allowMailto {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	return u.String()
+}
+
+func isAllowedIframe(attrs []html.Attribute) bool {
+	for _, a := range attrs {
+		if a.Key == "src" {
+			u, err := url.Parse(a.Val)
+			return err == nil && u.Scheme == "https" && allowedIframeHosts[strings.ToLower(u.Host)]
+		}
+	}
+	return false
+}
+
+func containsString(a []string, s string) bool {
+	for _, e := range a {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
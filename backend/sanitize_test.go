@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/jackc/tpr/backend/data"
+)
+
+var sanitizeHTMLTests = []struct {
+	name     string
+	unsafe   string
+	expected string
+}{
+	{"Plain text", "Snow & ice", "Snow &amp; ice"},
+	{"Safe markup", "<p>Snow is <b>coming</b></p>", "<p>Snow is <b>coming</b></p>"},
+	{"Script element", `<p>Snow</p><script>alert("xss")</script>`, "<p>Snow</p>"},
+	{"Script element uppercase", `<SCRIPT SRC="http://evil.example.com/xss.js"></SCRIPT>`, ""},
+	{"Style element", `<style>body { display: none }</style><p>Snow</p>`, "<p>Snow</p>"},
+	{"Event handler attribute", `<img src="http://example.org/a.png" onerror="alert(1)">`, `<img src="http://example.org/a.png">`},
+	{"Event handler on allowed element", `<p onclick="alert(1)" onmouseover="alert(2)">Snow</p>`, "<p>Snow</p>"},
+	{"Style attribute", `<p style="position:fixed;top:0">Snow</p>`, "<p>Snow</p>"},
+	{"javascript: href", `<a href="javascript:alert(1)">Snow</a>`, `<a rel="noopener noreferrer">Snow</a>`},
+	{"javascript: href mixed case", `<a href="JaVaScRiPt:alert(1)">Snow</a>`, `<a rel="noopener noreferrer">Snow</a>`},
+	{"javascript: href with entity encoded tab", `<a href="java&#x09;script:alert(1)">Snow</a>`, `<a rel="noopener noreferrer">Snow</a>`},
+	{"javascript: href with leading whitespace", `<a href=" &#14; javascript:alert(1)">Snow</a>`, `<a rel="noopener noreferrer">Snow</a>`},
+	{"vbscript: href", `<a href="vbscript:msgbox(1)">Snow</a>`, `<a rel="noopener noreferrer">Snow</a>`},
+	{"data: img src", `<img src="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=">`, `<img>`},
+	{"mailto: href", `<a href="mailto:jack@example.org">Mail</a>`, `<a href="mailto:jack@example.org" rel="noopener noreferrer">Mail</a>`},
+	{"mailto: img src", `<img src="mailto:jack@example.org">`, `<img>`},
+	{"Iframe from unknown origin", `<iframe src="https://evil.example.com/"></iframe><p>Snow</p>`, "<p>Snow</p>"},
+	{"Iframe without src", `<iframe srcdoc="<script>alert(1)</script>"></iframe>`, ""},
+	{"Iframe from allowed origin", `<iframe src="https://www.youtube.com/embed/abc" onload="alert(1)" allowfullscreen></iframe>`, `<iframe src="https://www.youtube.com/embed/abc" allowfullscreen=""></iframe>`},
+	{"Iframe from allowed origin over http", `<iframe src="http://www.youtube.com/embed/abc"></iframe>`, ""},
+	{"Object and embed", `<object data="http://evil.example.com/x.swf"><embed src="http://evil.example.com/x.swf"></object>`, ""},
+	{"Form", `<form action="http://evil.example.com/"><input name="password"><button>Go</button></form>`, ""},
+	{"SVG with script", `<svg><script>alert(1)</script></svg>`, ""},
+	{"SVG onload", `<svg onload="alert(1)"/>`, ""},
+	{"Math with link", `<math><mi xlink:href="javascript:alert(1)">x</mi></math>`, ""},
+	{"Meta refresh", `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`, ""},
+	{"Base element", `<base href="http://evil.example.com/"><a href="/foo">Foo</a>`, `<a href="http://example.org/foo" rel="noopener noreferrer">Foo</a>`},
+	{"Comment", `<!-- <script>alert(1)</script> --><p>Snow</p>`, "<p>Snow</p>"},
+	{"Unknown element is unwrapped", `<blink><font color="red">Snow</font></blink>`, "Snow"},
+	{"Unclosed tags", `<p><b>Snow`, "<p><b>Snow</b></p>"},
+	{"Attribute breakout", `<img src="http://example.org/a.png" alt='"><script>alert(1)</script>'>`, `<img src="http://example.org/a.png" alt="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;">`},
+	{"Escaped markup in text", `&lt;script&gt;alert(1)&lt;/script&gt;`, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+	{"Relative href", `<a href="snow-storm">Snow</a>`, `<a href="http://example.org/news/snow-storm" rel="noopener noreferrer">Snow</a>`},
+	{"Root relative src", `<img src="/images/snow.png">`, `<img src="http://example.org/images/snow.png">`},
+	{"Protocol relative src", `<img src="//cdn.example.org/snow.png">`, `<img src="http://cdn.example.org/snow.png">`},
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	base, err := url.Parse("http://example.org/news/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range sanitizeHTMLTests {
+		actual := sanitizeHTML(tt.unsafe, base)
+		if actual != tt.expected {
+			t.Errorf("%d. %s: expected %#v, but it was %#v", i, tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestSanitizeFeed(t *testing.T) {
+	body := []byte(`<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:base="http://example.org/blog/">
+  <title>News</title>
+  <entry>
+    <title>Snow Storm</title>
+    <link href="2014/snow-storm" />
+    <content type="html">&lt;img src="snow.png" onerror="alert(1)"&gt;&lt;script&gt;alert(1)&lt;/script&gt;</content>
+  </entry>
+  <entry xml:base="http://static.example.org/">
+    <title>Blizzard</title>
+    <link href="http://example.org/blizzard" />
+    <summary type="html">&lt;a href="javascript:alert(1)"&gt;Blizzard&lt;/a&gt;</summary>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><img src="blizzard.png"/></div></content>
+  </entry>
+  <entry>
+    <title>Hostile</title>
+    <link href="javascript:alert(1)" />
+  </entry>
+</feed>`)
+
+	feed, err := parseFeed(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sanitizeFeed(feed, "http://example.org/feed.xml")
+
+	expected := []data.ParsedItem{
+		{
+			Title:   "Snow Storm",
+			URL:     "http://example.org/blog/2014/snow-storm",
+			Content: `<img src="http://example.org/blog/snow.png">`,
+		},
+		{
+			Title:   "Blizzard",
+			URL:     "http://example.org/blizzard",
+			Summary: `<a rel="noopener noreferrer">Blizzard</a>`,
+			Content: `<div><img src="http://static.example.org/blizzard.png"></div>`,
+		},
+	}
+
+	if len(feed.Items) != len(expected) {
+		t.Fatalf("Expected %d items, but instead found %d items", len(expected), len(feed.Items))
+	}
+	for i, item := range feed.Items {
+		if item.URL != expected[i].URL {
+			t.Errorf("Item %d: Expected url %#v, but it was %#v", i, expected[i].URL, item.URL)
+		}
+		if item.Summary != expected[i].Summary {
+			t.Errorf("Item %d: Expected summary %#v, but it was %#v", i, expected[i].Summary, item.Summary)
+		}
+		if item.Content != expected[i].Content {
+			t.Errorf("Item %d: Expected content %#v, but it was %#v", i, expected[i].Content, item.Content)
+		}
+	}
+}
@@ -43,3 +43,53 @@ func (m *SMTPMailer) SendPasswordResetMail(to, token string) error {
 	m.logger.Info("SendPasswordResetEmail", "to", to)
 	return nil
 }
+
+var digestMailTmpl = template.Must(template.New("digestMailTemplate").Parse("To: {{.To}}\r\n" +
+	"Subject: The Pithy Reader Digest: {{.Digest.ItemCount}} new item{{if ne .Digest.ItemCount 1}}s{{end}}\r\n" +
+	"List-Unsubscribe: <{{.UnsubscribeURL}}>\r\n" +
+	"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n" +
+	"\r\n" +
+	"{{range .Digest.Feeds}}{{.Name}}\r\n" +
+	"{{range .Items}}  {{.Title}}\r\n  {{.URL}}\r\n{{end}}\r\n{{end}}" +
+	"Read them at {{.RootURL}}/#home\r\n" +
+	"\r\n" +
+	"To stop receiving digests: {{.UnsubscribeURL}}\r\n"))
+
+// digestMailMessage returns the message of a digest mail to to.
+func digestMailMessage(rootURL, to string, digest *Digest) ([]byte, error) {
+	var data = struct {
+		RootURL        string
+		To             string
+		UnsubscribeURL string
+		Digest         *Digest
+	}{
+		RootURL:        rootURL,
+		To:             to,
+		UnsubscribeURL: rootURL + "/api/digest/unsubscribe?token=" + digest.UnsubscribeToken,
+		Digest:         digest,
+	}
+
+	buf := &bytes.Buffer{}
+	err := digestMailTmpl.Execute(buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (m *SMTPMailer) SendDigestMail(to string, digest *Digest) error {
+	msg, err := digestMailMessage(m.rootURL, to, digest)
+	if err != nil {
+		return err
+	}
+
+	err = smtp.SendMail(m.ServerAddr, m.Auth, m.From, []string{to}, msg)
+	if err != nil {
+		m.logger.Error("SendDigestMail failed", "to", to, "error", err)
+		return err
+	}
+
+	m.logger.Info("SendDigestMail", "to", to, "itemCount", digest.ItemCount)
+	return nil
+}
@@ -5,8 +5,14 @@ type testPasswordResetMail struct {
 	token string
 }
 
+type testDigestMail struct {
+	to     string
+	digest *Digest
+}
+
 type testMailer struct {
 	sentPasswordResetMails []testPasswordResetMail
+	sentDigestMails        []testDigestMail
 }
 
 func (m *testMailer) SendPasswordResetMail(to, token string) error {
@@ -14,3 +20,9 @@ func (m *testMailer) SendPasswordResetMail(to, token string) error {
 	m.sentPasswordResetMails = append(m.sentPasswordResetMails, e)
 	return nil
 }
+
+func (m *testMailer) SendDigestMail(to string, digest *Digest) error {
+	e := testDigestMail{to: to, digest: digest}
+	m.sentDigestMails = append(m.sentDigestMails, e)
+	return nil
+}
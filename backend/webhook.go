@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// WebhookDeliverer POSTs the webhook deliveries queued when new items are
+// inserted. Failed deliveries are retried with exponential backoff until
+// maxAttempts have been made.
+type WebhookDeliverer struct {
+	client                *http.Client
+	interval              time.Duration // how often due deliveries are looked for
+	maxConcurrentRequests int
+	maxAttempts           int32
+	minRetryDelay         time.Duration
+	logRetention          time.Duration // how long finished deliveries are kept in the log
+	pool                  *pgxpool.Pool
+	logger                log.Logger
+}
+
+func NewWebhookDeliverer(pool *pgxpool.Pool, logger log.Logger) *WebhookDeliverer {
+	deliverer := &WebhookDeliverer{}
+	deliverer.pool = pool
+	deliverer.logger = logger
+	deliverer.client = &http.Client{Timeout: 30 * time.Second}
+	deliverer.interval = 10 * time.Second
+	deliverer.maxConcurrentRequests = 10
+	deliverer.maxAttempts = 10
+	deliverer.minRetryDelay = time.Minute
+	deliverer.logRetention = 30 * 24 * time.Hour
+	return deliverer
+}
+
+func (d *WebhookDeliverer) KeepWebhooksDelivered() {
+	for {
+		startTime := time.Now()
+		d.DeliverDue(startTime)
+
+		if _, err := data.DeleteOldWebhookDeliveries(context.Background(), d.pool, startTime.Add(-d.logRetention)); err != nil {
+			d.logger.Error("DeleteOldWebhookDeliveries failed", "error", err)
+		}
+
+		sleepUntil(startTime.Add(d.interval))
+	}
+}
+
+// DeliverDue attempts the deliveries that are due at now.
+func (d *WebhookDeliverer) DeliverDue(now time.Time) error {
+	deliveries, err := data.SelectDueWebhookDeliveries(context.Background(), d.pool, now, 100)
+	if err != nil {
+		d.logger.Error("SelectDueWebhookDeliveries failed", "error", err)
+		return err
+	}
+
+	deliveryChan := make(chan data.WebhookDelivery)
+	finishChan := make(chan bool)
+
+	worker := func() {
+		for delivery := range deliveryChan {
+			d.deliver(delivery)
+		}
+		finishChan <- true
+	}
+
+	for i := 0; i < d.maxConcurrentRequests; i++ {
+		go worker()
+	}
+
+	for _, delivery := range deliveries {
+		deliveryChan <- delivery
+	}
+	close(deliveryChan)
+
+	for i := 0; i < d.maxConcurrentRequests; i++ {
+		<-finishChan
+	}
+
+	return nil
+}
+
+// deliver makes one attempt of delivery and records the outcome. The payload
+// is signed with the webhook secret in the X-TPR-Signature header.
+func (d *WebhookDeliverer) deliver(delivery data.WebhookDelivery) {
+	attemptTime := time.Now()
+	responseStatus := pgtype.Int4{Status: pgtype.Null}
+
+	failure := func(message string) {
+		attemptCount := delivery.AttemptCount + 1
+		nextAttemptTime := pgtype.Timestamptz{Status: pgtype.Null}
+		if attemptCount < d.maxAttempts {
+			nextAttemptTime = pgtype.Timestamptz{Time: attemptTime.Add(d.retryDelay(attemptCount)), Status: pgtype.Present}
+		}
+
+		d.logger.Warn("webhook delivery failed", "id", delivery.ID, "webhookID", delivery.WebhookID, "attemptCount", attemptCount, "error", message)
+		err := data.RecordWebhookDeliveryFailure(context.Background(), d.pool, delivery.ID, attemptTime, nextAttemptTime, responseStatus, message)
+		if err != nil {
+			d.logger.Error("RecordWebhookDeliveryFailure failed", "id", delivery.ID, "error", err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		failure(err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TPR-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-TPR-Signature", webhookSignature(delivery.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		failure(err.Error())
+		return
+	}
+	resp.Body.Close()
+
+	responseStatus = pgtype.Int4{Int: int32(resp.StatusCode), Status: pgtype.Present}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		failure(fmt.Sprintf("Bad HTTP response: %s", resp.Status))
+		return
+	}
+
+	err = data.RecordWebhookDeliverySuccess(context.Background(), d.pool, delivery.ID, attemptTime, responseStatus.Int)
+	if err != nil {
+		d.logger.Error("RecordWebhookDeliverySuccess failed", "id", delivery.ID, "error", err)
+		return
+	}
+	d.logger.Info("webhook delivery succeeded", "id", delivery.ID, "webhookID", delivery.WebhookID)
+}
+
+// retryDelay returns how long to wait before attempting a delivery that has
+// failed attemptCount times. It starts at the minimum retry delay and doubles
+// with each failure.
+func (d *WebhookDeliverer) retryDelay(attemptCount int32) time.Duration {
+	delay := d.minRetryDelay
+	for i := int32(1); i < attemptCount; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// webhookSignature returns the X-TPR-Signature of payload: "sha256=" followed
+// by the hex encoded HMAC-SHA256 of payload keyed with secret.
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	// HMAC-SHA256 of "payload" keyed with "secret"
+	expected := "sha256=b82fcb791acec57859b989b430a826488ce2e479fdf92326bd0a2e8375a42ba4"
+	if signature := webhookSignature("secret", []byte("payload")); signature != expected {
+		t.Errorf("Expected %s, got %s", expected, signature)
+	}
+}
+
+func TestWebhookDelivererRetryDelay(t *testing.T) {
+	d := NewWebhookDeliverer(nil, log.Root())
+	d.minRetryDelay = time.Minute
+
+	tests := []struct {
+		attemptCount int32
+		expected     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if delay := d.retryDelay(tt.attemptCount); delay != tt.expected {
+			t.Errorf("%d attempts: Expected %v, got %v", tt.attemptCount, tt.expected, delay)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.InsertSubscription(context.Background(), pool, userID, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, "http://foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		signature string
+		body      []byte
+	}
+	requests := make(chan request, 10)
+	failures := 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{signature: r.Header.Get("X-TPR-Signature"), body: body}
+		if failures > 0 {
+			failures--
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	webhookID, err := data.CreateWebhook(context.Background(), pool, userID, &data.Webhook{
+		URL:      pgtype.Varchar{String: ts.URL, Status: pgtype.Present},
+		Secret:   pgtype.Varchar{String: "secret", Status: pgtype.Present},
+		FeedID:   pgtype.Int4{Int: feedID, Status: pgtype.Present},
+		FolderID: pgtype.Int4{Status: pgtype.Null},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = data.CreateWebhook(context.Background(), pool, userID, &data.Webhook{
+		URL:      pgtype.Varchar{String: ts.URL, Status: pgtype.Present},
+		Secret:   pgtype.Varchar{String: "secret", Status: pgtype.Present},
+		FeedID:   pgtype.Int4{Int: feedID + 1, Status: pgtype.Present},
+		FolderID: pgtype.Int4{Status: pgtype.Null},
+	})
+	if err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for feed without subscription, got %v", err)
+	}
+
+	now := time.Now()
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+	update := &data.ParsedFeed{Name: "Foo", Items: []data.ParsedItem{
+		{URL: "http://foo/1", Title: "One"},
+		{URL: "http://foo/2", Title: "Two"},
+	}}
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No delivery when nothing is new
+	err = data.UpdateFeedWithFetchSuccess(context.Background(), pool, feedID, update, nullString, nullString, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewWebhookDeliverer(pool, log.Root())
+	d.DeliverDue(time.Now())
+
+	r := <-requests
+	if r.signature != webhookSignature("secret", r.body) {
+		t.Errorf("Expected signature %s, got %s", webhookSignature("secret", r.body), r.signature)
+	}
+	var payload struct {
+		Event string `json:"event"`
+		Feed  struct {
+			ID   int32  `json:"id"`
+			Name string `json:"name"`
+		} `json:"feed"`
+		Items []struct {
+			Title string `json:"title"`
+		} `json:"items"`
+	}
+	err = json.Unmarshal(r.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "new_items" || payload.Feed.ID != feedID || len(payload.Items) != 2 || payload.Items[0].Title != "One" {
+		t.Errorf("Unexpected payload: %s", r.body)
+	}
+
+	// The failed delivery is not due again until after the retry delay
+	d.DeliverDue(time.Now())
+	select {
+	case r = <-requests:
+		t.Fatalf("Expected no retry before the retry delay, got %s", r.body)
+	default:
+	}
+
+	d.DeliverDue(time.Now().Add(d.minRetryDelay + time.Second))
+	r = <-requests
+	if !bytes.Contains(r.body, []byte("Two")) {
+		t.Errorf("Unexpected retried payload: %s", r.body)
+	}
+
+	var deliveries []struct {
+		Status         string `json:"status"`
+		AttemptCount   int32  `json:"attempt_count"`
+		ResponseStatus int32  `json:"response_status"`
+	}
+	buf := &bytes.Buffer{}
+	err = data.CopyWebhookDeliveriesAsJSON(context.Background(), pool, buf, userID, webhookID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(buf.Bytes(), &deliveries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != "delivered" || deliveries[0].AttemptCount != 2 || deliveries[0].ResponseStatus != 200 {
+		t.Errorf("Unexpected deliveries: %s", buf.String())
+	}
+
+	err = data.CopyWebhookDeliveriesAsJSON(context.Background(), pool, buf, userID+1, webhookID, 10)
+	if err != data.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for webhook of another user, got %v", err)
+	}
+}
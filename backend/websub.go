@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// WebSub (https://www.w3.org/TR/websub/) lets a feed's hub push new content
+// instead of waiting for the next fetch. Subscriptions are requested when a
+// fetched feed advertises a hub and the hub calls back to the handler made by
+// NewWebSubHandler to verify them and to deliver content.
+const (
+	websubLeaseSeconds = 7 * 24 * 60 * 60
+
+	// websubRenewBefore is how long before a lease expires it is renewed.
+	websubRenewBefore = 24 * time.Hour
+
+	// websubRetryAfter is how long a subscription the hub has not verified is
+	// left pending before it is requested again.
+	websubRetryAfter = 24 * time.Hour
+
+	maxWebSubContentSize = 10 * 1024 * 1024
+)
+
+// webSubSubscription returns the WebSub subscription of feedID or nil if there
+// is none or WebSub is disabled.
+func (u *FeedUpdater) webSubSubscription(feedID int32) *data.WebSubSubscription {
+	if u.websubCallbackURL == "" {
+		return nil
+	}
+
+	subscription, err := data.SelectWebSubSubscription(context.Background(), u.pool, feedID)
+	if err != nil {
+		if err != data.ErrNotFound {
+			u.logger.Error("SelectWebSubSubscription failed", "id", feedID, "error", err)
+		}
+		return nil
+	}
+
+	return subscription
+}
+
+// maintainWebSubSubscription subscribes to the hub feed advertises unless the
+// existing subscription is for the same hub and topic and is either pending or
+// active past the renewal window. The topic is the self link of feed or
+// feedURL if it has none.
+func (u *FeedUpdater) maintainWebSubSubscription(feedID int32, feed *data.ParsedFeed, feedURL string, subscription *data.WebSubSubscription, now time.Time) {
+	if u.websubCallbackURL == "" || feed.HubURL == "" {
+		return
+	}
+
+	topicURL := feed.SelfURL
+	if topicURL == "" {
+		topicURL = feedURL
+	}
+
+	if subscription != nil && subscription.HubURL.String == feed.HubURL && subscription.TopicURL.String == topicURL {
+		if subscription.IsActive(now.Add(websubRenewBefore)) {
+			return
+		}
+		if subscription.LeaseExpirationTime.Status != pgtype.Present && now.Sub(subscription.RequestTime.Time) < websubRetryAfter {
+			return
+		}
+	}
+
+	if err := u.subscribeToHub(feedID, feed.HubURL, topicURL, now); err != nil {
+		u.logger.Error("subscribeToHub failed", "id", feedID, "hub", feed.HubURL, "topic", topicURL, "error", err)
+		return
+	}
+	u.logger.Info("subscribeToHub succeeded", "id", feedID, "hub", feed.HubURL, "topic", topicURL)
+}
+
+// subscribeToHub requests a subscription to topicURL from hubURL. The
+// subscription is pending until the hub verifies it.
+func (u *FeedUpdater) subscribeToHub(feedID int32, hubURL, topicURL string, now time.Time) error {
+	secret, err := genRandToken(20)
+	if err != nil {
+		return err
+	}
+
+	// Record the subscription first as the hub may verify it before responding
+	err = data.RequestWebSubSubscription(context.Background(), u.pool, feedID, hubURL, topicURL, secret, now)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {topicURL},
+		"hub.callback":      {u.webSubCallbackURL(feedID)},
+		"hub.secret":        {secret},
+		"hub.lease_seconds": {strconv.Itoa(websubLeaseSeconds)},
+	}
+
+	resp, err := u.client.PostForm(hubURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Bad HTTP response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (u *FeedUpdater) webSubCallbackURL(feedID int32) string {
+	return strings.TrimSuffix(u.websubCallbackURL, "/") + "/" + strconv.FormatInt(int64(feedID), 10)
+}
+
+// savePushedFeed stores the items of content a hub delivered for feedID. The
+// push replaces a fetch so the next fetch is scheduled from now. The cache
+// validators are cleared as they describe the last fetched version.
+func (u *FeedUpdater) savePushedFeed(feedID int32, feed *data.ParsedFeed) error {
+	now := time.Now()
+	nextFetchTime := now.Add(u.clampFetchInterval(u.pushFetchInterval))
+	nullString := pgtype.Varchar{Status: pgtype.Null}
+
+	err := data.UpdateFeedWithFetchSuccess(context.Background(), u.pool, feedID, feed, nullString, nullString, now, nextFetchTime)
+	return u.logFilterRulesError(feedID, err)
+}
+
+// NewWebSubHandler serves the callback URLs of WebSub subscriptions. The last
+// path segment is the feed ID. Hubs GET them to verify subscription requests
+// and POST them to deliver content.
+func NewWebSubHandler(u *FeedUpdater, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		feedID, err := strconv.ParseInt(path.Base(req.URL.Path), 10, 32)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+			verifyWebSubIntent(w, req, u, logger, int32(feedID))
+		case "POST":
+			receiveWebSubContent(w, req, u, logger, int32(feedID))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// verifyWebSubIntent confirms a subscription the hub is verifying by echoing
+// the challenge. Subscriptions that were not requested are not confirmed and
+// neither are unsubscriptions from subscriptions that are still wanted.
+func verifyWebSubIntent(w http.ResponseWriter, req *http.Request, u *FeedUpdater, logger log.Logger, feedID int32) {
+	ctx := context.Background()
+	topicURL := req.FormValue("hub.topic")
+	now := time.Now()
+
+	switch req.FormValue("hub.mode") {
+	case "subscribe":
+		leaseSeconds, err := strconv.ParseInt(req.FormValue("hub.lease_seconds"), 10, 32)
+		if err != nil || leaseSeconds <= 0 {
+			http.Error(w, `Parameter "hub.lease_seconds" must be a positive integer`, http.StatusBadRequest)
+			return
+		}
+
+		err = data.ActivateWebSubSubscription(ctx, u.pool, feedID, topicURL, now.Add(time.Duration(leaseSeconds)*time.Second))
+		if err == data.ErrNotFound {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			logger.Error("ActivateWebSubSubscription failed", "id", feedID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("WebSub subscription verified", "id", feedID, "topic", topicURL, "leaseSeconds", leaseSeconds)
+	case "unsubscribe":
+		subscription, err := data.SelectWebSubSubscription(ctx, u.pool, feedID)
+		if err == nil && subscription.TopicURL.String == topicURL {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil && err != data.ErrNotFound {
+			logger.Error("SelectWebSubSubscription failed", "id", feedID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	case "denied":
+		err := data.DeactivateWebSubSubscription(ctx, u.pool, feedID, topicURL, now)
+		if err != nil && err != data.ErrNotFound {
+			logger.Error("DeactivateWebSubSubscription failed", "id", feedID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Warn("WebSub subscription denied", "id", feedID, "topic", topicURL, "reason", req.FormValue("hub.reason"))
+		return
+	default:
+		http.Error(w, `Parameter "hub.mode" must be subscribe, unsubscribe, or denied`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, req.FormValue("hub.challenge"))
+}
+
+// receiveWebSubContent stores the items of content delivered by the hub.
+// Content with a missing or wrong signature is acknowledged but ignored as
+// required by the specification.
+func receiveWebSubContent(w http.ResponseWriter, req *http.Request, u *FeedUpdater, logger log.Logger, feedID int32) {
+	subscription, err := data.SelectWebSubSubscription(context.Background(), u.pool, feedID)
+	if err == data.ErrNotFound {
+		// Tells the hub the subscription no longer exists
+		http.Error(w, "Gone", http.StatusGone)
+		return
+	}
+	if err != nil {
+		logger.Error("SelectWebSubSubscription failed", "id", feedID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxWebSubContentSize+1))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebSubContentSize {
+		http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !validWebSubSignature(subscription.Secret.String, req.Header.Get("X-Hub-Signature"), body) {
+		logger.Warn("WebSub content has invalid signature", "id", feedID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	rawFeed := &rawFeed{url: subscription.TopicURL.String, body: body, contentType: req.Header.Get("Content-Type")}
+	feed, err := parseRawFeed(rawFeed)
+	if err != nil {
+		logger.Warn("parseFeed of WebSub content failed", "id", feedID, "error", err)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	sanitizeFeed(feed, rawFeed.url)
+
+	if err := u.savePushedFeed(feedID, feed); err != nil {
+		logger.Error("UpdateFeedWithFetchSuccess failed", "id", feedID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("WebSub content received", "id", feedID, "items", len(feed.Items))
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validWebSubSignature returns true if signature is the X-Hub-Signature of
+// body with secret. It is "method=signature" where method is sha1, sha256,
+// sha384, or sha512 and signature is the hex encoded HMAC of body.
+func validWebSubSignature(secret, signature string, body []byte) bool {
+	i := strings.Index(signature, "=")
+	if i < 0 {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(signature[:i]) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	sum, err := hex.DecodeString(signature[i+1:])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
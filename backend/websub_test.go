@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/tpr/backend/data"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestParseFeedWebSubLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		hubURL  string
+		selfURL string
+	}{
+		{
+			name: "RSS",
+			body: `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel><title>News</title>
+<atom:link rel="hub" href="http://hub.example.com/" />
+<atom:link rel="self" href="http://example.com/feed.rss" />
+<item><title>Snow</title><link>http://example.com/snow</link></item></channel></rss>`,
+			hubURL:  "http://hub.example.com/",
+			selfURL: "http://example.com/feed.rss",
+		},
+		{
+			name: "Atom",
+			body: `<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>
+<link rel="alternate" href="http://example.com/" />
+<link rel="hub" href="http://hub.example.com/" />
+<link rel="self" href="http://example.com/feed.atom" />
+<entry><title>Snow</title><link href="http://example.com/snow" /></entry></feed>`,
+			hubURL:  "http://hub.example.com/",
+			selfURL: "http://example.com/feed.atom",
+		},
+		{
+			name: "JSON Feed",
+			body: `{"version": "https://jsonfeed.org/version/1.1", "title": "News", "feed_url": "http://example.com/feed.json",
+"hubs": [{"type": "rssCloud", "url": "http://cloud.example.com/"}, {"type": "WebSub", "url": "http://hub.example.com/"}],
+"items": [{"id": "1", "url": "http://example.com/snow", "title": "Snow"}]}`,
+			hubURL:  "http://hub.example.com/",
+			selfURL: "http://example.com/feed.json",
+		},
+		{
+			name: "No hub",
+			body: `<rss version="2.0"><channel><title>News</title><item><title>Snow</title><link>http://example.com/snow</link></item></channel></rss>`,
+		},
+	}
+
+	for _, tt := range tests {
+		feed, err := parseFeed([]byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if feed.HubURL != tt.hubURL || feed.SelfURL != tt.selfURL {
+			t.Errorf("%s: Expected hub %q and self %q, got %q and %q", tt.name, tt.hubURL, tt.selfURL, feed.HubURL, feed.SelfURL)
+		}
+	}
+}
+
+func TestValidWebSubSignature(t *testing.T) {
+	body := []byte("content")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	sum := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		signature string
+		valid     bool
+	}{
+		{"sha256=" + sum, true},
+		{"SHA256=" + sum, true},
+		{"sha1=" + sum, false},
+		{"sha256=" + sum[2:], false},
+		{"md5=" + sum, false},
+		{sum, false},
+		{"", false},
+	}
+
+	for i, tt := range tests {
+		if valid := validWebSubSignature("secret", tt.signature, body); valid != tt.valid {
+			t.Errorf("%d. Expected %v, got %v", i, tt.valid, valid)
+		}
+	}
+}
+
+func TestWebSub(t *testing.T) {
+	pool := newConnPool(t)
+
+	userID, err := data.CreateUser(context.Background(), pool, newUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hubURL, feedURL string
+	feedBody := func(title string) string {
+		return fmt.Sprintf(`<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>
+<link rel="hub" href="%s" />
+<link rel="self" href="%s" />
+<entry><title>%s</title><link href="http://example.com/%s" /></entry></feed>`, hubURL, feedURL, title, strings.ToLower(title))
+	}
+
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(feedBody("Snow")))
+	}))
+	defer feedServer.Close()
+	feedURL = feedServer.URL + "/feed.atom"
+
+	u := NewFeedUpdater(pool, log.Root())
+
+	callbackServer := httptest.NewServer(NewWebSubHandler(u, log.Root()))
+	defer callbackServer.Close()
+	u.websubCallbackURL = callbackServer.URL + "/websub"
+
+	// The hub stand-in verifies subscriptions before responding like some real
+	// hubs do
+	subscriptions := make(chan url.Values, 1)
+	hubServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		verifyURL := r.PostForm.Get("hub.callback") + "?" + url.Values{
+			"hub.mode":          {"subscribe"},
+			"hub.topic":         {r.PostForm.Get("hub.topic")},
+			"hub.challenge":     {"challenge"},
+			"hub.lease_seconds": {r.PostForm.Get("hub.lease_seconds")},
+		}.Encode()
+		resp, err := http.Get(verifyURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "challenge" {
+			http.Error(w, "verification failed", http.StatusInternalServerError)
+			return
+		}
+
+		subscriptions <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hubServer.Close()
+	hubURL = hubServer.URL
+
+	err = data.InsertSubscription(context.Background(), pool, userID, feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedID, err := data.SelectFeedIDByURL(context.Background(), pool, feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.RefreshFeed(data.Feed{ID: pgtype.Int4{Int: feedID, Status: pgtype.Present}, URL: pgtype.Varchar{String: feedURL, Status: pgtype.Present}})
+
+	var form url.Values
+	select {
+	case form = <-subscriptions:
+	default:
+		t.Fatal("Expected subscription request to hub")
+	}
+	if form.Get("hub.mode") != "subscribe" || form.Get("hub.topic") != feedURL {
+		t.Fatalf("Unexpected subscription request: %v", form)
+	}
+
+	subscription, err := data.SelectWebSubSubscription(context.Background(), pool, feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !subscription.IsActive(time.Now()) {
+		t.Fatalf("Expected active subscription, got %v", subscription)
+	}
+
+	// Fetches of feeds with an active subscription are less frequent
+	u.RefreshFeed(data.Feed{ID: pgtype.Int4{Int: feedID, Status: pgtype.Present}, URL: pgtype.Varchar{String: feedURL, Status: pgtype.Present}})
+	feed, err := data.SelectFeedByPK(context.Background(), pool, feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval := feed.NextFetchTime.Time.Sub(feed.LastFetchTime.Time); interval < u.pushFetchInterval {
+		t.Errorf("Expected fetch interval of at least %v, got %v", u.pushFetchInterval, interval)
+	}
+	select {
+	case form = <-subscriptions:
+		t.Errorf("Expected no renewal of active subscription, got %v", form)
+	default:
+	}
+
+	push := func(body, secret string) {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+
+		req, err := http.NewRequest("POST", u.webSubCallbackURL(feedID), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/atom+xml")
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			t.Fatalf("Expected HTTP status 2xx, got %d", resp.StatusCode)
+		}
+	}
+
+	push(feedBody("Rain"), "wrong")
+	push(feedBody("Hail"), form.Get("hub.secret"))
+
+	buf := &strings.Builder{}
+	err = data.CopyItemsAsJSONByUserID(context.Background(), pool, buf, userID, data.ItemQuery{FeedID: feedID}, data.ItemPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Hail") || strings.Contains(buf.String(), "Rain") {
+		t.Errorf("Expected only signed content to be stored, got %s", buf.String())
+	}
+}
@@ -0,0 +1,9 @@
+alter table items add column content text;
+alter table items add column summary text;
+alter table items add column author varchar;
+
+---- create above / drop below ----
+
+alter table items drop column author;
+alter table items drop column summary;
+alter table items drop column content;
@@ -0,0 +1,19 @@
+create table enclosures(
+  id serial primary key,
+  item_id integer not null references items on delete cascade,
+  url varchar not null,
+  mime_type varchar,
+  length bigint,
+  duration integer check(duration >= 0),
+  thumbnail_url varchar,
+  unique(item_id, url)
+);
+
+comment on column enclosures.duration is 'play time in seconds';
+
+grant select, insert, update, delete on enclosures to {{.app_user}};
+grant usage on sequence enclosures_id_seq to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table enclosures;
@@ -0,0 +1,7 @@
+alter table feeds add column next_fetch_time timestamp with time zone;
+
+create index on feeds (next_fetch_time);
+
+---- create above / drop below ----
+
+alter table feeds drop column next_fetch_time;
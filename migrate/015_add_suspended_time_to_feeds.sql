@@ -0,0 +1,7 @@
+alter table feeds add column suspended_time timestamp with time zone;
+
+comment on column feeds.suspended_time is 'when the feed stopped being fetched because of repeated failures';
+
+---- create above / drop below ----
+
+alter table feeds drop column suspended_time;
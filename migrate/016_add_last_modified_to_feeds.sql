@@ -0,0 +1,7 @@
+alter table feeds add column last_modified varchar;
+
+comment on column feeds.last_modified is 'Last-Modified header of the last successful fetch to send as If-Modified-Since';
+
+---- create above / drop below ----
+
+alter table feeds drop column last_modified;
@@ -0,0 +1,20 @@
+create table folders(
+  id serial primary key,
+  user_id integer not null references users on delete cascade,
+  name varchar not null check(name <> '')
+);
+
+create unique index folders_user_id_name_unq on folders (user_id, lower(name));
+
+grant select, insert, update, delete on folders to {{.app_user}};
+grant usage on sequence folders_id_seq to {{.app_user}};
+
+alter table subscriptions add column folder_id integer references folders on delete set null;
+
+create index on subscriptions (folder_id);
+
+---- create above / drop below ----
+
+alter table subscriptions drop column folder_id;
+
+drop table folders;
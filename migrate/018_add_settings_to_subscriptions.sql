@@ -0,0 +1,12 @@
+alter table subscriptions add column name varchar check(name <> '');
+alter table subscriptions add column notes text;
+alter table subscriptions add column muted boolean not null default false;
+
+comment on column subscriptions.name is 'user chosen name that overrides feeds.name';
+comment on column subscriptions.muted is 'items of muted subscriptions are left out of the unread items';
+
+---- create above / drop below ----
+
+alter table subscriptions drop column muted;
+alter table subscriptions drop column notes;
+alter table subscriptions drop column name;
@@ -0,0 +1,16 @@
+create table starred_items(
+  user_id integer not null references users on delete cascade,
+  item_id integer not null references items on delete restrict,
+  starred_time timestamptz not null default now(),
+  primary key(user_id, item_id)
+);
+
+create index on starred_items (item_id);
+
+comment on table starred_items is 'items must not be deleted while they are starred';
+
+grant select, insert, update, delete on starred_items to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table starred_items;
@@ -0,0 +1,20 @@
+{{ template "func/item_search_vector_001.sql" . }}
+
+alter table items add column search_vector tsvector;
+
+update items set search_vector=item_search_vector(title, summary, content);
+
+create trigger items_set_search_vector
+  before insert or update of title, summary, content on items
+  for each row execute procedure items_set_search_vector();
+
+create index items_search_vector_idx on items using gin (search_vector);
+
+---- create above / drop below ----
+
+drop index items_search_vector_idx;
+drop trigger items_set_search_vector on items;
+alter table items drop column search_vector;
+drop function items_set_search_vector();
+drop function item_search_vector(varchar, text, text);
+drop function html_to_text(text);
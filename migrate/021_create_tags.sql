@@ -0,0 +1,27 @@
+create table tags(
+  id serial primary key,
+  user_id integer not null references users on delete cascade,
+  name varchar not null check(name <> '')
+);
+
+create unique index tags_user_id_name_unq on tags (user_id, lower(name));
+
+grant select, insert, update, delete on tags to {{.app_user}};
+grant usage on sequence tags_id_seq to {{.app_user}};
+
+create table item_tags(
+  tag_id integer not null references tags on delete cascade,
+  item_id integer not null references items on delete restrict,
+  primary key(tag_id, item_id)
+);
+
+create index on item_tags (item_id);
+
+comment on table item_tags is 'items must not be deleted while they are tagged';
+
+grant select, insert, update, delete on item_tags to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table item_tags;
+drop table tags;
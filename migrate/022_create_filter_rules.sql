@@ -0,0 +1,23 @@
+create table filter_rules(
+  id serial primary key,
+  user_id integer not null references users on delete cascade,
+  feed_id integer references feeds on delete cascade,
+  field varchar not null check(field in ('title', 'content', 'author', 'url')),
+  match_type varchar not null check(match_type in ('keyword', 'regex')),
+  pattern varchar not null check(pattern <> ''),
+  action varchar not null check(action in ('mark_read', 'star', 'tag')),
+  tag_id integer references tags on delete cascade,
+  check((action = 'tag') = (tag_id is not null))
+);
+
+create index on filter_rules (user_id);
+create index on filter_rules (feed_id);
+
+comment on column filter_rules.feed_id is 'null applies the rule to all feeds of the user';
+
+grant select, insert, update, delete on filter_rules to {{.app_user}};
+grant usage on sequence filter_rules_id_seq to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table filter_rules;
@@ -0,0 +1,15 @@
+create table deleted_items(
+  feed_id integer not null references feeds on delete cascade,
+  url varchar not null,
+  last_seen_time timestamptz not null default now(),
+  primary key(feed_id, url)
+);
+
+comment on table deleted_items is 'URLs of items removed by retention so they are not inserted again while the feed still lists them';
+comment on column deleted_items.last_seen_time is 'last time the URL was in a fetched feed or when it was deleted';
+
+grant select, insert, update, delete on deleted_items to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table deleted_items;
@@ -0,0 +1,14 @@
+create table fever_api_keys(
+  api_key varchar primary key,
+  user_id integer not null references users on delete cascade
+);
+
+create index on fever_api_keys (user_id);
+
+comment on table fever_api_keys is 'md5 of "login:password" for each login (name or email) of a user who enabled the Fever API';
+
+grant select, insert, update, delete on fever_api_keys to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table fever_api_keys;
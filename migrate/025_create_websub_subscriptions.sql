@@ -0,0 +1,18 @@
+create table websub_subscriptions(
+  feed_id integer primary key references feeds on delete cascade,
+  hub_url varchar not null,
+  topic_url varchar not null,
+  secret varchar not null,
+  request_time timestamptz not null default now(),
+  lease_expiration_time timestamptz
+);
+
+comment on table websub_subscriptions is 'WebSub (PubSubHubbub) subscriptions to the hubs feeds advertise';
+comment on column websub_subscriptions.secret is 'key of the HMAC signature of content distributed by the hub';
+comment on column websub_subscriptions.lease_expiration_time is 'null until the hub has verified the subscription';
+
+grant select, insert, update, delete on websub_subscriptions to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table websub_subscriptions;
@@ -0,0 +1,45 @@
+create table webhooks(
+  id serial primary key,
+  user_id integer not null references users on delete cascade,
+  url varchar not null check(url <> ''),
+  secret varchar not null check(secret <> ''),
+  feed_id integer references feeds on delete cascade,
+  folder_id integer references folders on delete cascade,
+  creation_time timestamptz not null default now(),
+  check(feed_id is null or folder_id is null)
+);
+
+create index on webhooks (user_id);
+create index on webhooks (feed_id);
+
+comment on table webhooks is 'URLs new items are posted to. A webhook without a feed or folder applies to all subscriptions';
+comment on column webhooks.secret is 'key of the HMAC signature of each delivery';
+
+create table webhook_deliveries(
+  id bigserial primary key,
+  webhook_id integer not null references webhooks on delete cascade,
+  payload json not null,
+  status varchar not null default 'pending' check(status in ('pending', 'delivered', 'failed')),
+  attempt_count integer not null default 0,
+  next_attempt_time timestamptz default now(),
+  last_attempt_time timestamptz,
+  response_status integer,
+  last_failure varchar,
+  creation_time timestamptz not null default now(),
+  check((status = 'pending') = (next_attempt_time is not null))
+);
+
+create index on webhook_deliveries (webhook_id, id);
+create index on webhook_deliveries (next_attempt_time) where status = 'pending';
+
+comment on table webhook_deliveries is 'POSTs of new items to webhooks and the log of their attempts';
+
+grant select, insert, update, delete on webhooks to {{.app_user}};
+grant usage on sequence webhooks_id_seq to {{.app_user}};
+grant select, insert, update, delete on webhook_deliveries to {{.app_user}};
+grant usage on sequence webhook_deliveries_id_seq to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table webhook_deliveries;
+drop table webhooks;
@@ -0,0 +1,35 @@
+create table digest_settings(
+  user_id integer primary key references users on delete cascade,
+  frequency varchar not null check(frequency in ('daily', 'weekly')),
+  time_zone varchar not null,
+  hour smallint not null check(hour between 0 and 23),
+  weekday smallint not null default 1 check(weekday between 0 and 6),
+  unsubscribe_token varchar not null unique,
+  next_send_time timestamptz not null,
+  last_send_time timestamptz,
+  creation_time timestamptz not null default now()
+);
+
+create index on digest_settings (next_send_time);
+
+comment on table digest_settings is 'users who opted in to periodic emails of their new unread items';
+comment on column digest_settings.hour is 'local hour in time_zone the digest is sent at';
+comment on column digest_settings.weekday is 'day of the week weekly digests are sent on, 0 is Sunday';
+
+create table digested_items(
+  user_id integer not null references users on delete cascade,
+  item_id integer not null references items on delete cascade,
+  primary key(user_id, item_id)
+);
+
+create index on digested_items (item_id);
+
+comment on table digested_items is 'items that were included in a digest of the user so they are not sent again';
+
+grant select, insert, update, delete on digest_settings to {{.app_user}};
+grant select, insert, update, delete on digested_items to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table digested_items;
+drop table digest_settings;
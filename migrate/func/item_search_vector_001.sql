@@ -0,0 +1,24 @@
+create function html_to_text(html text) returns text as
+$$
+  select regexp_replace(coalesce(html, ''), '<[^>]*>', ' ', 'g');
+$$
+language sql immutable;
+
+create function item_search_vector(title varchar, summary text, content text) returns tsvector as
+$$
+  select setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', html_to_text(coalesce(content, summary))), 'B');
+$$
+language sql immutable;
+
+create function items_set_search_vector() returns trigger as
+$$
+begin
+  new.search_vector := item_search_vector(new.title, new.summary, new.content);
+  return new;
+end;
+$$
+language plpgsql;
+
+grant execute on function html_to_text(text) to {{.app_user}};
+grant execute on function item_search_vector(varchar, text, text) to {{.app_user}};